@@ -1,6 +1,7 @@
 package iscsi
 
 import (
+	"github.com/garenwen/freebsd-manager/handle"
 	"github.com/garenwen/freebsd-manager/server/iscsiserver"
 	"github.com/spf13/cobra"
 )
@@ -16,14 +17,38 @@ var IscsiCmd = &cobra.Command{
 
 var (
 	tlsCertificate string
+	tlsKey         string
+	tlsCA          string
+	authFile       string
+	policyFile     string
+	auditLog       string
+	auditMaxSize   int64
+	auditMaxFiles  int
 )
 
 func init() {
 	flags := IscsiCmd.Flags()
 	flags.StringVar(&tlsCertificate, "tls-certificate", "", "the certificate to use for secure connections")
+	flags.StringVar(&tlsKey, "tls-key", "", "the private key of the certificate, if it is not in the certificate file")
+	flags.StringVar(&tlsCA, "tls-ca", "", "the CA that must have signed the certificates of clients; empty does not ask for client certificates")
+	flags.StringVar(&authFile, "auth-file", "", "the JSON file with the tokens, users and HMAC keys of callers, reloaded on changes; empty disables authentication")
+	flags.StringVar(&policyFile, "policy-file", "", "the JSON file with the roles of callers, reloaded on changes; empty allows every caller everything")
+	flags.StringVar(&auditLog, "audit-log", "", "the JSON lines file that logs the requests and the commands run for them, such as /var/log/freebsd-manager/iscsi-audit.log; empty disables auditing")
+	flags.Int64Var(&auditMaxSize, "audit-log-max-size", 100, "the size in megabytes at which the audit log is rotated")
+	flags.IntVar(&auditMaxFiles, "audit-log-max-files", 10, "the number of rotated audit logs to keep")
 
 }
 
 func run(*cobra.Command, []string) {
-	iscsiserver.NewIscsiServer().Start()
+	iscsiserver.NewIscsiServer(handle.Options{
+		SecureEnabled:    tlsCertificate != "",
+		TlsCert:          tlsCertificate,
+		TlsCertKey:       tlsKey,
+		TlsCA:            tlsCA,
+		AuthFile:         authFile,
+		PolicyFile:       policyFile,
+		AuditLog:         auditLog,
+		AuditLogMaxSize:  auditMaxSize << 20,
+		AuditLogMaxFiles: auditMaxFiles,
+	}).Start()
 }
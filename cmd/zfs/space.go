@@ -0,0 +1,53 @@
+package zfs
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/garenwen/freebsd-manager/pkg/zfs"
+	"github.com/spf13/cobra"
+)
+
+var SpaceCmd = &cobra.Command{
+	Use:     "space <dataset>",
+	Short:   "space report",
+	Long:    `report where the space below a dataset is used.`,
+	Example: "go run main.go zfs space tank",
+	Args:    cobra.ExactArgs(1),
+	RunE:    runSpace,
+}
+
+func init() {
+	ZfsCmd.AddCommand(SpaceCmd)
+}
+
+func runSpace(cmd *cobra.Command, args []string) error {
+	report, err := zfs.SpaceReport(args[0])
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tUSED\tAVAIL\tUSEDSNAP\tUSEDDS\tUSEDREFRESERV\tUSEDCHILD\tRATIO")
+	printSpaceNode(w, report, 0)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	t := report.Total
+	fmt.Printf("\ntotal: %s in snapshots, %s in datasets, %s in refreservations\n",
+		t.Snapshots, t.Datasets, t.Refreservation)
+	return nil
+}
+
+func printSpaceNode(w *tabwriter.Writer, n *zfs.SpaceNode, depth int) {
+	fmt.Fprintf(w, "%s%s\t%s\t%s\t%s\t%s\t%s\t%s\t%.2fx\n",
+		strings.Repeat("  ", depth), n.Name, n.Used, n.Avail,
+		n.UsedBySnapshots, n.UsedByDataset, n.UsedByRefreservation, n.UsedByChildren,
+		n.CompressRatio)
+	for _, c := range n.Children {
+		printSpaceNode(w, c, depth+1)
+	}
+}
@@ -1,6 +1,10 @@
 package zfs
 
 import (
+	"time"
+
+	"github.com/garenwen/freebsd-manager/handle"
+	"github.com/garenwen/freebsd-manager/pkg/zfs"
 	"github.com/garenwen/freebsd-manager/server/zfsserver"
 	"github.com/spf13/cobra"
 )
@@ -16,14 +20,49 @@ var ZfsCmd = &cobra.Command{
 
 var (
 	tlsCertificate string
+	tlsKey         string
+	tlsCA          string
+	cacheTTL       time.Duration
+	jobHistory     string
+	jobHistorySize int
+	authFile       string
+	policyFile     string
+	auditLog       string
+	auditMaxSize   int64
+	auditMaxFiles  int
 )
 
 func init() {
 	flags := ZfsCmd.Flags()
 	flags.StringVar(&tlsCertificate, "tls-certificate", "", "the certificate to use for secure connections")
+	flags.StringVar(&tlsKey, "tls-key", "", "the private key of the certificate, if it is not in the certificate file")
+	flags.StringVar(&tlsCA, "tls-ca", "", "the CA that must have signed the certificates of clients; empty does not ask for client certificates")
+	flags.DurationVar(&cacheTTL, "cache-ttl", 0, "how long to cache dataset and pool properties, 0 disables the cache")
+	flags.StringVar(&jobHistory, "job-history", "/var/db/freebsd-manager/jobs.json", "the file that keeps the history of asynchronous requests, empty keeps it in memory")
+	flags.IntVar(&jobHistorySize, "job-history-size", 1000, "the number of finished asynchronous requests to keep")
+	flags.StringVar(&authFile, "auth-file", "", "the JSON file with the tokens, users and HMAC keys of callers, reloaded on changes; empty disables authentication")
+	flags.StringVar(&policyFile, "policy-file", "", "the JSON file with the roles of callers, reloaded on changes; empty allows every caller everything")
+	flags.StringVar(&auditLog, "audit-log", "", "the JSON lines file that logs the requests and the commands run for them, such as /var/log/freebsd-manager/zfs-audit.log; empty disables auditing")
+	flags.Int64Var(&auditMaxSize, "audit-log-max-size", 100, "the size in megabytes at which the audit log is rotated")
+	flags.IntVar(&auditMaxFiles, "audit-log-max-files", 10, "the number of rotated audit logs to keep")
 
 }
 
 func run(*cobra.Command, []string) {
-	zfsserver.NewZfsServer().Start()
+	if cacheTTL > 0 {
+		zfs.EnableCache(cacheTTL)
+	}
+	zfsserver.NewZfsServer(handle.Options{
+		JobHistory:       jobHistory,
+		JobHistorySize:   jobHistorySize,
+		SecureEnabled:    tlsCertificate != "",
+		TlsCert:          tlsCertificate,
+		TlsCertKey:       tlsKey,
+		TlsCA:            tlsCA,
+		AuthFile:         authFile,
+		PolicyFile:       policyFile,
+		AuditLog:         auditLog,
+		AuditLogMaxSize:  auditMaxSize << 20,
+		AuditLogMaxFiles: auditMaxFiles,
+	}).Start()
 }
@@ -9,5 +9,6 @@ require (
 	github.com/pkg/errors v0.8.1
 	github.com/prometheus/common v0.4.0
 	github.com/spf13/cobra v1.1.3
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 	golang.org/x/sync v0.0.0-20190423024810-112230192c58
 )
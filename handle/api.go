@@ -18,6 +18,25 @@ type Options struct {
 	TlsCert       string
 	TlsCertKey    string
 	TlsCA         string
+
+	// AuthFile is the file with the credentials of the callers.  Requests
+	// are not authenticated if empty.
+	AuthFile string
+	// PolicyFile is the file with the roles of the callers.  Requests are
+	// not authorized if empty.
+	PolicyFile string
+
+	// AuditLog is the file that logs the requests and the commands run for
+	// them, which are not logged if empty.  It is rotated once it grows
+	// beyond AuditLogMaxSize bytes, keeping AuditLogMaxFiles old files.
+	AuditLog         string
+	AuditLogMaxSize  int64
+	AuditLogMaxFiles int
+
+	// JobHistory is the file that keeps the history of asynchronous
+	// requests, which is only kept in memory if empty.
+	JobHistory     string
+	JobHistorySize int
 }
 
 var (
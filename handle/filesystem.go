@@ -0,0 +1,237 @@
+package handle
+
+import (
+	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
+	"github.com/garenwen/freebsd-manager/pkg/zfs"
+	"github.com/gin-gonic/gin"
+)
+
+func newFilesystem(ds *zfs.Dataset) v1.Filesystem {
+	return v1.Filesystem{
+		Name:        ds.Name,
+		Origin:      ds.Origin,
+		Used:        ds.Used,
+		Avail:       ds.Avail,
+		Referenced:  ds.Referenced,
+		Quota:       ds.Quota,
+		Refquota:    ds.Refquota,
+		Mountpoint:  ds.Mountpoint,
+		Canmount:    ds.Canmount,
+		Mounted:     ds.Mounted,
+		Compression: ds.Compression,
+		Creation:    ds.Creation,
+	}
+}
+
+// quotaValue returns the property value of a quota, where 0 means none.
+func quotaValue(quota zfs.Bytes) string {
+	if quota == 0 {
+		return "none"
+	}
+	return quota.Exact()
+}
+
+func (zfsHandler *ZfsHandler) HandleCreateFilesystem(c *gin.Context) {
+
+	zfsHandler.run(c, zfsHandler.createFilesystem)
+}
+
+func (zfsHandler *ZfsHandler) createFilesystem(c *gin.Context) v1.BaseResult {
+
+	cfr := v1.CreateFilesystemRequest{}
+	if err := c.ShouldBindJSON(&cfr); err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}
+	}
+	if cfr.Name == "" {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: "name is required"}}
+	}
+
+	props := make(map[string]string, len(cfr.Properties)+4)
+	for key, val := range cfr.Properties {
+		props[key] = val
+	}
+	if cfr.Quota != 0 {
+		props["quota"] = cfr.Quota.Exact()
+	}
+	if cfr.Refquota != 0 {
+		props["refquota"] = cfr.Refquota.Exact()
+	}
+	if cfr.Mountpoint != "" {
+		props["mountpoint"] = cfr.Mountpoint
+	}
+	if cfr.Canmount != "" {
+		props["canmount"] = cfr.Canmount
+	}
+
+	fs, err := zfs.CreateFilesystemContext(c.Request.Context(), cfr.Name, props)
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: newFilesystem(fs), ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleListFilesystems(c *gin.Context) {
+
+	result := zfsHandler.listFilesystems(c)
+	c.JSON(statusCode(result), result)
+}
+
+func (zfsHandler *ZfsHandler) listFilesystems(c *gin.Context) v1.BaseResult {
+
+	datasets, err := zfs.FilesystemsContext(c.Request.Context(), c.Query("filter"))
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	filesystems := make([]v1.Filesystem, len(datasets))
+	for i, ds := range datasets {
+		filesystems[i] = newFilesystem(ds)
+	}
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: filesystems, ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleGetFilesystem(c *gin.Context) {
+
+	result := zfsHandler.getFilesystem(c)
+	c.JSON(statusCode(result), result)
+}
+
+func (zfsHandler *ZfsHandler) getFilesystem(c *gin.Context) v1.BaseResult {
+
+	fs, apiErr := lookupDataset(c, zfs.DatasetFilesystem)
+	if apiErr != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: apiErr}
+	}
+
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: newFilesystem(fs), ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleUpdateFilesystem(c *gin.Context) {
+
+	zfsHandler.run(c, zfsHandler.updateFilesystem)
+}
+
+func (zfsHandler *ZfsHandler) updateFilesystem(c *gin.Context) v1.BaseResult {
+
+	ufr := v1.UpdateFilesystemRequest{}
+	if err := c.ShouldBindJSON(&ufr); err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}
+	}
+
+	fs, apiErr := lookupDataset(c, zfs.DatasetFilesystem)
+	if apiErr != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: apiErr}
+	}
+
+	props := make(map[string]string, len(ufr.Properties)+4)
+	for key, val := range ufr.Properties {
+		props[key] = val
+	}
+	if ufr.Quota != nil {
+		props["quota"] = quotaValue(*ufr.Quota)
+	}
+	if ufr.Refquota != nil {
+		props["refquota"] = quotaValue(*ufr.Refquota)
+	}
+	if ufr.Mountpoint != "" {
+		props["mountpoint"] = ufr.Mountpoint
+	}
+	if ufr.Canmount != "" {
+		props["canmount"] = ufr.Canmount
+	}
+
+	for _, key := range ufr.Inherit {
+		if err := fs.InheritPropertyContext(c.Request.Context(), key); err != nil {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+		}
+	}
+	for key, val := range props {
+		if err := fs.SetPropertyContext(c.Request.Context(), key, val); err != nil {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+		}
+	}
+
+	fs, err := zfs.GetDatasetContext(c.Request.Context(), fs.Name)
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: newFilesystem(fs), ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleDeleteFilesystem(c *gin.Context) {
+
+	zfsHandler.run(c, zfsHandler.deleteFilesystem)
+}
+
+func (zfsHandler *ZfsHandler) deleteFilesystem(c *gin.Context) v1.BaseResult {
+
+	flags, err := destroyFlags(c)
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}
+	}
+
+	fs, apiErr := lookupDataset(c, zfs.DatasetFilesystem)
+	if apiErr != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: apiErr}
+	}
+	if err := fs.DestroyContext(c.Request.Context(), flags); err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: nil, ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleMountFilesystem(c *gin.Context) {
+
+	zfsHandler.run(c, zfsHandler.mountFilesystem)
+}
+
+func (zfsHandler *ZfsHandler) mountFilesystem(c *gin.Context) v1.BaseResult {
+
+	// the request body is optional
+	mfr := v1.MountFilesystemRequest{}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&mfr); err != nil {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}
+		}
+	}
+
+	fs, apiErr := lookupDataset(c, zfs.DatasetFilesystem)
+	if apiErr != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: apiErr}
+	}
+	fs, err := fs.MountContext(c.Request.Context(), mfr.Overlay, mfr.Options)
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: newFilesystem(fs), ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleUnmountFilesystem(c *gin.Context) {
+
+	zfsHandler.run(c, zfsHandler.unmountFilesystem)
+}
+
+func (zfsHandler *ZfsHandler) unmountFilesystem(c *gin.Context) v1.BaseResult {
+
+	// the request body is optional
+	ufr := v1.UnmountFilesystemRequest{}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&ufr); err != nil {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}
+		}
+	}
+
+	fs, apiErr := lookupDataset(c, zfs.DatasetFilesystem)
+	if apiErr != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: apiErr}
+	}
+	fs, err := fs.UnmountContext(c.Request.Context(), ufr.Force)
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: newFilesystem(fs), ApiError: nil}
+}
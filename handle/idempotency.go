@@ -0,0 +1,116 @@
+package handle
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
+)
+
+// IdempotencyKeyHeader is the header that makes retries of a mutating request
+// safe.  A request with the key of an earlier request gets the response of
+// the earlier one, without being processed again.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const (
+	// idempotencyTTL is how long the response to a key is kept.
+	idempotencyTTL = 24 * time.Hour
+	// idempotencyLimit is the number of keys kept, the oldest are dropped
+	// beyond it.
+	idempotencyLimit = 10000
+)
+
+var errIdempotencyMismatch = errors.New("idempotency key was used for a different request")
+
+// idempotentResponse is the response to the first request with a key.
+type idempotentResponse struct {
+	key         string
+	fingerprint string
+	// done is closed once the response is recorded
+	done    chan struct{}
+	code    int
+	result  v1.BaseResult
+	expires time.Time
+}
+
+// wait returns the response once it is recorded.  It fails if fingerprint
+// does not match the recorded request.
+func (r *idempotentResponse) wait(ctx context.Context, fingerprint string) (int, v1.BaseResult, error) {
+	if fingerprint != r.fingerprint {
+		return 0, v1.BaseResult{}, errIdempotencyMismatch
+	}
+	select {
+	case <-r.done:
+		return r.code, r.result, nil
+	case <-ctx.Done():
+		return 0, v1.BaseResult{}, ctx.Err()
+	}
+}
+
+type idempotencyCache struct {
+	mu        sync.Mutex
+	responses map[string]*idempotentResponse
+	order     []*idempotentResponse // oldest first
+}
+
+func newIdempotencyCache() *idempotencyCache {
+	return &idempotencyCache{responses: make(map[string]*idempotentResponse)}
+}
+
+// begin returns the response recorded for key and true, or a new response to
+// be recorded with finish and false.  Retries wait for the response of a
+// request that is still being processed.
+func (ic *idempotencyCache) begin(key, fingerprint string) (*idempotentResponse, bool) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	now := time.Now()
+	if r, ok := ic.responses[key]; ok && (r.expires.IsZero() || now.Before(r.expires)) {
+		return r, true
+	}
+
+	r := &idempotentResponse{key: key, fingerprint: fingerprint, done: make(chan struct{})}
+	ic.responses[key] = r
+	ic.order = append(ic.order, r)
+	ic.expire(now)
+	return r, false
+}
+
+// finish records the response to the first request with key.  Responses to
+// errors that may be transient are not kept, so that a retry is processed.
+func (ic *idempotencyCache) finish(key string, r *idempotentResponse, code int, result v1.BaseResult) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	r.code, r.result = code, result
+	r.expires = time.Now().Add(idempotencyTTL)
+	close(r.done)
+	if result.ApiError != nil {
+		switch result.ApiError.Typ {
+		case v1.ErrorInternal, v1.ErrorUnavailable, v1.ErrorTimeout, v1.ErrorCanceled:
+			if ic.responses[key] == r {
+				delete(ic.responses, key)
+			}
+		}
+	}
+}
+
+// expire drops expired responses and the oldest ones beyond the limit.
+func (ic *idempotencyCache) expire(now time.Time) {
+	kept := ic.order[:0]
+	for i, r := range ic.order {
+		if ic.responses[r.key] != r {
+			// replaced or dropped
+			continue
+		}
+		finished := !r.expires.IsZero()
+		if finished && (now.After(r.expires) || len(ic.order)-i > idempotencyLimit) {
+			delete(ic.responses, r.key)
+			continue
+		}
+		kept = append(kept, r)
+	}
+	ic.order = kept
+}
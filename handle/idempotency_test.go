@@ -0,0 +1,123 @@
+package handle
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
+)
+
+var okResult = v1.BaseResult{Status: v1.StatusSuccess, Data: "created"}
+
+func TestIdempotencyCacheBegin(t *testing.T) {
+	ic := newIdempotencyCache()
+
+	r, recorded := ic.begin("key", "POST /volumes a")
+	if recorded {
+		t.Fatal("new key was recorded")
+	}
+	retry, recorded := ic.begin("key", "POST /volumes a")
+	if !recorded || retry != r {
+		t.Fatal("retry did not get the response of the first request")
+	}
+
+	ic.finish("key", r, http.StatusOK, okResult)
+	code, result, err := retry.wait(context.Background(), "POST /volumes a")
+	if err != nil || code != http.StatusOK || result.Data != "created" {
+		t.Errorf("got %d %+v %v", code, result, err)
+	}
+	if _, _, err := retry.wait(context.Background(), "POST /volumes b"); err != errIdempotencyMismatch {
+		t.Errorf("got %v for a different request", err)
+	}
+
+	// expired responses are dropped
+	r.expires = time.Now().Add(-time.Second)
+	if _, recorded := ic.begin("key", "POST /volumes a"); recorded {
+		t.Error("expired response was returned")
+	}
+}
+
+func TestIdempotencyCacheWaiters(t *testing.T) {
+	ic := newIdempotencyCache()
+	r, _ := ic.begin("key", "fp")
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, recorded := ic.begin("key", "fp")
+			if !recorded {
+				t.Error("concurrent retry was not recorded")
+				return
+			}
+			code, _, err := resp.wait(context.Background(), "fp")
+			if err != nil {
+				t.Error(err)
+			}
+			codes <- code
+		}()
+	}
+
+	// waiters give up with their context
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := r.wait(ctx, "fp"); err != context.DeadlineExceeded {
+		t.Errorf("got %v while the response is pending", err)
+	}
+
+	ic.finish("key", r, http.StatusCreated, okResult)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusCreated {
+			t.Errorf("waiter got %d", code)
+		}
+	}
+}
+
+func TestIdempotencyCacheTransientErrors(t *testing.T) {
+	ic := newIdempotencyCache()
+	for _, test := range []struct {
+		typ  v1.ApiError
+		kept bool
+	}{
+		{v1.ApiError{Typ: v1.ErrorInternal}, false},
+		{v1.ApiError{Typ: v1.ErrorUnavailable}, false},
+		{v1.ApiError{Typ: v1.ErrorTimeout}, false},
+		{v1.ApiError{Typ: v1.ErrorCanceled}, false},
+		{v1.ApiError{Typ: v1.ErrorAlreadyExists}, true},
+		{v1.ApiError{Typ: v1.ErrorBadData}, true},
+	} {
+		key := string(test.typ.Typ)
+		r, _ := ic.begin(key, "fp")
+		apiErr := test.typ
+		ic.finish(key, r, http.StatusInternalServerError, v1.BaseResult{Status: v1.StatusError, ApiError: &apiErr})
+		if _, recorded := ic.begin(key, "fp"); recorded != test.kept {
+			t.Errorf("%s: kept %v, want %v", key, recorded, test.kept)
+		}
+	}
+}
+
+func TestIdempotencyCacheLimit(t *testing.T) {
+	ic := newIdempotencyCache()
+	for i := 0; i <= idempotencyLimit+1; i++ {
+		key := fmt.Sprint(i)
+		r, _ := ic.begin(key, "fp")
+		ic.finish(key, r, http.StatusOK, okResult)
+	}
+	if n := len(ic.responses); n > idempotencyLimit {
+		t.Errorf("kept %d responses", n)
+	}
+	if _, ok := ic.responses["0"]; ok {
+		t.Error("oldest response was kept")
+	}
+	if _, ok := ic.responses[fmt.Sprint(idempotencyLimit+1)]; !ok {
+		t.Error("newest response was dropped")
+	}
+}
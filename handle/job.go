@@ -0,0 +1,85 @@
+package handle
+
+import (
+	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
+	"github.com/garenwen/freebsd-manager/pkg/auth"
+	"github.com/garenwen/freebsd-manager/pkg/jobs"
+	"github.com/gin-gonic/gin"
+)
+
+func (zfsHandler *ZfsHandler) HandleListJobs(c *gin.Context) {
+
+	result := zfsHandler.listJobs(c)
+	c.JSON(statusCode(result), result)
+}
+
+func (zfsHandler *ZfsHandler) listJobs(c *gin.Context) v1.BaseResult {
+
+	if zfsHandler.jobs == nil {
+		return v1.BaseResult{Status: v1.StatusSuccess, Data: []v1.Job{}, ApiError: nil}
+	}
+	list := zfsHandler.jobs.List()
+	if !auth.Unscoped(c) {
+		owned := []v1.Job{}
+		for _, job := range list {
+			if ownJob(c, job) {
+				owned = append(owned, job)
+			}
+		}
+		list = owned
+	}
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: list, ApiError: nil}
+}
+
+// ownJob reports whether the caller of a request may see and cancel a job:
+// their own, or any job if they are authorized for jobs of all datasets.
+func ownJob(c *gin.Context, job v1.Job) bool {
+	return auth.Unscoped(c) || job.Owner == auth.Subject(c)
+}
+
+func (zfsHandler *ZfsHandler) HandleGetJob(c *gin.Context) {
+
+	result := zfsHandler.getJob(c)
+	c.JSON(statusCode(result), result)
+}
+
+func (zfsHandler *ZfsHandler) getJob(c *gin.Context) v1.BaseResult {
+
+	if zfsHandler.jobs == nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorNotFound, Msg: jobs.ErrNotFound.Error()}}
+	}
+	job, ok := zfsHandler.jobs.Get(c.Param("id"))
+	// the jobs of other callers are not disclosed
+	if !ok || !ownJob(c, job) {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorNotFound, Msg: jobs.ErrNotFound.Error()}}
+	}
+
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: job, ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleCancelJob(c *gin.Context) {
+
+	result := zfsHandler.cancelJob(c)
+	c.JSON(statusCode(result), result)
+}
+
+func (zfsHandler *ZfsHandler) cancelJob(c *gin.Context) v1.BaseResult {
+
+	if zfsHandler.jobs == nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorNotFound, Msg: jobs.ErrNotFound.Error()}}
+	}
+	job, err := zfsHandler.jobs.CancelIf(c.Param("id"), func(job v1.Job) bool {
+		return ownJob(c, job)
+	})
+	switch err {
+	case nil:
+	case jobs.ErrNotAllowed:
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorPermission, Msg: auth.Subject(c) + " may not cancel the jobs of " + job.Owner}}
+	case jobs.ErrNotFound:
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorNotFound, Msg: err.Error()}}
+	default:
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}
+	}
+
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: job, ApiError: nil}
+}
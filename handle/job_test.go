@@ -0,0 +1,78 @@
+package handle
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
+	"github.com/garenwen/freebsd-manager/pkg/auth"
+	"github.com/garenwen/freebsd-manager/pkg/jobs"
+	"github.com/gin-gonic/gin"
+)
+
+func TestJobOwners(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m, err := jobs.NewManager("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	running := func(ctx context.Context) v1.BaseResult {
+		<-ctx.Done()
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorCanceled, Msg: ctx.Err().Error()}}
+	}
+	own := m.Submit("POST /test", "token:team-a", running)
+	other := m.Submit("POST /test", "token:team-b", running)
+	defer m.Cancel(own.Id)
+	defer m.Cancel(other.Id)
+
+	h := NewZfsHandler(m)
+	route := gin.New()
+	route.Use(auth.Middleware(headerAuthenticator{}))
+	// without Authorize, callers are scoped to their own jobs
+	route.GET("/jobs", h.HandleListJobs)
+	route.GET("/jobs/:id", h.HandleGetJob)
+	route.POST("/jobs/:id/cancel", h.HandleCancelJob)
+	route.GET("/all/jobs", func(c *gin.Context) { auth.SetUnscoped(c, true) }, h.HandleListJobs)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-Caller", "team-a")
+		w := httptest.NewRecorder()
+		route.ServeHTTP(w, req)
+		return w
+	}
+	list := func(path string) []v1.Job {
+		var result struct {
+			Data []v1.Job `json:"data"`
+		}
+		if err := json.Unmarshal(do("GET", path).Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result.Data
+	}
+
+	if got := list("/jobs"); len(got) != 1 || got[0].Id != own.Id {
+		t.Errorf("listed %+v, want only the own job", got)
+	}
+	if got := list("/all/jobs"); len(got) != 2 {
+		t.Errorf("unscoped caller listed %d jobs", len(got))
+	}
+	if w := do("GET", "/jobs/"+own.Id); w.Code != http.StatusOK {
+		t.Errorf("own job: got %d", w.Code)
+	}
+	if w := do("GET", "/jobs/"+other.Id); w.Code != http.StatusNotFound {
+		t.Errorf("job of another caller: got %d", w.Code)
+	}
+	if w := do("POST", "/jobs/"+other.Id+"/cancel"); w.Code != http.StatusForbidden {
+		t.Errorf("canceling the job of another caller: got %d", w.Code)
+	}
+	if job, _ := m.Get(other.Id); job.State != v1.JobRunning {
+		t.Errorf("job of another caller is %s", job.State)
+	}
+	if w := do("POST", "/jobs/"+own.Id+"/cancel"); w.Code != http.StatusOK {
+		t.Errorf("canceling the own job: got %d", w.Code)
+	}
+}
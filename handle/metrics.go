@@ -0,0 +1,34 @@
+package handle
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/garenwen/freebsd-manager/pkg/zfs"
+	"github.com/gin-gonic/gin"
+)
+
+// HandleMetrics exposes the dataset lock and property cache statistics in the
+// Prometheus text exposition format.
+func (zfsHandler *ZfsHandler) HandleMetrics(c *gin.Context) {
+	stats := zfs.GetLockStats()
+	cacheStats := zfs.GetCacheStats()
+
+	var b strings.Builder
+	writeMetric(&b, "fm_zfs_lock_acquisitions_total", "counter", "Dataset locks acquired.", float64(stats.Acquisitions))
+	writeMetric(&b, "fm_zfs_lock_contentions_total", "counter", "Dataset locks that waited for a conflicting lock.", float64(stats.Contentions))
+	writeMetric(&b, "fm_zfs_lock_wait_seconds_total", "counter", "Time spent waiting for dataset locks.", stats.WaitTime.Seconds())
+	writeMetric(&b, "fm_zfs_lock_wait_seconds_max", "gauge", "Longest time spent waiting for a dataset lock.", stats.MaxWaitTime.Seconds())
+	writeMetric(&b, "fm_zfs_locks_held", "gauge", "Dataset locks currently held.", float64(stats.Held))
+	writeMetric(&b, "fm_zfs_locks_waiting", "gauge", "Dataset locks currently waited for.", float64(stats.Waiting))
+	writeMetric(&b, "fm_zfs_cache_hits_total", "counter", "Property lookups served from the cache.", float64(cacheStats.Hits))
+	writeMetric(&b, "fm_zfs_cache_misses_total", "counter", "Property lookups not served from the cache.", float64(cacheStats.Misses))
+	writeMetric(&b, "fm_zfs_cache_entries", "gauge", "Datasets and zpools currently cached.", float64(cacheStats.Entries))
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
+
+func writeMetric(b *strings.Builder, name, typ, help string, value float64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, typ, name, value)
+}
@@ -0,0 +1,143 @@
+package handle
+
+import (
+	"context"
+	"time"
+
+	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
+	"github.com/garenwen/freebsd-manager/pkg/jobs"
+	"github.com/garenwen/freebsd-manager/pkg/zfs"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+)
+
+func newPool(z *zfs.Zpool) v1.Pool {
+	p := v1.Pool{
+		Name:          z.Name,
+		Health:        z.Health,
+		Size:          z.Size,
+		Allocated:     z.Allocated,
+		Free:          z.Free,
+		Fragmentation: z.Fragmentation,
+		DedupRatio:    z.DedupRatio,
+		ReadOnly:      z.ReadOnly,
+		Freeing:       z.Freeing,
+		Leaked:        z.Leaked,
+	}
+	if z.Size != 0 {
+		p.Capacity = uint64(z.Allocated * 100 / z.Size)
+	}
+	return p
+}
+
+func (zfsHandler *ZfsHandler) HandleListPools(c *gin.Context) {
+
+	result := zfsHandler.listPools(c)
+	c.JSON(statusCode(result), result)
+}
+
+func (zfsHandler *ZfsHandler) listPools(c *gin.Context) v1.BaseResult {
+
+	zpools, err := zfs.ListZpoolsContext(c.Request.Context())
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	pools := make([]v1.Pool, len(zpools))
+	for i, z := range zpools {
+		pools[i] = newPool(z)
+	}
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: pools, ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleGetPool(c *gin.Context) {
+
+	result := zfsHandler.getPool(c)
+	c.JSON(statusCode(result), result)
+}
+
+func (zfsHandler *ZfsHandler) getPool(c *gin.Context) v1.BaseResult {
+
+	z, err := zfs.GetZpoolContext(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: newPool(z), ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleGetPoolStatus(c *gin.Context) {
+
+	result := zfsHandler.getPoolStatus(c)
+	c.JSON(statusCode(result), result)
+}
+
+func (zfsHandler *ZfsHandler) getPoolStatus(c *gin.Context) v1.BaseResult {
+
+	z := &zfs.Zpool{Name: c.Param("name")}
+	status, err := z.StatusContext(c.Request.Context())
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: status, ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleScrubPool(c *gin.Context) {
+
+	zfsHandler.run(c, zfsHandler.scrubPool)
+}
+
+// scrubPool starts a scrub, and waits for it to finish if the wait query
+// parameter is set.  A canceled wait cancels the scrub.
+func (zfsHandler *ZfsHandler) scrubPool(c *gin.Context) v1.BaseResult {
+
+	wait, err := queryBool(c, "wait")
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}
+	}
+
+	z := &zfs.Zpool{Name: c.Param("name")}
+	if err := z.ScrubContext(c.Request.Context()); err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+	if !wait {
+		return v1.BaseResult{Status: v1.StatusSuccess, Data: nil, ApiError: nil}
+	}
+
+	ctx := c.Request.Context()
+	done := make(chan struct{})
+	defer close(done)
+	go reportScrubProgress(ctx, z, done)
+
+	if err := zfs.WaitFor(ctx, z.Name, zfs.WaitScrub); err != nil {
+		if ctx.Err() != nil {
+			if err := z.StopScrubContext(withoutCancel(ctx)); err != nil {
+				glog.Errorln("failed to stop scrub of", z.Name, err)
+			}
+			return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorCanceled, Msg: err.Error()}}
+		}
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	status, err := z.StatusContext(ctx)
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: status.Scan, ApiError: nil}
+}
+
+// reportScrubProgress reports the progress of a scrub to the job running
+// with ctx, until done is closed.
+func reportScrubProgress(ctx context.Context, z *zfs.Zpool, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(zfs.WaitPollInterval):
+		}
+		if status, err := z.StatusContext(ctx); err == nil && status.Scan.State == zfs.ScanScanning {
+			jobs.SetProgress(ctx, status.Scan.Progress)
+		}
+	}
+}
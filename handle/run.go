@@ -0,0 +1,192 @@
+package handle
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
+	"github.com/garenwen/freebsd-manager/pkg/audit"
+	"github.com/garenwen/freebsd-manager/pkg/auth"
+	"github.com/garenwen/freebsd-manager/pkg/jobs"
+	"github.com/garenwen/freebsd-manager/pkg/zfs"
+	"github.com/gin-gonic/gin"
+)
+
+// statusCode returns the HTTP status code of a result.
+func statusCode(result v1.BaseResult) int {
+	if result.ApiError == nil {
+		return http.StatusOK
+	}
+	switch result.ApiError.Typ {
+	case v1.ErrorBadData:
+		return http.StatusBadRequest
+	case v1.ErrorUnauthorized:
+		return http.StatusUnauthorized
+	case v1.ErrorPermission:
+		return http.StatusForbidden
+	case v1.ErrorNotFound:
+		return http.StatusNotFound
+	case v1.ErrorAlreadyExists, v1.ErrorBusy, v1.ErrorHasDependents:
+		return http.StatusConflict
+	case v1.ErrorUnavailable:
+		return http.StatusServiceUnavailable
+	case v1.ErrorTimeout:
+		return http.StatusGatewayTimeout
+	case v1.ErrorNoSpace:
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
+
+// zfsError returns the API error for an error of a zfs or zpool command, typed
+// by the cause the command reported.
+func zfsError(err error) *v1.ApiError {
+	typ := v1.ErrorExec
+	switch {
+	case errors.Is(err, zfs.ErrNotFound):
+		typ = v1.ErrorNotFound
+	case errors.Is(err, zfs.ErrAlreadyExists):
+		typ = v1.ErrorAlreadyExists
+	case errors.Is(err, zfs.ErrBusy):
+		typ = v1.ErrorBusy
+	case errors.Is(err, zfs.ErrNoSpace):
+		typ = v1.ErrorNoSpace
+	case errors.Is(err, zfs.ErrPermission):
+		typ = v1.ErrorPermission
+	case errors.Is(err, zfs.ErrHasDependents):
+		typ = v1.ErrorHasDependents
+	case errors.Is(err, zfs.ErrInvalidProperty):
+		typ = v1.ErrorBadData
+	case errors.Is(err, zfs.ErrPoolUnavailable):
+		typ = v1.ErrorUnavailable
+	}
+	return &v1.ApiError{Typ: typ, Msg: err.Error()}
+}
+
+// async reports whether the client asked for an asynchronous response, with
+// the async query parameter or a Prefer: respond-async header.
+func async(c *gin.Context) bool {
+	if set, _ := queryBool(c, "async"); set {
+		return true
+	}
+	for _, prefer := range c.Request.Header["Prefer"] {
+		for _, pref := range strings.Split(prefer, ",") {
+			if strings.TrimSpace(pref) == "respond-async" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// maxBodySize is the size up to which the JSON bodies of mutating requests are
+// read.
+const maxBodySize = 1 << 20
+
+// run answers a mutating request with the result of fn.
+//
+// If the client asked for an asynchronous response, fn is run as a job
+// instead, and the job is returned with 202 Accepted.  fn is canceled through
+// the context of the request.
+//
+// If the request has an Idempotency-Key header, the response is recorded,
+// and returned again for retries with the same key, without running fn again.
+func (zfsHandler *ZfsHandler) run(c *gin.Context, fn func(*gin.Context) v1.BaseResult) {
+	// the body is read beforehand, as the job and the idempotency key need it
+	body, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize))
+	if err != nil {
+		code := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			code = http.StatusRequestEntityTooLarge
+		}
+		c.JSON(code, v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}})
+		return
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	key := c.GetHeader(IdempotencyKeyHeader)
+	if key == "" {
+		code, result := zfsHandler.runOnce(c, body, fn)
+		c.JSON(code, result)
+		return
+	}
+	// keys are scoped by caller, who must not get the responses of others
+	key = auth.Subject(c) + "\x00" + key
+
+	sum := sha256.Sum256(body)
+	fingerprint := c.Request.Method + " " + c.Request.URL.RequestURI() + " " + hex.EncodeToString(sum[:])
+	resp, recorded := zfsHandler.idempotency.begin(key, fingerprint)
+	if recorded {
+		code, result, err := resp.wait(c.Request.Context(), fingerprint)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}})
+			return
+		}
+		c.Header("Idempotent-Replayed", "true")
+		if job, ok := result.Data.(v1.Job); ok {
+			c.Header("Location", jobLocation(job.Id))
+		}
+		c.JSON(code, result)
+		return
+	}
+
+	code, result := http.StatusInternalServerError, v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorInternal, Msg: "request failed"}}
+	defer func() {
+		zfsHandler.idempotency.finish(key, resp, code, result)
+	}()
+	code, result = zfsHandler.runOnce(c, body, fn)
+	c.JSON(code, result)
+}
+
+// detachedContext has the values of a context, but is never done.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// withoutCancel returns a context for cleaning up after a canceled request,
+// which keeps its request id.
+func withoutCancel(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+func jobLocation(id string) string {
+	return "/apis/storage/v1/jobs/" + id
+}
+
+// runOnce runs fn for the request, or submits it as a job if the client asked
+// for an asynchronous response.
+func (zfsHandler *ZfsHandler) runOnce(c *gin.Context, body []byte, fn func(*gin.Context) v1.BaseResult) (int, v1.BaseResult) {
+	if zfsHandler.jobs == nil || !async(c) {
+		result := fn(c)
+		return statusCode(result), result
+	}
+
+	// the context is reused once the request is answered, so the job works
+	// on a copy
+	cp := c.Copy()
+	operation := c.Request.Method + " " + c.Request.URL.EscapedPath()
+	job := zfsHandler.jobs.Submit(operation, auth.Subject(c), func(ctx context.Context) v1.BaseResult {
+		// the commands of the job are audited with the request
+		ctx, done := audit.Track(ctx, cp, jobs.ID(ctx))
+		cp.Request = cp.Request.WithContext(ctx)
+		cp.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		result := fn(cp)
+		done(result)
+		return result
+	})
+
+	c.Header("Location", jobLocation(job.Id))
+	return http.StatusAccepted, v1.BaseResult{Status: v1.StatusSuccess, Data: job, ApiError: nil}
+}
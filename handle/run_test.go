@@ -0,0 +1,88 @@
+package handle
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
+	"github.com/garenwen/freebsd-manager/pkg/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// headerAuthenticator authenticates callers by the X-Caller header.
+type headerAuthenticator struct{}
+
+func (headerAuthenticator) Authenticate(r *http.Request) (*auth.Identity, error) {
+	if name := r.Header.Get("X-Caller"); name != "" {
+		return &auth.Identity{Name: name, Method: auth.MethodToken}, nil
+	}
+	return nil, auth.ErrUnauthenticated
+}
+
+func TestRunIdempotencyKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewZfsHandler(nil)
+	var calls int32
+	route := gin.New()
+	route.Use(auth.Middleware(headerAuthenticator{}))
+	route.POST("/volumes", func(c *gin.Context) {
+		h.run(c, func(c *gin.Context) v1.BaseResult {
+			n := atomic.AddInt32(&calls, 1)
+			return v1.BaseResult{Status: v1.StatusSuccess, Data: fmt.Sprint(n)}
+		})
+	})
+
+	post := func(caller, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/volumes", strings.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, "key-1")
+		req.Header.Set("X-Caller", caller)
+		w := httptest.NewRecorder()
+		route.ServeHTTP(w, req)
+		return w
+	}
+
+	first := post("team-a", `{"name":"tank/a"}`)
+	retry := post("team-a", `{"name":"tank/a"}`)
+	if retry.Body.String() != first.Body.String() || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry got %s, want %s", retry.Body.String(), first.Body.String())
+	}
+	if w := post("team-a", `{"name":"tank/b"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key got %d", w.Code)
+	}
+	// the key of another caller is a different key
+	if w := post("team-b", `{"name":"tank/a"}`); w.Header().Get("Idempotent-Replayed") != "" || !strings.Contains(w.Body.String(), `"2"`) {
+		t.Errorf("other caller got %s", w.Body.String())
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times", calls)
+	}
+}
+
+func TestRunBodySize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewZfsHandler(nil)
+	route := gin.New()
+	route.POST("/volumes", func(c *gin.Context) {
+		h.run(c, func(c *gin.Context) v1.BaseResult {
+			return v1.BaseResult{Status: v1.StatusSuccess}
+		})
+	})
+
+	for _, test := range []struct {
+		size int
+		code int
+	}{
+		{maxBodySize, http.StatusOK},
+		{maxBodySize + 1, http.StatusRequestEntityTooLarge},
+	} {
+		w := httptest.NewRecorder()
+		route.ServeHTTP(w, httptest.NewRequest("POST", "/volumes", strings.NewReader(strings.Repeat(" ", test.size))))
+		if w.Code != test.code {
+			t.Errorf("%d bytes: got %d, want %d", test.size, w.Code, test.code)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package handle
+
+import (
+	"errors"
+	"strings"
+
+	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
+	"github.com/garenwen/freebsd-manager/pkg/zfs"
+	"github.com/gin-gonic/gin"
+)
+
+func newSnapshot(ds *zfs.Dataset) v1.Snapshot {
+	size := ds.Volsize
+	if size == 0 {
+		size = ds.Referenced
+	}
+	return v1.Snapshot{
+		SnapshotId:     ds.Name,
+		SourceVolumeId: ds.Name[:strings.Index(ds.Name, "@")],
+		SizeBytes:      size,
+		Used:           ds.Used,
+		CreationTime:   ds.Creation,
+		ReadyToUse:     true,
+	}
+}
+
+func (zfsHandler *ZfsHandler) HandleCreateSnapshot(c *gin.Context) {
+
+	zfsHandler.run(c, zfsHandler.createSnapshot)
+}
+
+func (zfsHandler *ZfsHandler) createSnapshot(c *gin.Context) v1.BaseResult {
+
+	csr := v1.CreateSnapshotRequest{}
+	if err := c.ShouldBindJSON(&csr); err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}
+	}
+	if csr.SourceVolumeId == "" || csr.Name == "" {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: "source_volume_id and name are required"}}
+	}
+
+	// creating an existing snapshot succeeds, as in CSI
+	if snap, err := zfs.GetDatasetContext(c.Request.Context(), csr.SourceVolumeId+"@"+csr.Name); err == nil {
+		return v1.BaseResult{Status: v1.StatusSuccess, Data: newSnapshot(snap), ApiError: nil}
+	}
+
+	ds, err := zfs.GetDatasetContext(c.Request.Context(), csr.SourceVolumeId)
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+	snap, err := ds.SnapshotContext(c.Request.Context(), csr.Name, csr.Recursive)
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: newSnapshot(snap), ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleListSnapshots(c *gin.Context) {
+
+	result := zfsHandler.listSnapshots(c)
+	c.JSON(statusCode(result), result)
+}
+
+func (zfsHandler *ZfsHandler) listSnapshots(c *gin.Context) v1.BaseResult {
+
+	// a single snapshot is listed by id, as in CSI
+	if id := c.Query("snapshot_id"); id != "" {
+		snap, err := zfs.GetDatasetContext(c.Request.Context(), id)
+		if err != nil && !errors.Is(err, zfs.ErrNotFound) {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+		}
+		if err != nil || snap.Type != zfs.DatasetSnapshot {
+			return v1.BaseResult{Status: v1.StatusSuccess, Data: []v1.Snapshot{}, ApiError: nil}
+		}
+		return v1.BaseResult{Status: v1.StatusSuccess, Data: []v1.Snapshot{newSnapshot(snap)}, ApiError: nil}
+	}
+
+	source := c.Query("source_volume_id")
+	datasets, err := zfs.SnapshotsContext(c.Request.Context(), source)
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+	zfs.SortSnapshots(datasets)
+
+	snapshots := []v1.Snapshot{}
+	for _, ds := range datasets {
+		snap := newSnapshot(ds)
+		// zfs list -r includes the snapshots of descendents
+		if source != "" && snap.SourceVolumeId != source {
+			continue
+		}
+		snapshots = append(snapshots, snap)
+	}
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: snapshots, ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleDeleteSnapshot(c *gin.Context) {
+
+	zfsHandler.run(c, zfsHandler.deleteSnapshot)
+}
+
+func (zfsHandler *ZfsHandler) deleteSnapshot(c *gin.Context) v1.BaseResult {
+
+	flags, err := destroyFlags(c)
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}
+	}
+
+	snap, err := zfs.GetDatasetContext(c.Request.Context(), c.Param("name"))
+	if errors.Is(err, zfs.ErrNotFound) {
+		// deleting a missing snapshot succeeds, as in CSI
+		return v1.BaseResult{Status: v1.StatusSuccess, Data: nil, ApiError: nil}
+	}
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+	if snap.Type != zfs.DatasetSnapshot {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: snap.Name + " is not a snapshot"}}
+	}
+	if err := snap.DestroyContext(c.Request.Context(), flags); err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: nil, ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleRollbackSnapshot(c *gin.Context) {
+
+	zfsHandler.run(c, zfsHandler.rollbackSnapshot)
+}
+
+func (zfsHandler *ZfsHandler) rollbackSnapshot(c *gin.Context) v1.BaseResult {
+
+	// the request body is optional
+	rsr := v1.RollbackSnapshotRequest{}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&rsr); err != nil {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}
+		}
+	}
+
+	snap, apiErr := lookupDataset(c, zfs.DatasetSnapshot)
+	if apiErr != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: apiErr}
+	}
+	if err := snap.RollbackContext(c.Request.Context(), rsr.DestroyMoreRecent); err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: newSnapshot(snap), ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleCloneSnapshot(c *gin.Context) {
+
+	zfsHandler.run(c, zfsHandler.cloneSnapshot)
+}
+
+func (zfsHandler *ZfsHandler) cloneSnapshot(c *gin.Context) v1.BaseResult {
+
+	csr := v1.CloneSnapshotRequest{}
+	if err := c.ShouldBindJSON(&csr); err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}
+	}
+	if csr.Name == "" {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: "name is required"}}
+	}
+
+	snap, apiErr := lookupDataset(c, zfs.DatasetSnapshot)
+	if apiErr != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: apiErr}
+	}
+	clone, err := snap.CloneContext(c.Request.Context(), csr.Name, csr.Properties)
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: newDataset(clone), ApiError: nil}
+}
@@ -0,0 +1,193 @@
+package handle
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
+	"github.com/garenwen/freebsd-manager/pkg/auth"
+	"github.com/garenwen/freebsd-manager/pkg/zfs"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+)
+
+// newDataset returns the response for a volume or filesystem.
+func newDataset(ds *zfs.Dataset) interface{} {
+	if ds.Type == zfs.DatasetVolume {
+		return newVolume(ds)
+	}
+	return newFilesystem(ds)
+}
+
+// streamWriter writes a zfs stream to the response.  The headers are only
+// written with the stream, so that a command that fails before writing can
+// still be answered with an error result.
+type streamWriter struct {
+	c *gin.Context
+}
+
+func (w streamWriter) Write(p []byte) (int, error) {
+	if !w.c.Writer.Written() {
+		w.c.Header("Content-Type", "application/octet-stream")
+	}
+	return w.c.Writer.Write(p)
+}
+
+type responseWriterKey struct{}
+
+// StreamDeadlines wraps the handler of a server, so that the stream handlers
+// can lift the read and write timeouts of the server, which would cut off
+// snapshot streams that take longer.  The response writer of gin does not
+// unwrap to the one of the server, so it is passed in the request context.
+func StreamDeadlines(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), responseWriterKey{}, w)))
+	})
+}
+
+// liftDeadlines clears the read and write deadlines of a stream request.
+func liftDeadlines(c *gin.Context) {
+	w, ok := c.Request.Context().Value(responseWriterKey{}).(http.ResponseWriter)
+	if !ok {
+		return
+	}
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		glog.Warningln("failed to lift the read deadline of a stream", err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		glog.Warningln("failed to lift the write deadline of a stream", err)
+	}
+}
+
+// abortStream closes the connection of a stream that failed after it was
+// partially written, so that the client does not mistake it for a complete
+// stream.
+func abortStream(c *gin.Context) {
+	conn, _, err := c.Writer.Hijack()
+	if err != nil {
+		glog.Errorln("failed to abort stream", err)
+		return
+	}
+	conn.Close()
+}
+
+func (zfsHandler *ZfsHandler) HandleSendSnapshot(c *gin.Context) {
+
+	liftDeadlines(c)
+	result, ok := zfsHandler.sendSnapshot(c)
+	if ok {
+		return
+	}
+	if c.Writer.Written() {
+		glog.Errorln("snapshot stream failed", result.ApiError.Msg)
+		abortStream(c)
+		return
+	}
+	c.JSON(statusCode(result), result)
+}
+
+// sendSnapshot streams the snapshot into the response, and returns whether it
+// succeeded, or an error result otherwise.
+func (zfsHandler *ZfsHandler) sendSnapshot(c *gin.Context) (v1.BaseResult, bool) {
+
+	opts := v1.SendOptions{From: c.Query("from"), ResumeToken: c.Query("resume_token")}
+	var err error
+	if opts.Intermediary, err = queryBool(c, "intermediary"); err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}, false
+	}
+	if opts.Raw, err = queryBool(c, "raw"); err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}, false
+	}
+
+	ctx := c.Request.Context()
+	if opts.ResumeToken != "" {
+		// the token names the snapshot it sends, which the caller was not
+		// authorized for unless it is the requested one or below it
+		var snapshot string
+		if snapshot, err = zfs.ResumeTokenSnapshot(ctx, opts.ResumeToken); err != nil {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}, false
+		}
+		if name := c.Param("name"); !resumeTokenWithin(snapshot, name) {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorPermission, Msg: "resume token sends " + snapshot + ", not " + name}}, false
+		}
+		err = zfs.ResumeSend(ctx, streamWriter{c}, opts.ResumeToken)
+	} else {
+		snap, apiErr := lookupDataset(c, zfs.DatasetSnapshot)
+		if apiErr != nil {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: apiErr}, false
+		}
+		sendOpts := zfs.SendOptions{From: opts.From, Intermediary: opts.Intermediary, Raw: opts.Raw}
+		err = snap.SendSnapshotWithOptions(ctx, streamWriter{c}, sendOpts)
+	}
+	if err != nil {
+		typ := v1.ErrorExec
+		if ctx.Err() == context.Canceled {
+			typ = v1.ErrorCanceled
+		}
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: typ, Msg: err.Error()}}, false
+	}
+
+	return v1.BaseResult{}, true
+}
+
+// resumeTokenWithin reports whether the snapshot of a resume token is name, or
+// a snapshot of name or of a descendant of it.
+func resumeTokenWithin(snapshot, name string) bool {
+	return snapshot == name || strings.HasPrefix(snapshot, name+"@") || strings.HasPrefix(snapshot, name+"/")
+}
+
+func (zfsHandler *ZfsHandler) HandleReceiveSnapshot(c *gin.Context) {
+
+	liftDeadlines(c)
+	result := zfsHandler.receiveSnapshot(c)
+	c.JSON(statusCode(result), result)
+}
+
+func (zfsHandler *ZfsHandler) receiveSnapshot(c *gin.Context) v1.BaseResult {
+
+	opts := zfs.ReceiveOptions{}
+	for key, field := range map[string]*bool{
+		"resumable": &opts.Resumable,
+		"force":     &opts.Force,
+		"unmounted": &opts.Unmounted,
+	} {
+		val, err := queryBool(c, key)
+		if err != nil {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: key + ": " + err.Error()}}
+		}
+		*field = val
+	}
+
+	// the properties of the stream are set by zfs, on behalf of the caller
+	opts.Exclude = auth.DeniedProperties(c)
+	// the request body is passed to zfs receive as is
+	ds, err := zfs.ReceiveSnapshotWithOptions(c.Request.Context(), c.Request.Body, c.Param("name"), opts)
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: newDataset(ds), ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleGetResumeToken(c *gin.Context) {
+
+	result := zfsHandler.getResumeToken(c)
+	c.JSON(statusCode(result), result)
+}
+
+func (zfsHandler *ZfsHandler) getResumeToken(c *gin.Context) v1.BaseResult {
+
+	ds, err := zfs.GetDatasetContext(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+	token, err := ds.ResumeTokenContext(c.Request.Context())
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: v1.ResumeToken{Token: token}, ApiError: nil}
+}
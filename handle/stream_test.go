@@ -0,0 +1,66 @@
+package handle
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResumeTokenWithin(t *testing.T) {
+	var tests = []struct {
+		snapshot, name string
+		within         bool
+	}{
+		{"tank/k8s/team-a/v@daily", "tank/k8s/team-a/v@daily", true},
+		{"tank/k8s/team-a/v@daily", "tank/k8s/team-a/v", true},
+		{"tank/k8s/team-a/v@daily", "tank/k8s/team-a", true},
+		{"tank/k8s/team-a/v@daily", "tank/k8s/team-a/v@hourly", false},
+		{"tank/victim/v@daily", "tank/k8s/team-a/v@daily", false},
+		{"tank/k8s/team-ab/v@daily", "tank/k8s/team-a", false},
+	}
+
+	for _, test := range tests {
+		if got := resumeTokenWithin(test.snapshot, test.name); got != test.within {
+			t.Errorf("resumeTokenWithin(%q, %q) = %v, want %v", test.snapshot, test.name, got, test.within)
+		}
+	}
+}
+
+func TestLiftDeadlines(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	route := gin.New()
+	for _, path := range []string{"/stream", "/json"} {
+		lift := path == "/stream"
+		route.GET(path, func(c *gin.Context) {
+			if lift {
+				liftDeadlines(c)
+			}
+			time.Sleep(200 * time.Millisecond)
+			c.String(http.StatusOK, "ok")
+		})
+	}
+	srv := httptest.NewUnstartedServer(StreamDeadlines(route))
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "ok" {
+		t.Errorf("got %q %v for a stream", body, err)
+	}
+
+	// other requests keep the timeouts of the server
+	if resp, err := http.Get(srv.URL + "/json"); err == nil {
+		resp.Body.Close()
+		t.Error("request outlived the write timeout")
+	}
+}
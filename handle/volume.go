@@ -0,0 +1,241 @@
+package handle
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+
+	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
+	"github.com/garenwen/freebsd-manager/pkg/zfs"
+	"github.com/gin-gonic/gin"
+)
+
+func newVolume(ds *zfs.Dataset) v1.Volume {
+	return v1.Volume{
+		Name:           ds.Name,
+		Origin:         ds.Origin,
+		Used:           ds.Used,
+		Avail:          ds.Avail,
+		Referenced:     ds.Referenced,
+		Volsize:        ds.Volsize,
+		Volblocksize:   ds.Volblocksize,
+		Refreservation: ds.Refreservation,
+		Compression:    ds.Compression,
+		Creation:       ds.Creation,
+	}
+}
+
+// lookupDataset returns the dataset named by the name path parameter, which
+// must be of type typ.
+func lookupDataset(c *gin.Context, typ string) (*zfs.Dataset, *v1.ApiError) {
+	name := c.Param("name")
+	ds, err := zfs.GetDatasetContext(c.Request.Context(), name)
+	if err != nil {
+		return nil, zfsError(err)
+	}
+	if ds.Type != typ {
+		return nil, &v1.ApiError{Typ: v1.ErrorBadData, Msg: name + " is not a " + typ}
+	}
+	return ds, nil
+}
+
+// queryBool returns the boolean value of a query parameter, false if absent.
+func queryBool(c *gin.Context, key string) (bool, error) {
+	val := c.Query(key)
+	if val == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(val)
+}
+
+// queryBytes returns the size value of a query parameter, 0 if absent.
+func queryBytes(c *gin.Context, key string) (zfs.Bytes, error) {
+	return zfs.ParseBytes(c.Query(key))
+}
+
+// destroyFlags returns the flags of a deletion given as query parameters.
+func destroyFlags(c *gin.Context) (zfs.DestroyFlag, error) {
+	flags := zfs.DestroyDefault
+	for key, flag := range map[string]zfs.DestroyFlag{
+		"recursive":        zfs.DestroyRecursive,
+		"recursive_clones": zfs.DestroyRecursiveClones,
+		"defer":            zfs.DestroyDeferDeletion,
+		"force":            zfs.DestroyForceUmount,
+	} {
+		set, err := queryBool(c, key)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", key, err)
+		}
+		if set {
+			flags |= flag
+		}
+	}
+	return flags, nil
+}
+
+func (zfsHandler *ZfsHandler) HandleListVolumes(c *gin.Context) {
+
+	result := zfsHandler.listVolumes(c)
+	c.JSON(statusCode(result), result)
+}
+
+func (zfsHandler *ZfsHandler) listVolumes(c *gin.Context) v1.BaseResult {
+
+	opts := v1.ListVolumesOptions{Filter: c.Query("filter"), Origin: c.Query("origin")}
+	var err error
+	if opts.MinSize, err = queryBytes(c, "min_size"); err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}
+	}
+	if opts.MaxSize, err = queryBytes(c, "max_size"); err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}
+	}
+
+	datasets, err := zfs.VolumesContext(c.Request.Context(), opts.Filter)
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	volumes := []v1.Volume{}
+	for _, ds := range datasets {
+		if opts.Origin != "" && ds.Origin != opts.Origin {
+			continue
+		}
+		if ds.Volsize < opts.MinSize || (opts.MaxSize != 0 && ds.Volsize > opts.MaxSize) {
+			continue
+		}
+		volumes = append(volumes, newVolume(ds))
+	}
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: volumes, ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleGetVolume(c *gin.Context) {
+
+	result := zfsHandler.getVolume(c)
+	c.JSON(statusCode(result), result)
+}
+
+func (zfsHandler *ZfsHandler) getVolume(c *gin.Context) v1.BaseResult {
+
+	ds, apiErr := lookupDataset(c, zfs.DatasetVolume)
+	if apiErr != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: apiErr}
+	}
+
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: newVolume(ds), ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleDeleteVolume(c *gin.Context) {
+
+	zfsHandler.run(c, zfsHandler.deleteVolume)
+}
+
+func (zfsHandler *ZfsHandler) deleteVolume(c *gin.Context) v1.BaseResult {
+
+	flags, err := destroyFlags(c)
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}
+	}
+
+	ds, apiErr := lookupDataset(c, zfs.DatasetVolume)
+	if apiErr != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: apiErr}
+	}
+	if err := ds.DestroyContext(c.Request.Context(), flags); err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: nil, ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleUpdateVolume(c *gin.Context) {
+
+	zfsHandler.run(c, zfsHandler.updateVolume)
+}
+
+func (zfsHandler *ZfsHandler) updateVolume(c *gin.Context) v1.BaseResult {
+
+	uvr := v1.UpdateVolumeRequest{}
+	if err := c.ShouldBindJSON(&uvr); err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}
+	}
+	if _, ok := uvr.Properties["volsize"]; ok {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: "use size to resize a volume"}}
+	}
+
+	ds, apiErr := lookupDataset(c, zfs.DatasetVolume)
+	if apiErr != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: apiErr}
+	}
+
+	for _, key := range uvr.Inherit {
+		if err := ds.InheritPropertyContext(c.Request.Context(), key); err != nil {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+		}
+	}
+	for key, val := range uvr.Properties {
+		if err := ds.SetPropertyContext(c.Request.Context(), key, val); err != nil {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+		}
+	}
+	if uvr.Size != 0 && uvr.Size != ds.Volsize {
+		if _, err := ds.ResizeContext(c.Request.Context(), uvr.Size, uvr.AllowShrink); err != nil {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+		}
+	}
+
+	ds, err := zfs.GetDatasetContext(c.Request.Context(), ds.Name)
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: newVolume(ds), ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleCloneVolume(c *gin.Context) {
+
+	zfsHandler.run(c, zfsHandler.cloneVolume)
+}
+
+func (zfsHandler *ZfsHandler) cloneVolume(c *gin.Context) v1.BaseResult {
+
+	cvr := v1.CloneVolumeRequest{}
+	if err := c.ShouldBindJSON(&cvr); err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}
+	}
+	if cvr.Name == "" {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: "name is required"}}
+	}
+
+	ds, apiErr := lookupDataset(c, zfs.DatasetVolume)
+	if apiErr != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: apiErr}
+	}
+
+	// a snapshot named after the clone is reused when the clone is retried
+	snapName := cvr.Snapshot
+	if snapName == "" {
+		snapName = "clone-" + path.Base(cvr.Name)
+	}
+	snap, err := zfs.GetDatasetContext(c.Request.Context(), ds.Name+"@"+snapName)
+	created := false
+	if err != nil {
+		if cvr.Snapshot != "" || !errors.Is(err, zfs.ErrNotFound) {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+		}
+		if snap, err = ds.SnapshotContext(c.Request.Context(), snapName, false); err != nil {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+		}
+		created = true
+	}
+
+	clone, err := snap.CloneContext(c.Request.Context(), cvr.Name, cvr.Properties)
+	if err != nil {
+		// the snapshot is removed even if the request was canceled
+		if created {
+			snap.DestroyContext(withoutCancel(c.Request.Context()), zfs.DestroyDefault)
+		}
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: newVolume(clone), ApiError: nil}
+}
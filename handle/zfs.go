@@ -1,9 +1,13 @@
 package handle
 
 import (
-	"net/http"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
 
 	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
+	"github.com/garenwen/freebsd-manager/pkg/jobs"
 
 	"github.com/garenwen/freebsd-manager/pkg/zfs"
 
@@ -11,16 +15,19 @@ import (
 )
 
 type ZfsHandler struct {
+	jobs        *jobs.Manager
+	idempotency *idempotencyCache
 }
 
-func NewZfsHandler() *ZfsHandler {
+// NewZfsHandler returns a handler that runs asynchronous requests with
+// jobManager.  Requests are always answered synchronously if it is nil.
+func NewZfsHandler(jobManager *jobs.Manager) *ZfsHandler {
 
-	return &ZfsHandler{}
+	return &ZfsHandler{jobs: jobManager, idempotency: newIdempotencyCache()}
 }
 func (zfsHandler *ZfsHandler) HandleCreateVolume(c *gin.Context) {
 
-	result := zfsHandler.createVolume(c)
-	c.JSON(http.StatusOK, result)
+	zfsHandler.run(c, zfsHandler.createVolume)
 }
 
 func (zfsHandler *ZfsHandler) createVolume(c *gin.Context) v1.BaseResult {
@@ -29,10 +36,224 @@ func (zfsHandler *ZfsHandler) createVolume(c *gin.Context) v1.BaseResult {
 	if err := c.ShouldBindJSON(&cvr); err != nil {
 		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}
 	}
-	v, err := zfs.CreateVolume(cvr.Name, uint64(cvr.CapacityRange.RequiredBytes), cvr.Parameters)
+	// creating an existing volume succeeds if it matches the request, as in
+	// CSI
+	if v, err := zfs.GetDatasetContext(c.Request.Context(), cvr.Name); err == nil {
+		return existingVolume(c.Request.Context(), v, &cvr)
+	}
+
+	opts := zfs.VolumeOptions{Sparse: cvr.Sparse, BlockSize: cvr.VolBlockSize}
+	v, err := zfs.CreateVolumeWithOptions(c.Request.Context(), cvr.Name, cvr.RequiredBytes(), opts, cvr.Parameters)
 	if err != nil {
-		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}
+		// the volume may have been created by a concurrent request
+		if v, getErr := zfs.GetDatasetContext(c.Request.Context(), cvr.Name); getErr == nil {
+			return existingVolume(c.Request.Context(), v, &cvr)
+		}
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
 	}
 
 	return v1.BaseResult{Status: v1.StatusSuccess, Data: v, ApiError: nil}
 }
+
+// existingVolume returns the result of a request to create a volume that
+// already exists.
+func existingVolume(ctx context.Context, v *zfs.Dataset, cvr *v1.CreateVolumeRequest) v1.BaseResult {
+	getProperty := func(key string) (string, error) {
+		return v.GetPropertyContext(ctx, key)
+	}
+	compatible, err := volumeCompatible(v, cvr, getProperty)
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+	if compatible != "" {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorAlreadyExists, Msg: compatible}}
+	}
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: v, ApiError: nil}
+}
+
+// volumeCompatible checks an existing volume against a request to create it,
+// and describes the first mismatch, or returns empty string ("") if it
+// matches.  The requested parameters are compared with the values returned by
+// getProperty.
+func volumeCompatible(v *zfs.Dataset, cvr *v1.CreateVolumeRequest, getProperty func(key string) (string, error)) (string, error) {
+	if v.Type != zfs.DatasetVolume {
+		return fmt.Sprintf("%s already exists and is a %s", v.Name, v.Type), nil
+	}
+	if required := cvr.RequiredBytes(); v.Volsize < required {
+		return fmt.Sprintf("volume %s already exists with size %s, smaller than %s", v.Name, v.Volsize.Exact(), required.Exact()), nil
+	}
+	if cvr.CapacityRange != nil && cvr.CapacityRange.LimitBytes != 0 && v.Volsize > cvr.CapacityRange.LimitBytes {
+		return fmt.Sprintf("volume %s already exists with size %s, larger than %s", v.Name, v.Volsize.Exact(), cvr.CapacityRange.LimitBytes.Exact()), nil
+	}
+	if cvr.VolBlockSize != 0 && v.Volblocksize != cvr.VolBlockSize {
+		return fmt.Sprintf("volume %s already exists with volblocksize %s", v.Name, v.Volblocksize), nil
+	}
+	if _, ok := cvr.Parameters["refreservation"]; !ok && cvr.Sparse != (v.Refreservation == 0) {
+		if cvr.Sparse {
+			return fmt.Sprintf("volume %s already exists and is not sparse", v.Name), nil
+		}
+		return fmt.Sprintf("volume %s already exists and is sparse", v.Name), nil
+	}
+
+	for key, want := range cvr.Parameters {
+		got, err := getProperty(key)
+		if err != nil {
+			return "", err
+		}
+		if !propertyEqual(got, want) {
+			return fmt.Sprintf("volume %s already exists with %s=%s", v.Name, key, got), nil
+		}
+	}
+	return "", nil
+}
+
+// propertyEqual compares property values, which zfs get may print in a
+// different form than they were set, e.g. 1G for 1073741824.
+func propertyEqual(got, want string) bool {
+	if strings.EqualFold(got, want) {
+		return true
+	}
+	gotBytes, err := zfs.ParseBytes(got)
+	if err != nil {
+		return false
+	}
+	wantBytes, err := zfs.ParseBytes(want)
+	return err == nil && gotBytes == wantBytes
+}
+
+func (zfsHandler *ZfsHandler) HandleExpandVolume(c *gin.Context) {
+
+	zfsHandler.run(c, zfsHandler.expandVolume)
+}
+
+func (zfsHandler *ZfsHandler) expandVolume(c *gin.Context) v1.BaseResult {
+
+	evr := v1.ControllerExpandVolumeRequest{}
+	if err := c.ShouldBindJSON(&evr); err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}
+	}
+	if evr.CapacityRange == nil || evr.CapacityRange.RequiredBytes == 0 {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: "capacity_range.required_bytes is required"}}
+	}
+
+	v, err := zfs.GetDatasetContext(c.Request.Context(), evr.VolumeId)
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+	if v.Type != zfs.DatasetVolume {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: evr.VolumeId + " is not a volume"}}
+	}
+
+	// round up to the volblocksize, CSI allows any size within the range
+	size := evr.CapacityRange.RequiredBytes
+	if bs := v.Volblocksize; bs != 0 && size%bs != 0 {
+		size += bs - size%bs
+	}
+	if limit := evr.CapacityRange.LimitBytes; limit != 0 && size > limit {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: "capacity_range cannot be satisfied with volblocksize " + v.Volblocksize.String()}}
+	}
+
+	// expanding to a smaller size than the current one is a no-op
+	if size > v.Volsize {
+		v, err = v.ResizeContext(c.Request.Context(), size, false)
+		if err != nil {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+		}
+	}
+
+	resp := v1.ControllerExpandVolumeResponse{CapacityBytes: v.Volsize, NodeExpansionRequired: true}
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: resp, ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleCreateExport(c *gin.Context) {
+
+	zfsHandler.run(c, zfsHandler.createExport)
+}
+
+func (zfsHandler *ZfsHandler) createExport(c *gin.Context) v1.BaseResult {
+
+	cer := v1.CreateExportRequest{}
+	if err := c.ShouldBindJSON(&cer); err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}}
+	}
+	if cer.NFS == nil && cer.SMB == nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: "nfs or smb options are required"}}
+	}
+
+	fs, err := zfs.GetDatasetContext(c.Request.Context(), cer.Name)
+	if err != nil {
+		if !errors.Is(err, zfs.ErrNotFound) {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+		}
+		// provision the filesystem if it does not exist yet
+		fs, err = zfs.CreateFilesystemContext(c.Request.Context(), cer.Name, cer.Properties)
+		if err != nil {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+		}
+	}
+	if err := fs.SetShareOptionsContext(c.Request.Context(), cer.NFS, cer.SMB); err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	export := v1.Export{Name: fs.Name, Mountpoint: fs.Mountpoint, NFS: cer.NFS, SMB: cer.SMB}
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: export, ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleListExports(c *gin.Context) {
+
+	result := zfsHandler.listExports(c)
+	c.JSON(statusCode(result), result)
+}
+
+func (zfsHandler *ZfsHandler) listExports(c *gin.Context) v1.BaseResult {
+
+	shares, err := zfs.SharesContext(c.Request.Context(), c.Query("filter"))
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	exports := make([]v1.Export, len(shares))
+	for i, s := range shares {
+		exports[i] = v1.Export{Name: s.Name, Mountpoint: s.Mountpoint, NFS: s.NFS, SMB: s.SMB}
+	}
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: exports, ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleDeleteExport(c *gin.Context) {
+
+	zfsHandler.run(c, zfsHandler.deleteExport)
+}
+
+func (zfsHandler *ZfsHandler) deleteExport(c *gin.Context) v1.BaseResult {
+
+	fs, err := zfs.GetDatasetContext(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+	if err := fs.SetShareOptionsContext(c.Request.Context(), nil, nil); err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: nil, ApiError: nil}
+}
+
+func (zfsHandler *ZfsHandler) HandleSpaceReport(c *gin.Context) {
+
+	result := zfsHandler.spaceReport(c)
+	c.JSON(statusCode(result), result)
+}
+
+func (zfsHandler *ZfsHandler) spaceReport(c *gin.Context) v1.BaseResult {
+
+	root := c.Query("root")
+	if root == "" {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: "root is required"}}
+	}
+
+	report, err := zfs.SpaceReportContext(c.Request.Context(), root)
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: zfsError(err)}
+	}
+
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: report, ApiError: nil}
+}
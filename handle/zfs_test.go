@@ -0,0 +1,74 @@
+package handle
+
+import (
+	"testing"
+
+	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
+	"github.com/garenwen/freebsd-manager/pkg/zfs"
+)
+
+func TestPropertyEqual(t *testing.T) {
+	var tests = []struct {
+		got, want string
+		equal     bool
+	}{
+		{"lz4", "lz4", true},
+		{"LZ4", "lz4", true},
+		{"1G", "1073741824", true},
+		{"1073741824", "1G", true},
+		{"1.50G", "1536M", true},
+		{"1G", "1000000000", false},
+		{"on", "off", false},
+		{"none", "0", true},
+		{"none", "1", false},
+	}
+
+	for _, test := range tests {
+		if got := propertyEqual(test.got, test.want); got != test.equal {
+			t.Errorf("propertyEqual(%q, %q) = %v, want %v", test.got, test.want, got, test.equal)
+		}
+	}
+}
+
+func TestVolumeCompatible(t *testing.T) {
+	thick := &zfs.Dataset{Name: "tank/vol", Type: zfs.DatasetVolume, Volsize: zfs.GiB, Volblocksize: 16 * zfs.KiB, Refreservation: zfs.GiB + zfs.MiB}
+	sparse := &zfs.Dataset{Name: "tank/vol", Type: zfs.DatasetVolume, Volsize: zfs.GiB, Volblocksize: 16 * zfs.KiB}
+	props := map[string]string{"compression": "lz4", "refreservation": "512M", "volsize": "1G"}
+	getProperty := func(key string) (string, error) {
+		return props[key], nil
+	}
+
+	var tests = []struct {
+		name       string
+		v          *zfs.Dataset
+		cvr        v1.CreateVolumeRequest
+		compatible bool
+	}{
+		{"same size", thick, v1.CreateVolumeRequest{Capacity: zfs.GiB}, true},
+		{"within range", thick, v1.CreateVolumeRequest{CapacityRange: &v1.CapacityRange{RequiredBytes: 512 * zfs.MiB, LimitBytes: 2 * zfs.GiB}}, true},
+		{"too small", thick, v1.CreateVolumeRequest{Capacity: 2 * zfs.GiB}, false},
+		{"too large", thick, v1.CreateVolumeRequest{CapacityRange: &v1.CapacityRange{RequiredBytes: zfs.MiB, LimitBytes: 512 * zfs.MiB}}, false},
+		{"filesystem", &zfs.Dataset{Name: "tank/vol", Type: zfs.DatasetFilesystem}, v1.CreateVolumeRequest{}, false},
+		{"volblocksize", thick, v1.CreateVolumeRequest{Capacity: zfs.GiB, VolBlockSize: 8 * zfs.KiB}, false},
+		{"same volblocksize", thick, v1.CreateVolumeRequest{Capacity: zfs.GiB, VolBlockSize: 16 * zfs.KiB}, true},
+		{"sparse requested", thick, v1.CreateVolumeRequest{Capacity: zfs.GiB, Sparse: true}, false},
+		{"thick requested", sparse, v1.CreateVolumeRequest{Capacity: zfs.GiB}, false},
+		{"sparse", sparse, v1.CreateVolumeRequest{Capacity: zfs.GiB, Sparse: true}, true},
+		// an explicit refreservation is compared instead of the sparse flag
+		{"refreservation", sparse, v1.CreateVolumeRequest{Capacity: zfs.GiB, Sparse: true, Parameters: map[string]string{"refreservation": "536870912"}}, true},
+		{"other refreservation", thick, v1.CreateVolumeRequest{Capacity: zfs.GiB, Parameters: map[string]string{"refreservation": "1G"}}, false},
+		{"bytes", thick, v1.CreateVolumeRequest{Capacity: zfs.GiB, Parameters: map[string]string{"volsize": "1073741824"}}, true},
+		{"parameters", thick, v1.CreateVolumeRequest{Capacity: zfs.GiB, Parameters: map[string]string{"compression": "LZ4"}}, true},
+		{"other parameters", thick, v1.CreateVolumeRequest{Capacity: zfs.GiB, Parameters: map[string]string{"compression": "off"}}, false},
+	}
+
+	for _, test := range tests {
+		mismatch, err := volumeCompatible(test.v, &test.cvr, getProperty)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (mismatch == "") != test.compatible {
+			t.Errorf("%s: got %q, want compatible %v", test.name, mismatch, test.compatible)
+		}
+	}
+}
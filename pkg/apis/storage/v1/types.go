@@ -1,5 +1,12 @@
 package v1
 
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/garenwen/freebsd-manager/pkg/zfs"
+)
+
 type status string
 
 const (
@@ -18,6 +25,16 @@ const (
 	ErrorInternal    errorType = "internal"
 	ErrorUnavailable errorType = "unavailable"
 	ErrorNotFound    errorType = "not_found"
+	// ErrorAlreadyExists is returned for a resource that exists with
+	// different parameters than requested.
+	ErrorAlreadyExists errorType = "already_exists"
+	ErrorBusy          errorType = "busy"
+	ErrorNoSpace       errorType = "no_space"
+	ErrorPermission    errorType = "permission_denied"
+	// ErrorHasDependents is returned for a dataset which has children,
+	// clones or more recent snapshots.
+	ErrorHasDependents errorType = "has_dependents"
+	ErrorUnauthorized  errorType = "unauthorized"
 )
 
 type BaseResult struct {
@@ -32,28 +49,272 @@ type ApiError struct {
 }
 
 type CreateVolumeRequest struct {
-	Name          string            `json:"name,omitempty"`
+	Name string `json:"name,omitempty"`
+	// Capacity is a shorthand for CapacityRange.RequiredBytes, e.g. "20Gi".
+	Capacity      zfs.Bytes         `json:"capacity,omitempty"`
 	CapacityRange *CapacityRange    `json:"capacity_range,omitempty"`
 	Parameters    map[string]string `json:"parameters,omitempty"`
+	// Sparse creates the volume without a refreservation.
+	Sparse       bool      `json:"sparse,omitempty"`
+	VolBlockSize zfs.Bytes `json:"volblocksize,omitempty"`
 }
 
+// RequiredBytes returns the requested size of the volume.
+func (r *CreateVolumeRequest) RequiredBytes() zfs.Bytes {
+	if r.Capacity != 0 || r.CapacityRange == nil {
+		return r.Capacity
+	}
+	return r.CapacityRange.RequiredBytes
+}
+
+// CapacityRange accepts sizes as a number of bytes or as strings such as
+// "512Mi" or "1.5G".
 type CapacityRange struct {
-	RequiredBytes int64 `json:"required_bytes,omitempty"`
-	LimitBytes    int64 `json:"limit_bytes,omitempty"`
+	RequiredBytes zfs.Bytes `json:"required_bytes,omitempty"`
+	LimitBytes    zfs.Bytes `json:"limit_bytes,omitempty"`
 }
 
 type CreateVolumeResponse struct {
-	Name          string `json:"name"`
-	Origin        string `json:"origin"`
-	Used          uint64 `json:"used"`
-	Avail         uint64 `json:"avail"`
-	Mountpoint    string `json:"mountpoint"`
-	Compression   string `json:"compression"`
-	Type          string `json:"type"`
-	Written       uint64 `json:"written"`
-	Volsize       uint64 `json:"volsize"`
-	Logicalused   uint64 `json:"logicalused"`
-	Usedbydataset uint64 `json:"usedbydataset"`
-	Quota         uint64 `json:"quota"`
-	Referenced    uint64 `json:"referenced"`
+	Name          string    `json:"name"`
+	Origin        string    `json:"origin"`
+	Used          zfs.Bytes `json:"used"`
+	Avail         zfs.Bytes `json:"avail"`
+	Mountpoint    string    `json:"mountpoint"`
+	Compression   string    `json:"compression"`
+	Type          string    `json:"type"`
+	Written       zfs.Bytes `json:"written"`
+	Volsize       zfs.Bytes `json:"volsize"`
+	Logicalused   zfs.Bytes `json:"logicalused"`
+	Usedbydataset zfs.Bytes `json:"usedbydataset"`
+	Quota         zfs.Bytes `json:"quota"`
+	Referenced    zfs.Bytes `json:"referenced"`
+}
+
+type ControllerExpandVolumeRequest struct {
+	VolumeId      string         `json:"volume_id,omitempty"`
+	CapacityRange *CapacityRange `json:"capacity_range,omitempty"`
+}
+
+type ControllerExpandVolumeResponse struct {
+	CapacityBytes         zfs.Bytes `json:"capacity_bytes"`
+	NodeExpansionRequired bool      `json:"node_expansion_required"`
+}
+
+type CreateExportRequest struct {
+	Name string               `json:"name,omitempty"`
+	NFS  *zfs.NFSShareOptions `json:"nfs,omitempty"`
+	SMB  *zfs.SMBShareOptions `json:"smb,omitempty"`
+	// Properties are used to create the filesystem if it does not exist.
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type Export struct {
+	Name       string               `json:"name"`
+	Mountpoint string               `json:"mountpoint"`
+	NFS        *zfs.NFSShareOptions `json:"nfs,omitempty"`
+	SMB        *zfs.SMBShareOptions `json:"smb,omitempty"`
+}
+
+// Volume is a ZFS volume.
+type Volume struct {
+	Name           string    `json:"name"`
+	Origin         string    `json:"origin,omitempty"`
+	Used           zfs.Bytes `json:"used"`
+	Avail          zfs.Bytes `json:"avail"`
+	Referenced     zfs.Bytes `json:"referenced"`
+	Volsize        zfs.Bytes `json:"volsize"`
+	Volblocksize   zfs.Bytes `json:"volblocksize"`
+	Refreservation zfs.Bytes `json:"refreservation"`
+	Compression    string    `json:"compression"`
+	Creation       time.Time `json:"creation"`
+}
+
+// ListVolumesOptions select the volumes returned by the list endpoint.  Zero
+// values do not filter.
+type ListVolumesOptions struct {
+	// Filter selects the volumes below a dataset.
+	Filter string `json:"filter,omitempty"`
+	// Origin selects the clones of a snapshot.
+	Origin  string    `json:"origin,omitempty"`
+	MinSize zfs.Bytes `json:"min_size,omitempty"`
+	MaxSize zfs.Bytes `json:"max_size,omitempty"`
+}
+
+// DeleteOptions are the flags of a volume or filesystem deletion.
+type DeleteOptions struct {
+	// Recursive destroys the snapshots and descendents of the dataset.
+	Recursive bool `json:"recursive,omitempty"`
+	// RecursiveClones also destroys the clones of these snapshots.
+	RecursiveClones bool `json:"recursive_clones,omitempty"`
+	Defer           bool `json:"defer,omitempty"`
+	Force           bool `json:"force,omitempty"`
+}
+
+type UpdateVolumeRequest struct {
+	// Size resizes the volume if nonzero.
+	Size zfs.Bytes `json:"size,omitempty"`
+	// AllowShrink permits a Size smaller than the current one, which
+	// discards the data beyond it.
+	AllowShrink bool              `json:"allow_shrink,omitempty"`
+	Properties  map[string]string `json:"properties,omitempty"`
+	// Inherit lists properties to reset to their inherited value.
+	Inherit []string `json:"inherit,omitempty"`
+}
+
+type CloneVolumeRequest struct {
+	Name string `json:"name"`
+	// Snapshot is the snapshot of the volume to clone, without the volume
+	// name.  A snapshot named after the clone is taken if empty.
+	Snapshot   string            `json:"snapshot,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// Filesystem is a ZFS filesystem.
+type Filesystem struct {
+	Name        string    `json:"name"`
+	Origin      string    `json:"origin,omitempty"`
+	Used        zfs.Bytes `json:"used"`
+	Avail       zfs.Bytes `json:"avail"`
+	Referenced  zfs.Bytes `json:"referenced"`
+	Quota       zfs.Bytes `json:"quota"`
+	Refquota    zfs.Bytes `json:"refquota"`
+	Mountpoint  string    `json:"mountpoint"`
+	Canmount    string    `json:"canmount"`
+	Mounted     bool      `json:"mounted"`
+	Compression string    `json:"compression"`
+	Creation    time.Time `json:"creation"`
+}
+
+type CreateFilesystemRequest struct {
+	Name string `json:"name"`
+	// Quota and Refquota limit the space of the filesystem if nonzero.
+	Quota      zfs.Bytes         `json:"quota,omitempty"`
+	Refquota   zfs.Bytes         `json:"refquota,omitempty"`
+	Mountpoint string            `json:"mountpoint,omitempty"`
+	Canmount   string            `json:"canmount,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// UpdateFilesystemRequest changes the properties of a filesystem.  Nil and
+// empty fields are left unchanged; a zero quota removes the quota.
+type UpdateFilesystemRequest struct {
+	Quota      *zfs.Bytes        `json:"quota,omitempty"`
+	Refquota   *zfs.Bytes        `json:"refquota,omitempty"`
+	Mountpoint string            `json:"mountpoint,omitempty"`
+	Canmount   string            `json:"canmount,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+	// Inherit lists properties to reset to their inherited value.
+	Inherit []string `json:"inherit,omitempty"`
+}
+
+type MountFilesystemRequest struct {
+	// Overlay mounts the filesystem over a non-empty directory.
+	Overlay bool `json:"overlay,omitempty"`
+	// Options are temporary mount options, e.g. "ro".
+	Options []string `json:"options,omitempty"`
+}
+
+type UnmountFilesystemRequest struct {
+	Force bool `json:"force,omitempty"`
+}
+
+// Snapshot is a ZFS snapshot, described like a CSI snapshot.
+type Snapshot struct {
+	// SnapshotId is the full name of the snapshot, e.g. tank/vol@snap.
+	SnapshotId     string `json:"snapshot_id"`
+	SourceVolumeId string `json:"source_volume_id"`
+	// SizeBytes is the size of a volume restored from the snapshot.
+	SizeBytes    zfs.Bytes `json:"size_bytes"`
+	Used         zfs.Bytes `json:"used"`
+	CreationTime time.Time `json:"creation_time"`
+	// ReadyToUse is always true, ZFS snapshots are usable once created.
+	ReadyToUse bool `json:"ready_to_use"`
+}
+
+type CreateSnapshotRequest struct {
+	SourceVolumeId string `json:"source_volume_id"`
+	// Name is the snapshot name, without the source volume.
+	Name string `json:"name"`
+	// Recursive also snapshots the descendents of the source.
+	Recursive bool `json:"recursive,omitempty"`
+}
+
+type RollbackSnapshotRequest struct {
+	// DestroyMoreRecent destroys the snapshots taken after the snapshot,
+	// without which the rollback fails if there are any.
+	DestroyMoreRecent bool `json:"destroy_more_recent,omitempty"`
+}
+
+type CloneSnapshotRequest struct {
+	Name       string            `json:"name"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// Pool is a ZFS zpool.
+type Pool struct {
+	Name      string    `json:"name"`
+	Health    string    `json:"health"`
+	Size      zfs.Bytes `json:"size"`
+	Allocated zfs.Bytes `json:"allocated"`
+	Free      zfs.Bytes `json:"free"`
+	// Capacity is the percentage of the size that is allocated.
+	Capacity      uint64    `json:"capacity"`
+	Fragmentation uint64    `json:"fragmentation"`
+	DedupRatio    float64   `json:"dedup_ratio"`
+	ReadOnly      bool      `json:"read_only"`
+	Freeing       zfs.Bytes `json:"freeing"`
+	Leaked        zfs.Bytes `json:"leaked"`
+}
+
+// SendOptions are the query parameters of a snapshot stream.
+type SendOptions struct {
+	// From is the snapshot or bookmark an incremental stream starts from,
+	// e.g. @snap1 or tank/vol#bookmark.
+	From         string `json:"from,omitempty"`
+	Intermediary bool   `json:"intermediary,omitempty"`
+	Raw          bool   `json:"raw,omitempty"`
+	// ResumeToken resumes an interrupted stream.  The other options are
+	// ignored, they are part of the token.  The token must be for the sent
+	// snapshot, or a snapshot of a descendant of the sent dataset.
+	ResumeToken string `json:"resume_token,omitempty"`
+}
+
+// ReceiveOptions are the query parameters of a receive.
+type ReceiveOptions struct {
+	Resumable bool `json:"resumable,omitempty"`
+	Force     bool `json:"force,omitempty"`
+	Unmounted bool `json:"unmounted,omitempty"`
+}
+
+type ResumeToken struct {
+	// Token is empty if there is no interrupted receive to resume.
+	Token string `json:"token"`
+}
+
+type JobState string
+
+const (
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCanceled  JobState = "canceled"
+)
+
+// Job is a request that is processed asynchronously.
+type Job struct {
+	Id string `json:"id"`
+	// Operation is the method and path of the request, e.g.
+	// DELETE /apis/storage/v1/volumes/tank%2Fvol.
+	Operation string `json:"operation"`
+	// Owner is the subject of the caller who submitted the job.
+	Owner string   `json:"owner,omitempty"`
+	State JobState `json:"state"`
+	// Progress is the percentage done, if the operation reports it.
+	Progress float64 `json:"progress,omitempty"`
+	// Result is the data of the result of a succeeded job.
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *ApiError       `json:"error,omitempty"`
+	Created time.Time       `json:"created"`
+	// Finished is zero while the job is running.
+	Finished time.Time `json:"finished"`
 }
@@ -0,0 +1,173 @@
+// Package audit writes a JSON lines log of the API calls and of the zfs and
+// zpool commands run for them.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/garenwen/freebsd-manager/pkg/auth"
+	"github.com/garenwen/freebsd-manager/pkg/zfs"
+	"github.com/golang/glog"
+)
+
+// Entry is a line of the audit log.  API calls are logged when they are
+// answered.  Commands of an asynchronous request are logged in an entry of
+// their own once the job is done, with the same request id.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	// Job is the id of the job of an asynchronous request.
+	Job        string         `json:"job,omitempty"`
+	Identity   *auth.Identity `json:"identity,omitempty"`
+	RemoteAddr string         `json:"remote_addr,omitempty"`
+	Method     string         `json:"method,omitempty"`
+	// Path is the escaped path and query of the request.
+	Path string `json:"path,omitempty"`
+	// Body is the JSON request body, with secrets redacted.
+	Body       json.RawMessage `json:"body,omitempty"`
+	Status     int             `json:"status,omitempty"`
+	DurationMs float64         `json:"duration_ms"`
+	// Denied is the reason the request was not authorized.
+	Denied   string    `json:"denied,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Commands []Command `json:"commands,omitempty"`
+}
+
+// Command is a zfs or zpool command run for a request.
+type Command struct {
+	Args       []string  `json:"args"`
+	Start      time.Time `json:"start"`
+	DurationMs float64   `json:"duration_ms"`
+	ExitStatus int       `json:"exit_status"`
+	Stderr     string    `json:"stderr,omitempty"`
+}
+
+// maxStderr is the length at which the stderr of commands is cut off.
+const maxStderr = 4096
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Log is an audit log file, which is rotated when it grows beyond a size.
+type Log struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Open opens the audit log at path for appending.  Once the file grows beyond
+// maxSize bytes, it is renamed to path.1, path.1 to path.2 and so on, keeping
+// maxFiles old files.
+func Open(path string, maxSize int64, maxFiles int) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	l := &Log{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size = f, fi.Size()
+	return nil
+}
+
+// rotated returns the name of the i-th old file, or of the current file for 0.
+func (l *Log) rotated(i int) string {
+	if i == 0 {
+		return l.path
+	}
+	return fmt.Sprintf("%s.%d", l.path, i)
+}
+
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	os.Remove(l.rotated(l.maxFiles))
+	for i := l.maxFiles - 1; i >= 0; i-- {
+		if err := os.Rename(l.rotated(i), l.rotated(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return l.open()
+}
+
+// Write appends an entry to the log.
+func (l *Log) Write(e *Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(data)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	return err
+}
+
+// write appends an entry to the log, logging failures.
+func (l *Log) write(e *Entry) {
+	if err := l.Write(e); err != nil {
+		glog.Errorln("failed to write audit log", err)
+	}
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// LogCommand implements zfs.CommandLogger.  Commands are added to the entry of
+// the request whose context they were run with.  Other commands, and those
+// which finish after the entry was written, are logged on their own, with the
+// request id of their context.
+func (l *Log) LogCommand(ctx context.Context, r *zfs.CommandResult) {
+	stderr := r.Stderr
+	if len(stderr) > maxStderr {
+		stderr = stderr[:maxStderr] + "..."
+	}
+	cmd := Command{
+		Args:       r.Args,
+		Start:      r.Start,
+		DurationMs: milliseconds(r.Duration),
+		ExitStatus: r.ExitStatus,
+		Stderr:     stderr,
+	}
+
+	if rec := recordFromContext(ctx); rec != nil && rec.addCommand(cmd) {
+		return
+	}
+	l.write(&Entry{Time: time.Now(), RequestID: zfs.RequestID(ctx), DurationMs: cmd.DurationMs, Commands: []Command{cmd}})
+}
@@ -0,0 +1,283 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
+	"github.com/garenwen/freebsd-manager/pkg/zfs"
+	"github.com/gin-gonic/gin"
+)
+
+func openTestLog(t *testing.T, maxSize int64, maxFiles int) (*Log, func()) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Open(filepath.Join(dir, "audit.log"), maxSize, maxFiles)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return l, func() {
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	got := string(redact([]byte(`{"name":"tank/vol","properties":{"keylocation":"prompt","key":"abc"},"users":[{"password":"pw"}],"api_token":"t"}`)))
+	want := `{"api_token":"[redacted]","name":"tank/vol","properties":{"key":"[redacted]","keylocation":"prompt"},"users":[{"password":"[redacted]"}]}`
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	l, cleanup := openTestLog(t, 200, 2)
+	defer cleanup()
+
+	for i := 0; i < 10; i++ {
+		if err := l.Write(&Entry{Time: time.Now(), RequestID: strings.Repeat("x", 100)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i <= 2; i++ {
+		fi, err := os.Stat(l.rotated(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() > 200 {
+			t.Errorf("%s has %d bytes", l.rotated(i), fi.Size())
+		}
+	}
+	if _, err := os.Stat(l.rotated(3)); !os.IsNotExist(err) {
+		t.Errorf("%s was kept", l.rotated(3))
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l, cleanup := openTestLog(t, 0, 0)
+	defer cleanup()
+
+	route := gin.New()
+	route.Use(l.Middleware())
+	var late context.Context
+	route.POST("/volumes", func(c *gin.Context) {
+		// the body is still readable by the handler
+		body, _ := ioutil.ReadAll(c.Request.Body)
+		// commands are attributed by context, whichever goroutine runs them
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			l.LogCommand(c.Request.Context(), &zfs.CommandResult{Args: []string{"zfs", "create", "tank/vol"}, Duration: time.Millisecond, ExitStatus: 1, Stderr: "exists"})
+		}()
+		<-done
+		late = c.Request.Context()
+		c.String(http.StatusConflict, string(body))
+	})
+	route.POST("/jobs", func(c *gin.Context) {
+		cp := c.Copy()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			ctx, finish := Track(context.Background(), cp, "job-1")
+			l.LogCommand(ctx, &zfs.CommandResult{Args: []string{"zfs", "destroy", "tank/vol"}})
+			finish(v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Msg: "failed"}})
+		}()
+		<-done
+		c.Status(http.StatusAccepted)
+	})
+
+	body := `{"name":"tank/vol","password":"secret"}`
+	req := httptest.NewRequest("POST", "/volumes", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(RequestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+	route.ServeHTTP(w, req)
+	if w.Body.String() != body {
+		t.Errorf("handler read %q", w.Body.String())
+	}
+	if got := w.Header().Get(RequestIDHeader); got != "req-1" {
+		t.Errorf("got request id %q", got)
+	}
+
+	if zfs.RequestID(late) != "req-1" {
+		t.Errorf("got request id %q in the context", zfs.RequestID(late))
+	}
+	// commands finished after the request was logged, and commands of no
+	// request, are logged on their own
+	l.LogCommand(late, &zfs.CommandResult{Args: []string{"zpool", "status"}})
+	l.LogCommand(context.Background(), &zfs.CommandResult{Args: []string{"zpool", "list"}})
+
+	req = httptest.NewRequest("POST", "/jobs", nil)
+	req.Header.Set(RequestIDHeader, "not a valid id")
+	w = httptest.NewRecorder()
+	route.ServeHTTP(w, req)
+	generated := w.Header().Get(RequestIDHeader)
+	if generated == "" || generated == "not a valid id" {
+		t.Errorf("got request id %q", generated)
+	}
+
+	entries, err := l.Query(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("got %d entries, want 5", len(entries))
+	}
+	// newest first
+	call, job, standalone, orphan, create := entries[0], entries[1], entries[2], entries[3], entries[4]
+
+	if create.RequestID != "req-1" || create.Status != http.StatusConflict || create.Path != "/volumes" {
+		t.Errorf("unexpected entry %+v", create)
+	}
+	if string(create.Body) != `{"name":"tank/vol","password":"[redacted]"}` {
+		t.Errorf("got body %s", create.Body)
+	}
+	if len(create.Commands) != 1 || create.Commands[0].ExitStatus != 1 || create.Commands[0].Stderr != "exists" {
+		t.Errorf("got commands %+v", create.Commands)
+	}
+	if orphan.RequestID != "req-1" || len(orphan.Commands) != 1 || orphan.Commands[0].Args[1] != "status" {
+		t.Errorf("unexpected entry %+v", orphan)
+	}
+	if standalone.RequestID != "" || len(standalone.Commands) != 1 || standalone.Commands[0].Args[0] != "zpool" {
+		t.Errorf("unexpected entry %+v", standalone)
+	}
+	if job.RequestID != generated || job.Job != "job-1" || job.Error != "failed" || len(job.Commands) != 1 {
+		t.Errorf("unexpected entry %+v", job)
+	}
+	if call.RequestID != generated || call.Job != "" || call.Status != http.StatusAccepted || len(call.Commands) != 0 {
+		t.Errorf("unexpected entry %+v", call)
+	}
+
+	entries, err = l.Query(Query{RequestID: generated, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Job != "" {
+		t.Errorf("got %+v", entries)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l, cleanup := openTestLog(t, 300, 3)
+	defer cleanup()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		if err := l.Write(&Entry{Time: start.Add(time.Duration(i) * time.Hour), RequestID: string(rune('a' + i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	route := gin.New()
+	route.GET("/audit", l.HandleQuery)
+
+	for _, test := range []struct {
+		query string
+		code  int
+		ids   string
+	}{
+		{"", http.StatusOK, "jihgfedcba"},
+		{"?limit=3", http.StatusOK, "jih"},
+		{"?request_id=b", http.StatusOK, "b"},
+		{"?since=2020-01-01T02:00:00Z&until=2020-01-01T05:00:00Z", http.StatusOK, "edc"},
+		{"?identity=admin", http.StatusOK, ""},
+		{"?since=yesterday", http.StatusBadRequest, ""},
+		{"?limit=-1", http.StatusBadRequest, ""},
+	} {
+		w := httptest.NewRecorder()
+		route.ServeHTTP(w, httptest.NewRequest("GET", "/audit"+test.query, nil))
+		if w.Code != test.code {
+			t.Errorf("%s: got %d, want %d", test.query, w.Code, test.code)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var result struct {
+			Data []Entry `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		ids := ""
+		for _, e := range result.Data {
+			ids += e.RequestID
+		}
+		if ids != test.ids {
+			t.Errorf("%s: got %q, want %q", test.query, ids, test.ids)
+		}
+	}
+}
+
+func TestReverseLines(t *testing.T) {
+	defer func(n int64) { readChunk = n }(readChunk)
+
+	data := "a\n" + strings.Repeat("b", 10) + "\n\ncut"
+	for _, chunk := range []int64{1, 3, 64} {
+		readChunk = chunk
+		lines := &reverseLines{r: strings.NewReader(data), off: int64(len(data))}
+		var got []string
+		for {
+			line, err := lines.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, string(line))
+		}
+		if want := []string{"cut", "", strings.Repeat("b", 10), "a"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("chunk %d: got %q, want %q", chunk, got, want)
+		}
+	}
+}
+
+func TestQueryRotation(t *testing.T) {
+	l, cleanup := openTestLog(t, 300, 3)
+	defer cleanup()
+
+	write := func(from, to int) {
+		for i := from; i < to; i++ {
+			if err := l.Write(&Entry{Time: time.Now(), RequestID: string(rune('a' + i))}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	write(0, 5)
+
+	// writes and rotations while the files are read do not change the result
+	files, err := l.openFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer files.close()
+	write(5, 15)
+
+	entries, err := files.query(&Query{Limit: defaultLimit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := ""
+	for _, e := range entries {
+		ids += e.RequestID
+	}
+	if ids != "edcba" {
+		t.Errorf("got %q, want %q", ids, "edcba")
+	}
+}
@@ -0,0 +1,227 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
+	"github.com/garenwen/freebsd-manager/pkg/auth"
+	"github.com/garenwen/freebsd-manager/pkg/zfs"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader is the header of the request id.  A request id sent by the
+// client is kept, otherwise one is generated.  It is returned in the response.
+const RequestIDHeader = "X-Request-Id"
+
+// maxBody is the size up to which JSON request bodies are logged.
+const maxBody = 64 * 1024
+
+// requestIDRegex matches the request ids accepted from clients.
+var requestIDRegex = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// recordKey is the key of the record of a request in the gin context.
+const recordKey = "audit.record"
+
+// record is an entry in the making.
+type record struct {
+	log   *Log
+	start time.Time
+
+	mu       sync.Mutex
+	entry    Entry
+	finished bool
+}
+
+// addCommand adds a command to the entry, and reports whether it was not
+// written yet.
+func (rec *record) addCommand(cmd Command) bool {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.finished {
+		return false
+	}
+	rec.entry.Commands = append(rec.entry.Commands, cmd)
+	return true
+}
+
+func (rec *record) finish() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.finished = true
+	rec.entry.DurationMs = milliseconds(time.Since(rec.start))
+	rec.log.write(&rec.entry)
+}
+
+type contextKey struct{}
+
+// withRecord returns a copy of ctx whose commands are added to rec, and which
+// carries its request id.
+func withRecord(ctx context.Context, rec *record) context.Context {
+	ctx = zfs.WithRequestID(ctx, rec.entry.RequestID)
+	return context.WithValue(ctx, contextKey{}, rec)
+}
+
+func recordFromContext(ctx context.Context) *record {
+	rec, _ := ctx.Value(contextKey{}).(*record)
+	return rec
+}
+
+// Middleware returns a handler that logs every request, together with the
+// commands run with its context.
+func (l *Log) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !requestIDRegex.MatchString(id) {
+			id = uuid.New().String()
+		}
+		c.Header(RequestIDHeader, id)
+
+		rec := &record{
+			log:   l,
+			start: time.Now(),
+			entry: Entry{
+				Time:       time.Now(),
+				RequestID:  id,
+				RemoteAddr: c.ClientIP(),
+				Method:     c.Request.Method,
+				Path:       c.Request.URL.RequestURI(),
+				Body:       readBody(c),
+			},
+		}
+		c.Set(recordKey, rec)
+		c.Request = c.Request.WithContext(withRecord(c.Request.Context(), rec))
+		defer func() {
+			rec.mu.Lock()
+			rec.entry.Identity = auth.FromContext(c)
+			rec.entry.Status = c.Writer.Status()
+			if err := c.Errors.Last(); err != nil {
+				rec.entry.Error = err.Error()
+			}
+			rec.mu.Unlock()
+			rec.finish()
+		}()
+
+		c.Next()
+	}
+}
+
+// readBody returns the redacted JSON body of a request, if it is small enough
+// to be logged.  The body is left for the handler to read.
+func readBody(c *gin.Context) json.RawMessage {
+	if !strings.HasPrefix(c.ContentType(), "application/json") || c.Request.Body == nil {
+		return nil
+	}
+	head, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, maxBody+1))
+	c.Request.Body = readCloser{io.MultiReader(bytes.NewReader(head), c.Request.Body), c.Request.Body}
+	if err != nil || len(head) > maxBody {
+		return nil
+	}
+	return redact(head)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// secretRegex matches the names of fields holding secrets.
+var secretRegex = regexp.MustCompile(`(?i)password|passphrase|secret|token|credential|^key$`)
+
+// redact returns a JSON document with the values of fields named like secrets
+// replaced.
+func redact(data []byte) json.RawMessage {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	redacted, err := json.Marshal(redactValue(doc))
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+func redactValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if secretRegex.MatchString(key) {
+				v[key] = "[redacted]"
+			} else {
+				v[key] = redactValue(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	}
+	return val
+}
+
+func recordOf(c *gin.Context) *record {
+	if rec, ok := c.Get(recordKey); ok {
+		return rec.(*record)
+	}
+	return nil
+}
+
+// RequestID returns the id of a request, or an empty string if it is not
+// audited.
+func RequestID(c *gin.Context) string {
+	if rec := recordOf(c); rec != nil {
+		return rec.entry.RequestID
+	}
+	return ""
+}
+
+// Deny records why a request was not authorized.
+func Deny(c *gin.Context, reason string) {
+	if rec := recordOf(c); rec != nil {
+		rec.mu.Lock()
+		rec.entry.Denied = reason
+		rec.mu.Unlock()
+	}
+}
+
+// Track returns a copy of ctx, the context of the job of an asynchronous
+// request, whose commands are logged in an entry with the id of the job.  The
+// entry is written when the returned function is called with the result of
+// the job.  ctx is returned as is if the request is not audited.
+func Track(ctx context.Context, c *gin.Context, job string) (context.Context, func(result v1.BaseResult)) {
+	parent := recordOf(c)
+	if parent == nil {
+		return ctx, func(v1.BaseResult) {}
+	}
+
+	rec := &record{
+		log:   parent.log,
+		start: time.Now(),
+		entry: Entry{
+			Time:       time.Now(),
+			RequestID:  parent.entry.RequestID,
+			Job:        job,
+			Identity:   auth.FromContext(c),
+			RemoteAddr: parent.entry.RemoteAddr,
+			Method:     parent.entry.Method,
+			Path:       parent.entry.Path,
+		},
+	}
+	return withRecord(ctx, rec), func(result v1.BaseResult) {
+		if result.ApiError != nil {
+			rec.mu.Lock()
+			rec.entry.Error = result.ApiError.Msg
+			rec.mu.Unlock()
+		}
+		rec.finish()
+	}
+}
@@ -0,0 +1,208 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultLimit is the number of entries returned by queries without a limit.
+const defaultLimit = 100
+
+// Query selects entries of the log.  Zero fields match any entry.
+type Query struct {
+	RequestID string
+	// Identity is the name of the caller.
+	Identity string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+func (q *Query) matches(e *Entry) bool {
+	if q.RequestID != "" && e.RequestID != q.RequestID {
+		return false
+	}
+	if q.Identity != "" && (e.Identity == nil || e.Identity.Name != q.Identity) {
+		return false
+	}
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !e.Time.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// Query returns the entries of the current and the old files that match q,
+// newest first.
+func (l *Log) Query(q Query) ([]Entry, error) {
+	if q.Limit <= 0 {
+		q.Limit = defaultLimit
+	}
+
+	files, err := l.openFiles()
+	if err != nil {
+		return nil, err
+	}
+	defer files.close()
+	return files.query(&q)
+}
+
+// logFiles are the current and the old files of a log, newest first, as
+// opened by openFiles.
+type logFiles struct {
+	files []*os.File
+	// size is the size of the current file when it was opened, up to which
+	// it only holds whole lines
+	size int64
+}
+
+// openFiles opens the current and the old files.  The files are opened
+// together under the lock, so that they are not rotated in between, and
+// stay the same files while they are read without it.
+func (l *Log) openFiles() (*logFiles, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lf := &logFiles{size: l.size}
+	for i := 0; i <= l.maxFiles; i++ {
+		f, err := os.Open(l.rotated(i))
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			lf.close()
+			return nil, err
+		}
+		lf.files = append(lf.files, f)
+	}
+	return lf, nil
+}
+
+func (lf *logFiles) close() {
+	for _, f := range lf.files {
+		f.Close()
+	}
+}
+
+// query reads the files backwards, until q.Limit entries matched.
+func (lf *logFiles) query(q *Query) ([]Entry, error) {
+	entries := []Entry{}
+	for i, f := range lf.files {
+		size := lf.size
+		if i > 0 {
+			fi, err := f.Stat()
+			if err != nil {
+				return nil, err
+			}
+			size = fi.Size()
+		}
+		lines := &reverseLines{r: f, off: size}
+		for len(entries) < q.Limit {
+			line, err := lines.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			var e Entry
+			// lines cut off by a crash are skipped
+			if json.Unmarshal(line, &e) == nil && q.matches(&e) {
+				entries = append(entries, e)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// readChunk is the size of the chunks in which files are read backwards.
+var readChunk int64 = 64 * 1024
+
+// reverseLines reads the lines of a file backwards from an offset.
+type reverseLines struct {
+	r io.ReaderAt
+	// off is the offset of buf in the file
+	off int64
+	// buf holds the bytes before the lines returned so far
+	buf []byte
+}
+
+// next returns the line before the last returned one, without its newline,
+// or io.EOF at the start of the file.
+func (s *reverseLines) next() ([]byte, error) {
+	for {
+		if i := bytes.LastIndexByte(s.buf, '\n'); i >= 0 {
+			line := s.buf[i+1:]
+			s.buf = s.buf[:i]
+			return line, nil
+		}
+		if s.off == 0 {
+			if len(s.buf) == 0 {
+				return nil, io.EOF
+			}
+			line := s.buf
+			s.buf = nil
+			return line, nil
+		}
+		n := readChunk
+		if n > s.off {
+			n = s.off
+		}
+		buf := make([]byte, n+int64(len(s.buf)))
+		if _, err := s.r.ReadAt(buf[:n], s.off-n); err != nil {
+			return nil, err
+		}
+		copy(buf[n:], s.buf)
+		s.off -= n
+		s.buf = buf
+	}
+}
+
+// HandleQuery answers a query of the log, selected by the request_id,
+// identity, since, until (RFC 3339) and limit query parameters.
+func (l *Log) HandleQuery(c *gin.Context) {
+	result := l.query(c)
+	code := http.StatusOK
+	if result.ApiError != nil {
+		code = http.StatusBadRequest
+		if result.ApiError.Typ == v1.ErrorInternal {
+			code = http.StatusInternalServerError
+		}
+	}
+	c.JSON(code, result)
+}
+
+func (l *Log) query(c *gin.Context) v1.BaseResult {
+	q := Query{RequestID: c.Query("request_id"), Identity: c.Query("identity")}
+	var err error
+	if since := c.Query("since"); since != "" {
+		if q.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: "invalid since: " + err.Error()}}
+		}
+	}
+	if until := c.Query("until"); until != "" {
+		if q.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: "invalid until: " + err.Error()}}
+		}
+	}
+	if limit := c.Query("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit < 0 {
+			return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: "invalid limit: " + limit}}
+		}
+	}
+
+	entries, err := l.Query(q)
+	if err != nil {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorInternal, Msg: err.Error()}}
+	}
+	return v1.BaseResult{Status: v1.StatusSuccess, Data: entries, ApiError: nil}
+}
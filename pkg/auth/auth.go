@@ -0,0 +1,158 @@
+// Package auth authenticates the callers of the API servers.
+package auth
+
+import (
+	"errors"
+	"net/http"
+
+	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
+	"github.com/gin-gonic/gin"
+)
+
+// Methods of authentication.
+const (
+	MethodToken       = "token"
+	MethodBasic       = "basic"
+	MethodHMAC        = "hmac"
+	MethodCertificate = "certificate"
+)
+
+// ErrUnauthenticated is returned for requests with missing or invalid
+// credentials.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Identity is an authenticated caller.
+type Identity struct {
+	Name string `json:"name"`
+	// Method is one of the Method constants.
+	Method string `json:"method"`
+	// UnsignedPayload is set for HMAC signed requests whose body is not
+	// signed.
+	UnsignedPayload bool `json:"-"`
+}
+
+// Authenticator authenticates the caller of a request.
+type Authenticator interface {
+	// Authenticate returns the identity of the caller, or nil if the request
+	// carries no credentials this Authenticator knows about.  An error is
+	// returned for invalid credentials.
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// identityKey is the key of the identity in the gin context.
+const identityKey = "auth.identity"
+
+// FromContext returns the identity of the caller, or nil if the request was
+// not authenticated.
+func FromContext(c *gin.Context) *Identity {
+	if id, ok := c.Get(identityKey); ok {
+		return id.(*Identity)
+	}
+	return nil
+}
+
+// Anonymous is the subject of callers that were not authenticated.
+const Anonymous = "anonymous"
+
+// Subject returns the subject of the caller of a request, which policies bind
+// roles to, and which owns the jobs and idempotency keys of the caller.
+func Subject(c *gin.Context) string {
+	if id := FromContext(c); id != nil {
+		return id.Subject()
+	}
+	return Anonymous
+}
+
+// Subject returns the name of the identity prefixed with its method, such as
+// token:prometheus or certificate:node1, as the same name may belong to other
+// callers under other methods, e.g. the CN of any certificate of the CA.
+func (id *Identity) Subject() string {
+	return id.Method + ":" + id.Name
+}
+
+// unscopedKey is the key in the gin context of whether the caller of a
+// request is authorized for all datasets.
+const unscopedKey = "auth.unscoped"
+
+// SetUnscoped records whether the caller of a request is authorized for all
+// datasets, rather than for some of them, as decided by the authorization of
+// the request.
+func SetUnscoped(c *gin.Context, unscoped bool) {
+	c.Set(unscopedKey, unscoped)
+}
+
+// Unscoped reports whether the caller of a request is authorized for all
+// datasets.  Handlers of resources which are not datasets, such as jobs,
+// limit the other callers to their own.
+func Unscoped(c *gin.Context) bool {
+	return c.GetBool(unscopedKey)
+}
+
+// deniedPropertiesKey is the key in the gin context of the properties the
+// caller of a request may not set.
+const deniedPropertiesKey = "auth.deniedProperties"
+
+// SetDeniedProperties records the properties the caller of a request may not
+// set on the datasets of the request, as decided by its authorization.
+func SetDeniedProperties(c *gin.Context, props []string) {
+	c.Set(deniedPropertiesKey, props)
+}
+
+// DeniedProperties returns the properties the caller of a request may not
+// set.  Handlers keep zfs from setting them on behalf of the caller, such as
+// from the properties of a received stream.
+func DeniedProperties(c *gin.Context) []string {
+	return c.GetStringSlice(deniedPropertiesKey)
+}
+
+// Middleware returns a handler that authenticates requests with the first of
+// authenticators which accepts their credentials, and rejects them with 401
+// Unauthorized otherwise.
+func Middleware(authenticators ...Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, a := range authenticators {
+			id, err := a.Authenticate(c.Request)
+			if err != nil {
+				unauthorized(c, err)
+				return
+			}
+			if id != nil {
+				c.Set(identityKey, id)
+				c.Next()
+				return
+			}
+		}
+		unauthorized(c, ErrUnauthenticated)
+	}
+}
+
+// errUnsignedPayload is returned for requests with an unsigned payload on
+// routes which require a signed one.
+var errUnsignedPayload = errors.New("payload must be signed")
+
+// SignedPayload returns a handler that rejects requests with an unsigned
+// payload with 401 Unauthorized, except on the routes with the specified full
+// paths, such as those of snapshot streams.
+func SignedPayload(routes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if id := FromContext(c); id != nil && id.UnsignedPayload && !contains(routes, c.FullPath()) {
+			unauthorized(c, errUnsignedPayload)
+			return
+		}
+		c.Next()
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func unauthorized(c *gin.Context, err error) {
+	c.Header("WWW-Authenticate", `Basic realm="freebsd-manager"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorUnauthorized, Msg: err.Error()}})
+}
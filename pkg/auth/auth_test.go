@@ -0,0 +1,271 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeCredentials(t *testing.T, path string, creds *Credentials) {
+	data, err := json.Marshal(creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testStore(t *testing.T) *Store {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "credentials.json")
+	writeCredentials(t, path, &Credentials{
+		Tokens:   []Token{{Name: "csi", Token: "t0ken"}},
+		Users:    []string{"alice:" + string(hash)},
+		HMACKeys: []HMACKey{{ID: "replication", Secret: "shared"}},
+	})
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStoreAuthenticate(t *testing.T) {
+	s := testStore(t)
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+
+	signed := func(keyID, secret string, at time.Time) *http.Request {
+		r := httptest.NewRequest("DELETE", "/apis/storage/v1/volumes/tank%2Fvol?recursive=true", nil)
+		Sign(r, keyID, []byte(secret), at)
+		return r
+	}
+
+	var tests = []struct {
+		name    string
+		req     *http.Request
+		want    *Identity
+		wantErr bool
+	}{
+		{"anonymous", httptest.NewRequest("GET", "/", nil), nil, false},
+		{"token", withHeader("Authorization", "Bearer t0ken"), &Identity{Name: "csi", Method: MethodToken}, false},
+		{"bad token", withHeader("Authorization", "Bearer t0ke"), nil, true},
+		{"basic", withBasicAuth("alice", "secret"), &Identity{Name: "alice", Method: MethodBasic}, false},
+		{"bad password", withBasicAuth("alice", "wrong"), nil, true},
+		{"unknown user", withBasicAuth("bob", "secret"), nil, true},
+		{"hmac", signed("replication", "shared", now), &Identity{Name: "replication", Method: MethodHMAC}, false},
+		{"hmac within skew", signed("replication", "shared", now.Add(-time.Minute)), &Identity{Name: "replication", Method: MethodHMAC}, false},
+		{"hmac expired", signed("replication", "shared", now.Add(-time.Hour)), nil, true},
+		{"hmac bad secret", signed("replication", "other", now), nil, true},
+		{"hmac unknown key", signed("backup", "shared", now), nil, true},
+	}
+
+	for _, test := range tests {
+		id, err := s.Authenticate(test.req)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if (id == nil) != (test.want == nil) || id != nil && *id != *test.want {
+			t.Errorf("%s: got identity %+v, want %+v", test.name, id, test.want)
+		}
+	}
+}
+
+func TestStoreSignedURIMismatch(t *testing.T) {
+	s := testStore(t)
+	r := httptest.NewRequest("DELETE", "/apis/storage/v1/volumes/tank%2Fvol", nil)
+	Sign(r, "replication", []byte("shared"), time.Now())
+	r.URL.RawQuery = "recursive=true"
+	r.RequestURI = r.URL.RequestURI()
+	if _, err := s.Authenticate(r); err == nil {
+		t.Fatal("request with a modified URI was authenticated")
+	}
+}
+
+func TestStoreSignedBody(t *testing.T) {
+	s := testStore(t)
+	signed := func(body string) *http.Request {
+		r, err := http.NewRequest("POST", "/apis/storage/v1/filesystems", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		Sign(r, "replication", []byte("shared"), time.Now())
+		return r
+	}
+
+	r := signed(`{"name":"tank/fs"}`)
+	if _, err := s.Authenticate(r); err != nil {
+		t.Fatal(err)
+	}
+	// the body is kept for the handler
+	if body, _ := ioutil.ReadAll(r.Body); string(body) != `{"name":"tank/fs"}` {
+		t.Errorf("got body %q", body)
+	}
+
+	r = signed(`{"name":"tank/fs"}`)
+	r.Body = ioutil.NopCloser(strings.NewReader(`{"name":"tank/other"}`))
+	if _, err := s.Authenticate(r); err == nil {
+		t.Error("request with a modified body was authenticated")
+	}
+
+	r = signed("")
+	r.Header.Del(ContentSHA256Header)
+	if _, err := s.Authenticate(r); err == nil {
+		t.Error("request without a content digest was authenticated")
+	}
+}
+
+func TestStoreReplay(t *testing.T) {
+	s := testStore(t)
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+
+	r := httptest.NewRequest("DELETE", "/apis/storage/v1/volumes/tank%2Fvol", nil)
+	Sign(r, "replication", []byte("shared"), now)
+	if _, err := s.Authenticate(r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authenticate(r); err == nil {
+		t.Error("replayed request was authenticated")
+	}
+
+	// signatures are forgotten once their timestamp is too old anyway
+	now = now.Add(MaxClockSkew + time.Second)
+	Sign(r, "replication", []byte("shared"), now)
+	if _, err := s.Authenticate(r); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.seen.expires); n != 1 {
+		t.Errorf("kept %d signatures", n)
+	}
+}
+
+func TestSignedPayload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := testStore(t)
+	route := gin.New()
+	route.Use(Middleware(s), SignedPayload("/stream/:name"))
+	route.PUT("/stream/:name", func(c *gin.Context) { c.Status(http.StatusOK) })
+	route.PUT("/json/:name", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, test := range []struct {
+		path string
+		code int
+	}{
+		{"/stream/tank", http.StatusOK},
+		{"/json/tank", http.StatusUnauthorized},
+	} {
+		// readers without GetBody are not signed
+		r, err := http.NewRequest("PUT", test.path, ioutil.NopCloser(strings.NewReader("stream")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		Sign(r, "replication", []byte("shared"), time.Now())
+		if got := r.Header.Get(ContentSHA256Header); got != UnsignedPayload {
+			t.Fatalf("got content digest %q", got)
+		}
+		r.RequestURI = test.path
+		w := httptest.NewRecorder()
+		route.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s: got %d, want %d", test.path, w.Code, test.code)
+		}
+	}
+}
+
+func TestStoreWatch(t *testing.T) {
+	s := testStore(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Watch(ctx, 10*time.Millisecond)
+
+	writeCredentials(t, s.path, &Credentials{Tokens: []Token{{Name: "csi", Token: "rotated"}}})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		id, _ := s.Authenticate(withHeader("Authorization", "Bearer rotated"))
+		if id != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("credentials were not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := s.Authenticate(withHeader("Authorization", "Bearer t0ken")); err == nil {
+		t.Error("old token is still accepted")
+	}
+
+	// invalid files are ignored
+	if err := ioutil.WriteFile(s.path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reload(); err == nil {
+		t.Error("invalid credentials file was loaded")
+	}
+	if id, _ := s.Authenticate(withHeader("Authorization", "Bearer rotated")); id == nil {
+		t.Error("credentials were dropped by a failed reload")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := testStore(t)
+	route := gin.New()
+	route.Use(Middleware(s))
+	route.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, Subject(c))
+	})
+
+	w := httptest.NewRecorder()
+	route.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("anonymous request: got %d %v", w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	route.ServeHTTP(w, withHeader("Authorization", "Bearer t0ken"))
+	if w.Code != http.StatusOK || w.Body.String() != "token:csi" {
+		t.Errorf("token request: got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSubject(t *testing.T) {
+	token := &Identity{Name: "admin", Method: MethodToken}
+	cert := &Identity{Name: "admin", Method: MethodCertificate}
+	if token.Subject() == cert.Subject() {
+		t.Errorf("certificate and token of the same name have the subject %s", token.Subject())
+	}
+}
+
+func withHeader(key, val string) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(key, val)
+	return r
+}
+
+func withBasicAuth(user, password string) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth(user, password)
+	return r
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"crypto/x509"
+	"net/http"
+)
+
+// Certificate authenticates callers by their TLS client certificate, which
+// must have been verified during the handshake.  The identity is the common
+// name of the certificate, whose subject is certificate:<CN>, so that it does
+// not get the roles of a token or user of the same name.
+type Certificate struct{}
+
+// Authenticate implements Authenticator.
+func (Certificate) Authenticate(r *http.Request) (*Identity, error) {
+	cert := ClientCertificate(r)
+	if cert == nil {
+		return nil, nil
+	}
+	if cert.Subject.CommonName == "" {
+		return nil, ErrUnauthenticated
+	}
+	return &Identity{Name: cert.Subject.CommonName, Method: MethodCertificate}, nil
+}
+
+// ClientCertificate returns the verified client certificate of a request, or
+// nil if the caller did not present one.
+func ClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
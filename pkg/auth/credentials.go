@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Credentials are the credentials accepted by a Store, as read from a JSON
+// file such as:
+//
+//	{
+//		"tokens": [{"name": "csi", "token": "..."}],
+//		"users": ["alice:$2y$10$..."],
+//		"hmac_keys": [{"id": "replication", "secret": "..."}]
+//	}
+//
+// Users are htpasswd lines with bcrypt hashes, as written by htpasswd -B.
+type Credentials struct {
+	Tokens   []Token   `json:"tokens"`
+	Users    []string  `json:"users"`
+	HMACKeys []HMACKey `json:"hmac_keys"`
+}
+
+// Token is a static bearer token of a caller.
+type Token struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+}
+
+// HMACKey is a shared secret of a caller that signs its requests.
+type HMACKey struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+// credentials are Credentials prepared for lookups.
+type credentials struct {
+	// tokens maps the sha256 sum of a token to the name of its caller
+	tokens map[[sha256.Size]byte]string
+	users  map[string][]byte
+	keys   map[string][]byte
+}
+
+func newCredentials(creds *Credentials) (*credentials, error) {
+	c := &credentials{
+		tokens: make(map[[sha256.Size]byte]string),
+		users:  make(map[string][]byte),
+		keys:   make(map[string][]byte),
+	}
+	for _, t := range creds.Tokens {
+		if t.Name == "" || t.Token == "" {
+			return nil, fmt.Errorf("token without name or value")
+		}
+		c.tokens[sha256.Sum256([]byte(t.Token))] = t.Name
+	}
+	for _, line := range creds.Users {
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid htpasswd line %q", line)
+		}
+		user, hash := line[:i], []byte(line[i+1:])
+		if _, err := bcrypt.Cost(hash); err != nil {
+			return nil, fmt.Errorf("user %s: %v", user, err)
+		}
+		c.users[user] = hash
+	}
+	for _, k := range creds.HMACKeys {
+		if k.ID == "" || k.Secret == "" {
+			return nil, fmt.Errorf("hmac key without id or secret")
+		}
+		c.keys[k.ID] = []byte(k.Secret)
+	}
+	return c, nil
+}
+
+// Store authenticates requests with bearer tokens, basic auth or HMAC
+// signatures, using the credentials of a file.
+type Store struct {
+	path string
+
+	mu      sync.RWMutex
+	creds   *credentials
+	modTime time.Time
+	size    int64
+
+	// now returns the current time, to check HMAC timestamps
+	now func() time.Time
+	// seen are the HMAC signatures accepted within the clock skew, which
+	// survive reloads
+	seen signatures
+}
+
+// NewStore returns a Store with the credentials of the file at path.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, now: time.Now}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the credentials file again.  The previous credentials are kept
+// if it cannot be read.
+func (s *Store) Reload() error {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	creds := &Credentials{}
+	if err := json.Unmarshal(data, creds); err != nil {
+		return fmt.Errorf("%s: %v", s.path, err)
+	}
+	c, err := newCredentials(creds)
+	if err != nil {
+		return fmt.Errorf("%s: %v", s.path, err)
+	}
+
+	s.mu.Lock()
+	s.creds, s.modTime, s.size = c, fi.ModTime(), fi.Size()
+	s.mu.Unlock()
+	return nil
+}
+
+// changed reports whether the credentials file was modified since it was
+// last read.
+func (s *Store) changed() bool {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !fi.ModTime().Equal(s.modTime) || fi.Size() != s.size
+}
+
+// Watch reloads the credentials file whenever it changes, checking it every
+// interval until ctx is done.
+func (s *Store) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !s.changed() {
+			continue
+		}
+		if err := s.Reload(); err != nil {
+			glog.Errorln("failed to reload credentials", err)
+			continue
+		}
+		glog.Infoln("reloaded credentials from", s.path)
+	}
+}
+
+func (s *Store) credentials() *credentials {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.creds
+}
+
+// Authenticate implements Authenticator.
+func (s *Store) Authenticate(r *http.Request) (*Identity, error) {
+	creds := s.credentials()
+	if r.Header.Get(SignatureHeader) != "" {
+		return creds.authenticateHMAC(r, s.now(), &s.seen)
+	}
+
+	authorization := r.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(authorization, "Bearer "):
+		return creds.authenticateToken(strings.TrimPrefix(authorization, "Bearer "))
+	case strings.HasPrefix(authorization, "Basic "):
+		user, password, ok := r.BasicAuth()
+		if !ok {
+			return nil, ErrUnauthenticated
+		}
+		return creds.authenticateBasic(user, password)
+	}
+	return nil, nil
+}
+
+func (c *credentials) authenticateToken(token string) (*Identity, error) {
+	sum := sha256.Sum256([]byte(token))
+	// compare all tokens, so the time taken does not tell which is close
+	name := ""
+	for t, n := range c.tokens {
+		if subtle.ConstantTimeCompare(sum[:], t[:]) == 1 {
+			name = n
+		}
+	}
+	if name == "" {
+		return nil, ErrUnauthenticated
+	}
+	return &Identity{Name: name, Method: MethodToken}, nil
+}
+
+func (c *credentials) authenticateBasic(user, password string) (*Identity, error) {
+	hash, ok := c.users[user]
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		return nil, ErrUnauthenticated
+	}
+	return &Identity{Name: user, Method: MethodBasic}, nil
+}
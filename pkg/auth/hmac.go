@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers of a request signed with an HMAC key.
+const (
+	KeyIDHeader         = "X-Auth-Key-Id"
+	TimestampHeader     = "X-Auth-Timestamp"
+	ContentSHA256Header = "X-Auth-Content-SHA256"
+	SignatureHeader     = "X-Auth-Signature"
+)
+
+// UnsignedPayload is the content digest of a request whose body is not
+// signed, such as a snapshot stream, which cannot be read twice.  It is only
+// accepted on the routes allowed by SignedPayload.
+const UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// MaxClockSkew is how far the timestamp of a signed request may be off the
+// clock of the server.  A signature is only accepted once within this window.
+const MaxClockSkew = 5 * time.Minute
+
+// Signature returns the hex encoded HMAC-SHA256 of a request, which signs its
+// method, request URI, timestamp and content digest, separated by newlines.
+// The request URI is the escaped path and query, as sent in the request line.
+// The content digest is the hex encoded SHA-256 of the body, or
+// UnsignedPayload.
+func Signature(secret []byte, method, requestURI, timestamp, contentSHA256 string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + requestURI + "\n" + timestamp + "\n" + contentSHA256))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign adds the headers of a request signed at time now with the HMAC key of
+// the specified id.  The body is signed if it can be read again with
+// GetBody, as for the bodies of http.NewRequest, unless the content digest
+// header is already set, e.g. to UnsignedPayload.
+func Sign(r *http.Request, keyID string, secret []byte, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	digest := r.Header.Get(ContentSHA256Header)
+	if digest == "" {
+		digest = contentSHA256(r)
+	}
+	r.Header.Set(KeyIDHeader, keyID)
+	r.Header.Set(TimestampHeader, timestamp)
+	r.Header.Set(ContentSHA256Header, digest)
+	r.Header.Set(SignatureHeader, Signature(secret, r.Method, r.URL.RequestURI(), timestamp, digest))
+}
+
+// contentSHA256 returns the content digest of the body of a client request,
+// or UnsignedPayload if it cannot be read twice.
+func contentSHA256(r *http.Request) string {
+	if r.Body == nil || r.Body == http.NoBody {
+		return digest(nil)
+	}
+	if r.GetBody == nil {
+		return UnsignedPayload
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return UnsignedPayload
+	}
+	defer body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return UnsignedPayload
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *credentials) authenticateHMAC(r *http.Request, now time.Time, seen *signatures) (*Identity, error) {
+	keyID := r.Header.Get(KeyIDHeader)
+	secret, ok := c.keys[keyID]
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	timestamp := r.Header.Get(TimestampHeader)
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+	signedAt := time.Unix(sec, 0)
+	if skew := now.Sub(signedAt); skew > MaxClockSkew || skew < -MaxClockSkew {
+		return nil, ErrUnauthenticated
+	}
+
+	// the request URI of a server request is the one the client signed
+	requestURI := r.RequestURI
+	if requestURI == "" {
+		requestURI = r.URL.RequestURI()
+	}
+	contentSHA256 := r.Header.Get(ContentSHA256Header)
+	if contentSHA256 == "" {
+		return nil, ErrUnauthenticated
+	}
+	signature := r.Header.Get(SignatureHeader)
+	want := Signature(secret, r.Method, requestURI, timestamp, contentSHA256)
+	if !hmac.Equal([]byte(want), []byte(signature)) {
+		return nil, ErrUnauthenticated
+	}
+
+	id := &Identity{Name: keyID, Method: MethodHMAC}
+	if contentSHA256 == UnsignedPayload {
+		id.UnsignedPayload = true
+	} else if err := checkBody(r, contentSHA256); err != nil {
+		return nil, err
+	}
+
+	// checked last, so that a forged request cannot use up a signature
+	if !seen.add(keyID+"\n"+signature, signedAt.Add(MaxClockSkew), now) {
+		return nil, ErrUnauthenticated
+	}
+	return id, nil
+}
+
+// checkBody reads the body of a server request, which is kept for the
+// handler, and checks its content digest.
+func checkBody(r *http.Request, contentSHA256 string) error {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			return err
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if !hmac.Equal([]byte(digest(body)), []byte(contentSHA256)) {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+// signatures are the signatures accepted within the clock skew, which are
+// rejected when they are replayed.
+type signatures struct {
+	mu sync.Mutex
+	// expires maps the key id and signature to the time the signature is
+	// rejected anyway for its timestamp
+	expires map[string]time.Time
+}
+
+// add records a signature until expires, and reports whether it was not
+// recorded before.  Expired signatures are dropped.
+func (s *signatures) add(signature string, expires, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.expires == nil {
+		s.expires = make(map[string]time.Time)
+	}
+	for sig, t := range s.expires {
+		if now.After(t) {
+			delete(s.expires, sig)
+		}
+	}
+	if _, ok := s.expires[signature]; ok {
+		return false
+	}
+	s.expires[signature] = expires
+	return true
+}
@@ -1,7 +1,11 @@
 package client
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 
 	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
 )
@@ -9,6 +13,56 @@ import (
 type Config struct {
 	Url    string
 	APIVer string
+
+	// Credentials of the caller, at most one of them is used: a bearer
+	// token, a user and password, or an HMAC key which signs the requests.
+	Token      string
+	Username   string
+	Password   string
+	HMACKeyID  string
+	HMACSecret string
+
+	// TLS files: the CA of the server certificate, if it is not signed by a
+	// system CA, and the certificate and key of the client, if the server
+	// asks for one.
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// HttpClient returns a client with the TLS files of the config, or nil if
+// there are none.
+func (conf *Config) HttpClient() (*http.Client, error) {
+	if "" == conf.CAFile && "" == conf.CertFile {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if "" != conf.CAFile {
+		pem, err := ioutil.ReadFile(conf.CAFile)
+		if nil != err {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", conf.CAFile)
+		}
+	}
+	if "" != conf.CertFile {
+		keyFile := conf.KeyFile
+		if "" == keyFile {
+			keyFile = conf.CertFile
+		}
+		cert, err := tls.LoadX509KeyPair(conf.CertFile, keyFile)
+		if nil != err {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}, nil
 }
 
 type response struct {
@@ -12,6 +12,9 @@ import (
 	"net/url"
 	"path"
 	"strings"
+	"time"
+
+	"github.com/garenwen/freebsd-manager/pkg/auth"
 )
 
 // Class for HTTP request
@@ -31,8 +34,13 @@ type HttpRequest struct {
 	reqBody io.Reader
 	// HTTP response
 	resp *http.Response
+	// HTTP client to send the request with, http.DefaultClient if nil
+	client *http.Client
 	// Debug flag
 	debug bool
+	// HMAC key to sign the request with
+	hmacKeyID  string
+	hmacSecret []byte
 }
 
 // New HttpRequest object with specified URL
@@ -46,7 +54,7 @@ func NewHttpReq(u url.URL) *HttpRequest {
 // Set path of URL
 func (r *HttpRequest) BasicAuth(username, password string) *HttpRequest {
 	if nil == r.err {
-		r.headers.Set("Authorization", authorizationHeader("measure", "measure"))
+		r.headers.Set("Authorization", authorizationHeader(username, password))
 	}
 
 	return r
@@ -56,6 +64,25 @@ func authorizationHeader(user, password string) string {
 	return "Basic " + base64.StdEncoding.EncodeToString([]byte(base))
 }
 
+// Set bearer token of the request
+func (r *HttpRequest) BearerToken(token string) *HttpRequest {
+	if nil == r.err {
+		r.headers.Set("Authorization", "Bearer "+token)
+	}
+
+	return r
+}
+
+// Sign the request with an HMAC key when it is sent
+func (r *HttpRequest) HMAC(keyID string, secret []byte) *HttpRequest {
+	if nil == r.err {
+		r.hmacKeyID = keyID
+		r.hmacSecret = secret
+	}
+
+	return r
+}
+
 // Set path of URL
 func (r *HttpRequest) Path(path string) *HttpRequest {
 	if nil == r.err {
@@ -87,6 +114,15 @@ func (r *HttpRequest) RawSubPath(subPath string) *HttpRequest {
 	return r
 }
 
+// Set HTTP client to send the request with
+func (r *HttpRequest) Client(client *http.Client) *HttpRequest {
+	if nil == r.err {
+		r.client = client
+	}
+
+	return r
+}
+
 // Set HTTP method
 func (r *HttpRequest) Method(m string) *HttpRequest {
 	if nil == r.err {
@@ -187,7 +223,11 @@ func (r *HttpRequest) DoRaw() (*http.Response, error) {
 
 	if r.debug {
 		log.Println("request url:", u)
-		log.Println("request headers:", r.headers)
+		headers := r.headers.Clone()
+		if "" != headers.Get("Authorization") {
+			headers.Set("Authorization", "[redacted]")
+		}
+		log.Println("request headers:", headers)
 		log.Println("request method:", r.method)
 	}
 
@@ -197,8 +237,15 @@ func (r *HttpRequest) DoRaw() (*http.Response, error) {
 	}
 
 	req.Header = r.headers
+	if "" != r.hmacKeyID {
+		auth.Sign(req, r.hmacKeyID, r.hmacSecret, time.Now())
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	client := r.client
+	if nil == client {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
 	if nil != err {
 		return nil, fmt.Errorf("Request remote error: %v \n", err)
 	}
@@ -1,21 +1,37 @@
 package v1
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
 	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
 	"github.com/garenwen/freebsd-manager/pkg/client"
+	"github.com/garenwen/freebsd-manager/pkg/zfs"
 )
 
 const (
 	createVolume = "/create_volume"
+	expandVolume = "/expand_volume"
+	volumes      = "/volumes"
+	filesystems  = "/filesystems"
+	snapshots    = "/snapshots"
+	exports      = "/exports"
+	space        = "/space"
+	pools        = "/pools"
+	datasets     = "/datasets"
+	jobs         = "/jobs"
 )
 
 type Client struct {
-	url    *url.URL `env:"URL"`
-	apiVer string
+	url        *url.URL `env:"URL"`
+	apiVer     string
+	conf       client.Config
+	httpClient *http.Client
 }
 
 func NewClientOrDie(conf client.Config) *Client {
@@ -37,14 +53,30 @@ func NewClient(conf client.Config) (*Client, error) {
 		conf.APIVer = "v1"
 	}
 
+	httpClient, err := conf.HttpClient()
+	if nil != err {
+		return nil, fmt.Errorf("load tls files error: %v", err)
+	}
+
 	return &Client{
-		url:    u,
-		apiVer: conf.APIVer,
+		url:        u,
+		apiVer:     conf.APIVer,
+		conf:       conf,
+		httpClient: httpClient,
 	}, nil
 }
 
 func (c *Client) newRequest() *client.HttpRequest {
-	return client.NewHttpReq(*c.url).Path("apis/storage/" + c.apiVer)
+	r := client.NewHttpReq(*c.url).Path("apis/storage/" + c.apiVer).Client(c.httpClient)
+	switch {
+	case c.conf.Token != "":
+		r.BearerToken(c.conf.Token)
+	case c.conf.Username != "":
+		r.BasicAuth(c.conf.Username, c.conf.Password)
+	case c.conf.HMACKeyID != "":
+		r.HMAC(c.conf.HMACKeyID, []byte(c.conf.HMACSecret))
+	}
+	return r
 }
 
 // Query namespace based on username or userid.
@@ -64,3 +96,669 @@ func (c *Client) CreateVolume(req *v1.CreateVolumeRequest) (*v1.CreateVolumeResp
 
 	return cvResp, nil
 }
+
+// ExpandVolume grows a volume to at least the required capacity.
+func (c *Client) ExpandVolume(req *v1.ControllerExpandVolumeRequest) (*v1.ControllerExpandVolumeResponse, error) {
+
+	var evResp *v1.ControllerExpandVolumeResponse
+	hr := c.newRequest().Debug().
+		Method(http.MethodPost).
+		SubPath(expandVolume).
+		JsonBody(req).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&evResp); err != nil {
+		return nil, err
+	}
+
+	return evResp, nil
+}
+
+// ListVolumes lists the volumes selected by opts, or all volumes if opts is
+// nil.
+func (c *Client) ListVolumes(opts *v1.ListVolumesOptions) ([]v1.Volume, error) {
+
+	query := url.Values{}
+	if opts != nil {
+		if opts.Filter != "" {
+			query.Set("filter", opts.Filter)
+		}
+		if opts.Origin != "" {
+			query.Set("origin", opts.Origin)
+		}
+		if opts.MinSize != 0 {
+			query.Set("min_size", opts.MinSize.Exact())
+		}
+		if opts.MaxSize != 0 {
+			query.Set("max_size", opts.MaxSize.Exact())
+		}
+	}
+
+	var list []v1.Volume
+	hr := c.newRequest().Debug().
+		Method(http.MethodGet).
+		SubPath(volumes).
+		Query(query).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&list); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// GetVolume returns a volume by name.
+func (c *Client) GetVolume(name string) (*v1.Volume, error) {
+
+	var volume *v1.Volume
+	hr := c.newRequest().Debug().
+		Method(http.MethodGet).
+		RawSubPath(volumes + "/" + url.PathEscape(name)).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&volume); err != nil {
+		return nil, err
+	}
+
+	return volume, nil
+}
+
+// UpdateVolume sets the properties of a volume and resizes it.
+func (c *Client) UpdateVolume(name string, req *v1.UpdateVolumeRequest) (*v1.Volume, error) {
+
+	var volume *v1.Volume
+	hr := c.newRequest().Debug().
+		Method(http.MethodPatch).
+		RawSubPath(volumes + "/" + url.PathEscape(name)).
+		JsonBody(req).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&volume); err != nil {
+		return nil, err
+	}
+
+	return volume, nil
+}
+
+// DeleteVolume destroys a volume.
+func (c *Client) DeleteVolume(name string, opts v1.DeleteOptions) error {
+
+	hr := c.newRequest().Debug().
+		Method(http.MethodDelete).
+		RawSubPath(volumes + "/" + url.PathEscape(name)).
+		Query(deleteQuery(opts)).
+		Do()
+
+	return client.NewResponse(hr).IntoBaseRes(nil)
+}
+
+func deleteQuery(opts v1.DeleteOptions) url.Values {
+	query := url.Values{}
+	for key, set := range map[string]bool{
+		"recursive":        opts.Recursive,
+		"recursive_clones": opts.RecursiveClones,
+		"defer":            opts.Defer,
+		"force":            opts.Force,
+	} {
+		if set {
+			query.Set(key, "true")
+		}
+	}
+	return query
+}
+
+// CloneVolume clones a snapshot of a volume into a new volume.
+func (c *Client) CloneVolume(name string, req *v1.CloneVolumeRequest) (*v1.Volume, error) {
+
+	var volume *v1.Volume
+	hr := c.newRequest().Debug().
+		Method(http.MethodPost).
+		RawSubPath(volumes + "/" + url.PathEscape(name) + "/clone").
+		JsonBody(req).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&volume); err != nil {
+		return nil, err
+	}
+
+	return volume, nil
+}
+
+// CreateFilesystem creates a filesystem.
+func (c *Client) CreateFilesystem(req *v1.CreateFilesystemRequest) (*v1.Filesystem, error) {
+
+	var fs *v1.Filesystem
+	hr := c.newRequest().Debug().
+		Method(http.MethodPost).
+		SubPath(filesystems).
+		JsonBody(req).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&fs); err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+// ListFilesystems lists the filesystems below filter, or all of them if
+// filter is empty.
+func (c *Client) ListFilesystems(filter string) ([]v1.Filesystem, error) {
+
+	var list []v1.Filesystem
+	hr := c.newRequest().Debug().
+		Method(http.MethodGet).
+		SubPath(filesystems).
+		Query(url.Values{"filter": []string{filter}}).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&list); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// GetFilesystem returns a filesystem by name.
+func (c *Client) GetFilesystem(name string) (*v1.Filesystem, error) {
+
+	var fs *v1.Filesystem
+	hr := c.newRequest().Debug().
+		Method(http.MethodGet).
+		RawSubPath(filesystems + "/" + url.PathEscape(name)).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&fs); err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+// UpdateFilesystem changes the properties of a filesystem.
+func (c *Client) UpdateFilesystem(name string, req *v1.UpdateFilesystemRequest) (*v1.Filesystem, error) {
+
+	var fs *v1.Filesystem
+	hr := c.newRequest().Debug().
+		Method(http.MethodPatch).
+		RawSubPath(filesystems + "/" + url.PathEscape(name)).
+		JsonBody(req).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&fs); err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+// DeleteFilesystem destroys a filesystem.
+func (c *Client) DeleteFilesystem(name string, opts v1.DeleteOptions) error {
+
+	hr := c.newRequest().Debug().
+		Method(http.MethodDelete).
+		RawSubPath(filesystems + "/" + url.PathEscape(name)).
+		Query(deleteQuery(opts)).
+		Do()
+
+	return client.NewResponse(hr).IntoBaseRes(nil)
+}
+
+// MountFilesystem mounts a filesystem.
+func (c *Client) MountFilesystem(name string, req *v1.MountFilesystemRequest) (*v1.Filesystem, error) {
+
+	var fs *v1.Filesystem
+	hr := c.newRequest().Debug().
+		Method(http.MethodPost).
+		RawSubPath(filesystems + "/" + url.PathEscape(name) + "/mount").
+		JsonBody(req).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&fs); err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+// UnmountFilesystem unmounts a filesystem.
+func (c *Client) UnmountFilesystem(name string, req *v1.UnmountFilesystemRequest) (*v1.Filesystem, error) {
+
+	var fs *v1.Filesystem
+	hr := c.newRequest().Debug().
+		Method(http.MethodPost).
+		RawSubPath(filesystems + "/" + url.PathEscape(name) + "/unmount").
+		JsonBody(req).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&fs); err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+// CreateSnapshot snapshots a volume or filesystem.  Creating an existing
+// snapshot returns it.
+func (c *Client) CreateSnapshot(req *v1.CreateSnapshotRequest) (*v1.Snapshot, error) {
+
+	var snap *v1.Snapshot
+	hr := c.newRequest().Debug().
+		Method(http.MethodPost).
+		SubPath(snapshots).
+		JsonBody(req).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&snap); err != nil {
+		return nil, err
+	}
+
+	return snap, nil
+}
+
+// ListSnapshots lists the snapshots of a source volume, or all snapshots if
+// sourceVolumeId is empty, oldest first.
+func (c *Client) ListSnapshots(sourceVolumeId string) ([]v1.Snapshot, error) {
+
+	var list []v1.Snapshot
+	hr := c.newRequest().Debug().
+		Method(http.MethodGet).
+		SubPath(snapshots).
+		Query(url.Values{"source_volume_id": []string{sourceVolumeId}}).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&list); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// GetSnapshot returns a snapshot by id, or nil if it does not exist.
+func (c *Client) GetSnapshot(snapshotId string) (*v1.Snapshot, error) {
+
+	var list []v1.Snapshot
+	hr := c.newRequest().Debug().
+		Method(http.MethodGet).
+		SubPath(snapshots).
+		Query(url.Values{"snapshot_id": []string{snapshotId}}).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&list); err != nil {
+		return nil, err
+	}
+
+	if len(list) == 0 {
+		return nil, nil
+	}
+	return &list[0], nil
+}
+
+// DeleteSnapshot destroys a snapshot.  Deleting a missing snapshot succeeds.
+func (c *Client) DeleteSnapshot(snapshotId string, opts v1.DeleteOptions) error {
+
+	hr := c.newRequest().Debug().
+		Method(http.MethodDelete).
+		RawSubPath(snapshots + "/" + url.PathEscape(snapshotId)).
+		Query(deleteQuery(opts)).
+		Do()
+
+	return client.NewResponse(hr).IntoBaseRes(nil)
+}
+
+// RollbackSnapshot rolls the source volume back to a snapshot.
+func (c *Client) RollbackSnapshot(snapshotId string, req *v1.RollbackSnapshotRequest) (*v1.Snapshot, error) {
+
+	var snap *v1.Snapshot
+	hr := c.newRequest().Debug().
+		Method(http.MethodPost).
+		RawSubPath(snapshots + "/" + url.PathEscape(snapshotId) + "/rollback").
+		JsonBody(req).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&snap); err != nil {
+		return nil, err
+	}
+
+	return snap, nil
+}
+
+// CloneSnapshot clones a snapshot of a volume into a new volume.  Snapshots
+// of filesystems can be cloned too; the volume fields of the clone are zero.
+func (c *Client) CloneSnapshot(snapshotId string, req *v1.CloneSnapshotRequest) (*v1.Volume, error) {
+
+	var volume *v1.Volume
+	hr := c.newRequest().Debug().
+		Method(http.MethodPost).
+		RawSubPath(snapshots + "/" + url.PathEscape(snapshotId) + "/clone").
+		JsonBody(req).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&volume); err != nil {
+		return nil, err
+	}
+
+	return volume, nil
+}
+
+// CreateExport shares a filesystem over NFS or SMB, creating the filesystem
+// if it does not exist.
+func (c *Client) CreateExport(req *v1.CreateExportRequest) (*v1.Export, error) {
+
+	var export *v1.Export
+	hr := c.newRequest().Debug().
+		Method(http.MethodPost).
+		SubPath(exports).
+		JsonBody(req).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&export); err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// ListExports lists the shared filesystems below filter, or all of them if
+// filter is empty.
+func (c *Client) ListExports(filter string) ([]v1.Export, error) {
+
+	var list []v1.Export
+	hr := c.newRequest().Debug().
+		Method(http.MethodGet).
+		SubPath(exports).
+		Query(url.Values{"filter": []string{filter}}).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&list); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// DeleteExport stops sharing a filesystem.  The filesystem is kept.
+func (c *Client) DeleteExport(name string) error {
+
+	hr := c.newRequest().Debug().
+		Method(http.MethodDelete).
+		RawSubPath(exports + "/" + url.PathEscape(name)).
+		Do()
+
+	return client.NewResponse(hr).IntoBaseRes(nil)
+}
+
+// SpaceReport returns the space accounting tree of the datasets below root.
+func (c *Client) SpaceReport(root string) (*zfs.SpaceNode, error) {
+
+	var report *zfs.SpaceNode
+	hr := c.newRequest().Debug().
+		Method(http.MethodGet).
+		SubPath(space).
+		Query(url.Values{"root": []string{root}}).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&report); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// ListPools lists all zpools.  Callers whose role is limited to some
+// datasets may not list the zpools, and get theirs with GetPool instead.
+func (c *Client) ListPools() ([]v1.Pool, error) {
+
+	var list []v1.Pool
+	hr := c.newRequest().Debug().
+		Method(http.MethodGet).
+		SubPath(pools).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&list); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// GetPool returns the capacity and health of a zpool.
+func (c *Client) GetPool(name string) (*v1.Pool, error) {
+
+	var pool *v1.Pool
+	hr := c.newRequest().Debug().
+		Method(http.MethodGet).
+		RawSubPath(pools + "/" + url.PathEscape(name)).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&pool); err != nil {
+		return nil, err
+	}
+
+	return pool, nil
+}
+
+// GetPoolStatus returns the vdev tree and scan state of a zpool.
+func (c *Client) GetPoolStatus(name string) (*zfs.ZpoolStatus, error) {
+
+	var status *zfs.ZpoolStatus
+	hr := c.newRequest().Debug().
+		Method(http.MethodGet).
+		RawSubPath(pools + "/" + url.PathEscape(name) + "/status").
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&status); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// SendSnapshot writes a stream of a snapshot to w, as received with
+// ReceiveSnapshot.  The stream is not buffered.
+func (c *Client) SendSnapshot(snapshotId string, opts v1.SendOptions, w io.Writer) error {
+
+	query := url.Values{}
+	if opts.From != "" {
+		query.Set("from", opts.From)
+	}
+	if opts.Intermediary {
+		query.Set("intermediary", "true")
+	}
+	if opts.Raw {
+		query.Set("raw", "true")
+	}
+	if opts.ResumeToken != "" {
+		query.Set("resume_token", opts.ResumeToken)
+	}
+
+	resp, err := c.newRequest().Debug().
+		Method(http.MethodGet).
+		RawSubPath(snapshots + "/" + url.PathEscape(snapshotId) + "/stream").
+		Query(query).
+		DoRaw()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// errors are returned as a result instead of a stream
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		var res v1.BaseResult
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			return fmt.Errorf("Decode response error: %v", err)
+		}
+		if res.ApiError != nil {
+			return fmt.Errorf("converter response error: errorType：%s msg: %s", res.ApiError.Typ, res.ApiError.Msg)
+		}
+		return fmt.Errorf("unexpected response to snapshot stream")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Service response error: (%d)", resp.StatusCode)
+	}
+
+	// a stream that failed on the server is cut off, which fails the copy
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// ReceiveSnapshot streams r into the dataset with the specified name.  The
+// stream is not buffered.  The volume fields are zero for filesystems.
+func (c *Client) ReceiveSnapshot(name string, opts v1.ReceiveOptions, r io.Reader) (*v1.Volume, error) {
+
+	query := url.Values{}
+	for key, set := range map[string]bool{
+		"resumable": opts.Resumable,
+		"force":     opts.Force,
+		"unmounted": opts.Unmounted,
+	} {
+		if set {
+			query.Set(key, "true")
+		}
+	}
+
+	var volume *v1.Volume
+	hr := c.newRequest().Debug().
+		Method(http.MethodPut).
+		RawSubPath(datasets+"/"+url.PathEscape(name)+"/receive").
+		Query(query).
+		SetHeader("Content-Type", "application/octet-stream").
+		Body(r).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&volume); err != nil {
+		return nil, err
+	}
+
+	return volume, nil
+}
+
+// GetResumeToken returns the token to resume an interrupted resumable
+// receive into a dataset, or empty string ("") if there is none.
+func (c *Client) GetResumeToken(name string) (string, error) {
+
+	var token *v1.ResumeToken
+	hr := c.newRequest().Debug().
+		Method(http.MethodGet).
+		RawSubPath(datasets + "/" + url.PathEscape(name) + "/resume_token").
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&token); err != nil {
+		return "", err
+	}
+
+	if token == nil {
+		return "", nil
+	}
+	return token.Token, nil
+}
+
+// ScrubPool starts a scrub of a zpool as a job, which finishes with the
+// scrub.  Canceling the job cancels the scrub.
+func (c *Client) ScrubPool(name string) (*v1.Job, error) {
+
+	var job *v1.Job
+	hr := c.newRequest().Debug().
+		Method(http.MethodPost).
+		RawSubPath(pools+"/"+url.PathEscape(name)+"/scrub").
+		Query(url.Values{"wait": []string{"true"}}).
+		SetHeader("Prefer", "respond-async").
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// ListJobs lists the running and finished jobs, oldest first.
+func (c *Client) ListJobs() ([]v1.Job, error) {
+
+	var list []v1.Job
+	hr := c.newRequest().Debug().
+		Method(http.MethodGet).
+		SubPath(jobs).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&list); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// GetJob returns a job by id.
+func (c *Client) GetJob(id string) (*v1.Job, error) {
+
+	var job *v1.Job
+	hr := c.newRequest().Debug().
+		Method(http.MethodGet).
+		SubPath(jobs + "/" + id).
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// CancelJob cancels a running job.
+func (c *Client) CancelJob(id string) (*v1.Job, error) {
+
+	var job *v1.Job
+	hr := c.newRequest().Debug().
+		Method(http.MethodPost).
+		SubPath(jobs + "/" + id + "/cancel").
+		Do()
+
+	if err := client.NewResponse(hr).
+		IntoBaseRes(&job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// WaitJob polls a job every interval until it is no longer running.
+func (c *Client) WaitJob(id string, interval time.Duration) (*v1.Job, error) {
+	for {
+		job, err := c.GetJob(id)
+		if err != nil {
+			return nil, err
+		}
+		if job.State != v1.JobRunning {
+			return job, nil
+		}
+		time.Sleep(interval)
+	}
+}
@@ -1,6 +1,7 @@
 package v1
 
 import (
+	"bytes"
 	"testing"
 
 	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
@@ -32,3 +33,129 @@ func TestClient_CreateVolume(t *testing.T) {
 	t.Logf("crResp %#v \n", crResp)
 
 }
+
+func TestClient_ExpandVolume(t *testing.T) {
+	evResp, err := NewClientOrDie(client.Config{
+		Url:    urls,
+		APIVer: apiVersion,
+	}).ExpandVolume(&v1.ControllerExpandVolumeRequest{
+		VolumeId: "test/appv1",
+		CapacityRange: &v1.CapacityRange{
+			RequiredBytes: 2048,
+		},
+	})
+	if err != nil {
+		t.Errorf("err: %v \n", err)
+		return
+	}
+
+	t.Log("success")
+	t.Logf("evResp %#v \n", evResp)
+
+}
+
+func TestClient_ListExports(t *testing.T) {
+	exports, err := NewClientOrDie(client.Config{
+		Url:    urls,
+		APIVer: apiVersion,
+	}).ListExports("test")
+	if err != nil {
+		t.Errorf("err: %v \n", err)
+		return
+	}
+
+	t.Log("success")
+	t.Logf("exports %#v \n", exports)
+
+}
+
+func TestClient_ListVolumes(t *testing.T) {
+	volumes, err := NewClientOrDie(client.Config{
+		Url:    urls,
+		APIVer: apiVersion,
+	}).ListVolumes(&v1.ListVolumesOptions{Filter: "test"})
+	if err != nil {
+		t.Errorf("err: %v \n", err)
+		return
+	}
+
+	t.Log("success")
+	t.Logf("volumes %#v \n", volumes)
+
+}
+
+func TestClient_ListFilesystems(t *testing.T) {
+	filesystems, err := NewClientOrDie(client.Config{
+		Url:    urls,
+		APIVer: apiVersion,
+	}).ListFilesystems("test")
+	if err != nil {
+		t.Errorf("err: %v \n", err)
+		return
+	}
+
+	t.Log("success")
+	t.Logf("filesystems %#v \n", filesystems)
+
+}
+
+func TestClient_ListSnapshots(t *testing.T) {
+	snapshots, err := NewClientOrDie(client.Config{
+		Url:    urls,
+		APIVer: apiVersion,
+	}).ListSnapshots("test/appv1")
+	if err != nil {
+		t.Errorf("err: %v \n", err)
+		return
+	}
+
+	t.Log("success")
+	t.Logf("snapshots %#v \n", snapshots)
+
+}
+
+func TestClient_ListPools(t *testing.T) {
+	pools, err := NewClientOrDie(client.Config{
+		Url:    urls,
+		APIVer: apiVersion,
+	}).ListPools()
+	if err != nil {
+		t.Errorf("err: %v \n", err)
+		return
+	}
+
+	t.Log("success")
+	t.Logf("pools %#v \n", pools)
+
+}
+
+func TestClient_SendSnapshot(t *testing.T) {
+	var stream bytes.Buffer
+	err := NewClientOrDie(client.Config{
+		Url:    urls,
+		APIVer: apiVersion,
+	}).SendSnapshot("test/appv1@snap", v1.SendOptions{}, &stream)
+	if err != nil {
+		t.Errorf("err: %v \n", err)
+		return
+	}
+
+	t.Log("success")
+	t.Logf("stream %d bytes \n", stream.Len())
+
+}
+
+func TestClient_ListJobs(t *testing.T) {
+	jobs, err := NewClientOrDie(client.Config{
+		Url:    urls,
+		APIVer: apiVersion,
+	}).ListJobs()
+	if err != nil {
+		t.Errorf("err: %v \n", err)
+		return
+	}
+
+	t.Log("success")
+	t.Logf("jobs %#v \n", jobs)
+
+}
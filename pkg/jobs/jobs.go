@@ -0,0 +1,260 @@
+// Package jobs runs requests asynchronously and keeps a history of them.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
+	"github.com/golang/glog"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrNotFound is returned for unknown job ids.
+	ErrNotFound = errors.New("job not found")
+	// ErrFinished is returned when canceling a job that already finished.
+	ErrFinished = errors.New("job already finished")
+	// ErrNotAllowed is returned by CancelIf for jobs it may not cancel.
+	ErrNotAllowed = errors.New("job may not be canceled")
+)
+
+// Func is the work of a job.  It should stop when ctx is done, and may report
+// its progress with SetProgress.
+type Func func(ctx context.Context) v1.BaseResult
+
+type job struct {
+	v1.Job
+	cancel context.CancelFunc
+}
+
+// Manager runs jobs and keeps the latest finished ones, as well as the
+// running ones, in a history file.
+type Manager struct {
+	mu    sync.Mutex
+	jobs  map[string]*job
+	order []*job // oldest first
+	path  string
+	limit int
+}
+
+// NewManager returns a manager that keeps at most limit finished jobs in the
+// history file at path.  An empty path keeps the history in memory only.
+// Jobs that were running when the history was last written are marked as
+// failed.
+func NewManager(path string, limit int) (*Manager, error) {
+	m := &Manager{jobs: make(map[string]*job), path: path, limit: limit}
+	if path == "" {
+		return m, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var history []v1.Job
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, err
+	}
+	for _, h := range history {
+		j := &job{Job: h}
+		if j.State == v1.JobRunning {
+			j.State = v1.JobFailed
+			j.Error = &v1.ApiError{Typ: v1.ErrorCanceled, Msg: "interrupted by a restart"}
+			j.Finished = time.Now()
+		}
+		m.jobs[j.Id] = j
+		m.order = append(m.order, j)
+	}
+	m.trim()
+	return m, nil
+}
+
+type progressKey struct{}
+
+type idKey struct{}
+
+// ID returns the id of the job running with ctx, or an empty string if ctx is
+// not the context of a job.
+func ID(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
+
+// SetProgress reports the progress of the job running with ctx, as a
+// percentage.  It does nothing if ctx is not the context of a job.
+func SetProgress(ctx context.Context, progress float64) {
+	p, ok := ctx.Value(progressKey{}).(func(float64))
+	if ok {
+		p(progress)
+	}
+}
+
+// Submit starts fn as a new job of owner and returns it.
+func (m *Manager) Submit(operation, owner string, fn Func) v1.Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		Job: v1.Job{
+			Id:        uuid.New().String(),
+			Operation: operation,
+			Owner:     owner,
+			State:     v1.JobRunning,
+			Created:   time.Now(),
+		},
+		cancel: cancel,
+	}
+	ctx = context.WithValue(ctx, progressKey{}, func(progress float64) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		j.Progress = progress
+	})
+	ctx = context.WithValue(ctx, idKey{}, j.Id)
+
+	m.mu.Lock()
+	m.jobs[j.Id] = j
+	m.order = append(m.order, j)
+	m.save()
+	started := j.Job
+	m.mu.Unlock()
+
+	go m.run(ctx, j, fn)
+	return started
+}
+
+func (m *Manager) run(ctx context.Context, j *job, fn Func) {
+	result := fn(ctx)
+
+	var data json.RawMessage
+	if result.Status == v1.StatusSuccess && result.Data != nil {
+		var err error
+		if data, err = json.Marshal(result.Data); err != nil {
+			result = v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorInternal, Msg: err.Error()}}
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j.Finished = time.Now()
+	switch {
+	case result.Status == v1.StatusSuccess:
+		j.State = v1.JobSucceeded
+		j.Progress = 100
+		j.Result = data
+	case ctx.Err() != nil:
+		j.State = v1.JobCanceled
+		j.Error = result.ApiError
+	default:
+		j.State = v1.JobFailed
+		j.Error = result.ApiError
+	}
+	j.cancel()
+	m.trim()
+	m.save()
+}
+
+// Get returns a job by id.
+func (m *Manager) Get(id string) (v1.Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return v1.Job{}, false
+	}
+	return j.Job, true
+}
+
+// List returns the running jobs and the history, oldest first.
+func (m *Manager) List() []v1.Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]v1.Job, len(m.order))
+	for i, j := range m.order {
+		list[i] = j.Job
+	}
+	return list
+}
+
+// Cancel cancels the context of a running job.  The job is canceled once its
+// Func returns.
+func (m *Manager) Cancel(id string) (v1.Job, error) {
+	return m.CancelIf(id, func(v1.Job) bool { return true })
+}
+
+// CancelIf is like Cancel, but returns ErrNotAllowed unless allowed reports
+// that the job may be canceled, such as by the owner of the job.  allowed is
+// called with the manager locked, so the job cannot change in between.
+func (m *Manager) CancelIf(id string, allowed func(v1.Job) bool) (v1.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return v1.Job{}, ErrNotFound
+	}
+	if !allowed(j.Job) {
+		return j.Job, ErrNotAllowed
+	}
+	if j.State != v1.JobRunning {
+		return j.Job, ErrFinished
+	}
+	j.cancel()
+	return j.Job, nil
+}
+
+// trim removes the oldest finished jobs beyond the limit.
+func (m *Manager) trim() {
+	finished := 0
+	for _, j := range m.order {
+		if j.State != v1.JobRunning {
+			finished++
+		}
+	}
+
+	kept := m.order[:0]
+	for _, j := range m.order {
+		if finished > m.limit && j.State != v1.JobRunning {
+			finished--
+			delete(m.jobs, j.Id)
+			continue
+		}
+		kept = append(kept, j)
+	}
+	m.order = kept
+}
+
+// save writes the history file, replacing it atomically.
+func (m *Manager) save() {
+	if m.path == "" {
+		return
+	}
+	history := make([]v1.Job, len(m.order))
+	for i, j := range m.order {
+		history[i] = j.Job
+	}
+	data, err := json.Marshal(history)
+	if err != nil {
+		glog.Errorln("failed to encode job history", err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.path), 0700); err != nil {
+		glog.Errorln("failed to write job history", err)
+		return
+	}
+	tmp := m.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		glog.Errorln("failed to write job history", err)
+		return
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		glog.Errorln("failed to write job history", err)
+	}
+}
@@ -0,0 +1,123 @@
+package jobs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
+)
+
+// wait polls the job until it is no longer running.
+func wait(t *testing.T, m *Manager, id string) v1.Job {
+	for i := 0; i < 100; i++ {
+		j, ok := m.Get(id)
+		if !ok {
+			t.Fatalf("job %s not found", id)
+		}
+		if j.State != v1.JobRunning {
+			return j
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s still running", id)
+	return v1.Job{}
+}
+
+func TestManager(t *testing.T) {
+	m, err := NewManager("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j := m.Submit("POST /test", "alice", func(ctx context.Context) v1.BaseResult {
+		SetProgress(ctx, 50)
+		return v1.BaseResult{Status: v1.StatusSuccess, Data: "done"}
+	})
+	if j.State != v1.JobRunning || j.Owner != "alice" {
+		t.Fatalf("unexpected job %+v", j)
+	}
+	j = wait(t, m, j.Id)
+	if j.State != v1.JobSucceeded || string(j.Result) != `"done"` || j.Progress != 100 {
+		t.Fatalf("unexpected job %+v", j)
+	}
+
+	j = m.Submit("POST /test", "alice", func(ctx context.Context) v1.BaseResult {
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorExec, Msg: "failed"}}
+	})
+	j = wait(t, m, j.Id)
+	if j.State != v1.JobFailed || j.Error.Msg != "failed" {
+		t.Fatalf("unexpected job %+v", j)
+	}
+
+	if _, err := m.Cancel(j.Id); err != ErrFinished {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := m.Cancel("unknown"); err != ErrNotFound {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestManagerCancel(t *testing.T) {
+	m, _ := NewManager("", 10)
+
+	j := m.Submit("DELETE /test", "alice", func(ctx context.Context) v1.BaseResult {
+		<-ctx.Done()
+		return v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorCanceled, Msg: ctx.Err().Error()}}
+	})
+	byBob := func(job v1.Job) bool { return job.Owner == "bob" }
+	if job, err := m.CancelIf(j.Id, byBob); err != ErrNotAllowed || job.State != v1.JobRunning {
+		t.Fatalf("got %+v %v for another owner", job, err)
+	}
+	if _, err := m.Cancel(j.Id); err != nil {
+		t.Fatal(err)
+	}
+	j = wait(t, m, j.Id)
+	if j.State != v1.JobCanceled {
+		t.Fatalf("unexpected state %s", j.State)
+	}
+}
+
+func TestManagerHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jobs.json")
+
+	m, err := NewManager(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for i := 0; i < 3; i++ {
+		j := m.Submit("POST /test", "alice", func(ctx context.Context) v1.BaseResult {
+			return v1.BaseResult{Status: v1.StatusSuccess}
+		})
+		wait(t, m, j.Id)
+		ids = append(ids, j.Id)
+	}
+	block := make(chan struct{})
+	defer close(block)
+	running := m.Submit("POST /test", "alice", func(ctx context.Context) v1.BaseResult {
+		<-block
+		return v1.BaseResult{Status: v1.StatusSuccess}
+	})
+
+	// the running job is failed, which drops the oldest finished jobs
+	m, err = NewManager(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := m.List()
+	if len(list) != 2 || list[0].Id != ids[2] || list[1].Id != running.Id {
+		t.Fatalf("unexpected history %+v", list)
+	}
+	if list[1].State != v1.JobFailed {
+		t.Fatalf("unexpected state %s", list[1].State)
+	}
+}
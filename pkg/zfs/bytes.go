@@ -0,0 +1,161 @@
+package zfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Bytes is a size in bytes, such as the used space of a dataset or the size
+// of a zpool.
+//
+// Like the ZFS tools, Bytes treats every unit suffix as a power of 1024, so
+// "1.5G", "1.5Gi", "1.5GB" and "1.5GiB" are all 1.5 * 1024^3 bytes.
+type Bytes uint64
+
+// Byte sizes in powers of 1024.
+const (
+	Byte Bytes = 1 << (10 * iota)
+	KiB
+	MiB
+	GiB
+	TiB
+	PiB
+	EiB
+)
+
+var bytesUnits = []struct {
+	suffix string
+	size   Bytes
+}{
+	{"Ei", EiB},
+	{"Pi", PiB},
+	{"Ti", TiB},
+	{"Gi", GiB},
+	{"Mi", MiB},
+	{"Ki", KiB},
+}
+
+// ParseBytes parses a size such as "512K", "1.5G", "20Gi" or "10T".  The
+// values "none", "-" and "" are parsed as 0, as printed by zfs for unset
+// properties.
+func ParseBytes(s string) (Bytes, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "", "-", "none":
+		return 0, nil
+	}
+
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+	number, suffix := s[:i], strings.ToUpper(strings.TrimSpace(s[i:]))
+	if number == "" {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	unit := Byte
+	if suffix != "" && suffix != "B" {
+		suffix = strings.TrimSuffix(strings.TrimSuffix(suffix, "B"), "I")
+		switch suffix {
+		case "K":
+			unit = KiB
+		case "M":
+			unit = MiB
+		case "G":
+			unit = GiB
+		case "T":
+			unit = TiB
+		case "P":
+			unit = PiB
+		case "E":
+			unit = EiB
+		default:
+			return 0, fmt.Errorf("invalid size %q: unknown unit", s)
+		}
+	}
+
+	if !strings.Contains(number, ".") {
+		n, err := strconv.ParseUint(number, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid size %q: %v", s, err)
+		}
+		if n > math.MaxUint64/uint64(unit) {
+			return 0, fmt.Errorf("invalid size %q: out of range", s)
+		}
+		return Bytes(n) * unit, nil
+	}
+
+	// fractions are computed exactly and rounded up to a whole byte
+	r, ok := new(big.Rat).SetString(number)
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	r.Mul(r, new(big.Rat).SetUint64(uint64(unit)))
+	n := new(big.Int).Quo(r.Num(), r.Denom())
+	if new(big.Rat).SetInt(n).Cmp(r) != 0 {
+		n.Add(n, big.NewInt(1))
+	}
+	if !n.IsUint64() {
+		return 0, fmt.Errorf("invalid size %q: out of range", s)
+	}
+	return Bytes(n.Uint64()), nil
+}
+
+// String returns the size in IEC units, rounded to two decimals, e.g. "1.5Gi".
+func (b Bytes) String() string {
+	for _, u := range bytesUnits {
+		if b >= u.size {
+			v := strconv.FormatFloat(float64(b)/float64(u.size), 'f', 2, 64)
+			v = strings.TrimRight(strings.TrimRight(v, "0"), ".")
+			return v + u.suffix
+		}
+	}
+	return strconv.FormatUint(uint64(b), 10)
+}
+
+// Exact returns the size as a decimal number of bytes.
+func (b Bytes) Exact() string {
+	return strconv.FormatUint(uint64(b), 10)
+}
+
+// MarshalJSON encodes the size in IEC units if no precision is lost, or as an
+// exact number of bytes otherwise.  Either form is accepted by UnmarshalJSON.
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	s := b.Exact()
+	for _, u := range bytesUnits {
+		// two decimals are exact for multiples of a quarter unit
+		if b >= u.size {
+			if b%(u.size/4) == 0 {
+				s = b.String()
+			}
+			break
+		}
+	}
+	return json.Marshal(s)
+}
+
+// UnmarshalJSON decodes a size from a JSON number of bytes or a string
+// accepted by ParseBytes.
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n json.Number
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("invalid size %s", data)
+		}
+		s = n.String()
+	}
+	v, err := ParseBytes(s)
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
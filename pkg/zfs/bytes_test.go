@@ -0,0 +1,61 @@
+package zfs
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseBytes(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out Bytes
+	}{
+		{"-", 0},
+		{"none", 0},
+		{"", 0},
+		{"1024", 1024},
+		{"512K", 512 * KiB},
+		{"1.5G", 3 * GiB / 2},
+		{"20Gi", 20 * GiB},
+		{"20GiB", 20 * GiB},
+		{"20gb", 20 * GiB},
+		{"10T", 10 * TiB},
+		{"0.1K", 103},
+		{"16E", 0},
+	}
+
+	for _, test := range tests[:len(tests)-1] {
+		b, err := ParseBytes(test.in)
+		ok(t, err)
+		equals(t, test.out, b)
+	}
+
+	for _, in := range []string{"16E", "1X", "G", "1.2.3G"} {
+		_, err := ParseBytes(in)
+		nok(t, err)
+	}
+}
+
+func TestBytesFormat(t *testing.T) {
+	equals(t, "512", Bytes(512).String())
+	equals(t, "1.5Gi", (3 * GiB / 2).String())
+	equals(t, "20Gi", (20 * GiB).String())
+	equals(t, "1.33Ki", Bytes(1362).String())
+	equals(t, "1362", Bytes(1362).Exact())
+}
+
+func TestBytesJSON(t *testing.T) {
+	var v struct {
+		A Bytes `json:"a"`
+		B Bytes `json:"b"`
+		C Bytes `json:"c"`
+	}
+	ok(t, json.Unmarshal([]byte(`{"a": "20Gi", "b": 1362, "c": "1.75M"}`), &v))
+	equals(t, 20*GiB, v.A)
+	equals(t, Bytes(1362), v.B)
+	equals(t, 7*MiB/4, v.C)
+
+	out, err := json.Marshal(v)
+	ok(t, err)
+	equals(t, `{"a":"20Gi","b":"1362","c":"1.75Mi"}`, string(out))
+}
@@ -0,0 +1,165 @@
+package zfs
+
+import (
+	"sync"
+	"time"
+)
+
+// CacheStats are the statistics of the property cache, as returned by
+// GetCacheStats.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	// Entries is the number of datasets and zpools currently cached.
+	Entries int
+}
+
+type cachedDataset struct {
+	dataset Dataset
+	expires time.Time
+}
+
+type cachedZpool struct {
+	zpool   Zpool
+	expires time.Time
+}
+
+// propertyCache caches the properties of datasets and zpools returned by
+// GetDataset and GetZpool.  Entries expire after the TTL, and are invalidated
+// when a mutating call of this package changes the dataset, one of its
+// descendents or one of its ancestors.
+//
+// Mutating calls invalidate the cache when they release their locks, so
+// entries are not returned while an exclusive lock is held on them, as the
+// mutating call would read the properties from before its change.
+type propertyCache struct {
+	locks *lockManager
+
+	mu       sync.Mutex
+	ttl      time.Duration
+	datasets map[string]cachedDataset
+	zpools   map[string]cachedZpool
+	stats    CacheStats
+	// gen is incremented on every invalidation, so that properties read
+	// concurrently with a mutating call are not cached.
+	gen uint64
+}
+
+var cache = &propertyCache{locks: locks}
+
+// EnableCache enables caching of the properties returned by GetDataset,
+// GetZpool and ListZpools for ttl.  Cached properties are invalidated by the
+// mutating calls of this package, but not by changes made by other processes,
+// which may remain unnoticed for up to ttl.  A ttl of zero disables the cache.
+func EnableCache(ttl time.Duration) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.ttl = ttl
+	cache.datasets = make(map[string]cachedDataset)
+	cache.zpools = make(map[string]cachedZpool)
+}
+
+// InvalidateCache removes the cached properties of the dataset or zpool with
+// the specified name, its descendents and its ancestors.  An empty name
+// clears the whole cache.
+func InvalidateCache(name string) {
+	cache.invalidate(name)
+}
+
+// GetCacheStats returns the statistics of the property cache.
+func GetCacheStats() CacheStats {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	stats := cache.stats
+	stats.Entries = len(cache.datasets) + len(cache.zpools)
+	return stats
+}
+
+// generation returns the current generation, to be passed to putDataset and
+// putZpool along with the properties read afterwards.
+func (c *propertyCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// locked reports whether an exclusive lock is held on the entry with the
+// specified name.
+func (c *propertyCache) locked(name string) bool {
+	return c.locks != nil && c.locks.exclusivelyLocked(name)
+}
+
+func (c *propertyCache) getDataset(name string) (*Dataset, bool) {
+	locked := c.locked(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 {
+		return nil, false
+	}
+	e, ok := c.datasets[name]
+	if !ok || locked || time.Now().After(e.expires) {
+		delete(c.datasets, name)
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	ds := e.dataset
+	return &ds, true
+}
+
+func (c *propertyCache) putDataset(gen uint64, ds *Dataset) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 || gen != c.gen {
+		return
+	}
+	c.datasets[ds.Name] = cachedDataset{dataset: *ds, expires: time.Now().Add(c.ttl)}
+}
+
+func (c *propertyCache) getZpool(name string) (*Zpool, bool) {
+	locked := c.locked(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 {
+		return nil, false
+	}
+	e, ok := c.zpools[name]
+	if !ok || locked || time.Now().After(e.expires) {
+		delete(c.zpools, name)
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	z := e.zpool
+	return &z, true
+}
+
+func (c *propertyCache) putZpool(gen uint64, z *Zpool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 || gen != c.gen {
+		return
+	}
+	c.zpools[z.Name] = cachedZpool{zpool: *z, expires: time.Now().Add(c.ttl)}
+}
+
+func (c *propertyCache) invalidate(names ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if c.ttl <= 0 {
+		return
+	}
+	for _, name := range names {
+		for cached := range c.datasets {
+			if name == "" || overlaps(name, cached) {
+				delete(c.datasets, cached)
+			}
+		}
+		for cached := range c.zpools {
+			if name == "" || overlaps(name, cached) {
+				delete(c.zpools, cached)
+			}
+		}
+	}
+}
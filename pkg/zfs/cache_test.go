@@ -0,0 +1,115 @@
+package zfs
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPropertyCache(t *testing.T) {
+	c := &propertyCache{}
+	c.putDataset(c.generation(), &Dataset{Name: "tank/a"})
+	_, ok := c.getDataset("tank/a")
+	equals(t, false, ok)
+
+	c.ttl = time.Minute
+	c.datasets = make(map[string]cachedDataset)
+	c.zpools = make(map[string]cachedZpool)
+
+	c.putDataset(c.generation(), &Dataset{Name: "tank/a", Used: 1})
+	c.putDataset(c.generation(), &Dataset{Name: "tank/b"})
+	c.putZpool(c.generation(), &Zpool{Name: "tank"})
+
+	ds, ok := c.getDataset("tank/a")
+	equals(t, true, ok)
+	equals(t, Bytes(1), ds.Used)
+
+	// the cached properties are not modified through the returned copy
+	ds.Used = 2
+	ds, _ = c.getDataset("tank/a")
+	equals(t, Bytes(1), ds.Used)
+
+	// a snapshot invalidates its dataset and ancestors, but not siblings
+	c.invalidate("tank/a@snap")
+	_, ok = c.getDataset("tank/a")
+	equals(t, false, ok)
+	_, ok = c.getZpool("tank")
+	equals(t, false, ok)
+	_, ok = c.getDataset("tank/b")
+	equals(t, true, ok)
+
+	// properties read before an invalidation are not cached
+	gen := c.generation()
+	c.invalidate("tank/c")
+	c.putDataset(gen, &Dataset{Name: "tank/c"})
+	_, ok = c.getDataset("tank/c")
+	equals(t, false, ok)
+
+	c.invalidate("")
+	_, ok = c.getDataset("tank/b")
+	equals(t, false, ok)
+	equals(t, uint64(3), c.stats.Hits)
+	equals(t, uint64(4), c.stats.Misses)
+}
+
+func TestPropertyCacheExpiry(t *testing.T) {
+	c := &propertyCache{
+		ttl:      time.Millisecond,
+		datasets: make(map[string]cachedDataset),
+		zpools:   make(map[string]cachedZpool),
+	}
+	c.putZpool(c.generation(), &Zpool{Name: "tank"})
+	time.Sleep(5 * time.Millisecond)
+	_, ok := c.getZpool("tank")
+	equals(t, false, ok)
+	equals(t, 0, len(c.zpools))
+}
+
+func TestPropertyCacheLocked(t *testing.T) {
+	c := &propertyCache{
+		locks:    newLockManager(),
+		ttl:      time.Minute,
+		datasets: make(map[string]cachedDataset),
+		zpools:   make(map[string]cachedZpool),
+	}
+	c.putDataset(c.generation(), &Dataset{Name: "tank/vol", Volsize: GiB})
+	c.putDataset(c.generation(), &Dataset{Name: "tank/other"})
+	c.putZpool(c.generation(), &Zpool{Name: "tank"})
+
+	// a resize reads the volume again under its exclusive lock, which must not
+	// return the size from before the resize
+	unlock, _ := c.locks.lock(context.Background(), exclusive("tank/vol"))
+	_, ok := c.getDataset("tank/vol")
+	equals(t, false, ok)
+	_, ok = c.getZpool("tank")
+	equals(t, false, ok)
+	_, ok = c.getDataset("tank/other")
+	equals(t, true, ok)
+
+	c.putDataset(c.generation(), &Dataset{Name: "tank/vol", Volsize: 2 * GiB})
+	unlock()
+
+	// shared locks do not change the dataset
+	unlock, _ = c.locks.lock(context.Background(), shared("tank/vol"))
+	defer unlock()
+	ds, ok := c.getDataset("tank/vol")
+	equals(t, true, ok)
+	equals(t, 2*GiB, ds.Volsize)
+}
+
+func TestParseZpools(t *testing.T) {
+	out := [][]string{
+		{"tank", "name", "tank"},
+		{"tank", "health", "ONLINE"},
+		{"tank", "size", "1073741824"},
+		{"backup", "name", "backup"},
+		{"backup", "health", "DEGRADED"},
+	}
+	pools, err := parseZpools(out)
+	ok(t, err)
+	equals(t, 2, len(pools))
+	equals(t, "tank", pools[0].Name)
+	equals(t, GiB, pools[0].Size)
+	equals(t, "backup", pools[1].Name)
+	equals(t, ZpoolDegraded, pools[1].Health)
+}
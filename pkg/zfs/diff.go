@@ -0,0 +1,113 @@
+package zfs
+
+import (
+	"context"
+	"io"
+	"path"
+	"sort"
+)
+
+// DiffOptions are the options passed to DiffFunc.
+type DiffOptions struct {
+	// Timestamps requests the change time of every inode (zfs diff -t).
+	Timestamps bool
+}
+
+// DiffSummary is an aggregated view of the changes between a snapshot and a
+// ZFS dataset, as returned by DiffSummary.
+type DiffSummary struct {
+	Total          int
+	Changes        map[ChangeType]int
+	Inodes         map[InodeType]int
+	TopDirectories []DirectoryChanges
+}
+
+// DirectoryChanges is the number of changes found directly inside a
+// directory.
+type DirectoryChanges struct {
+	Path    string
+	Changes int
+}
+
+// DiffFunc streams the changes between a snapshot and the given ZFS dataset
+// to fn, one change at a time, without holding the whole diff in memory.
+// The snapshot name must include the filesystem part as it is possible to
+// compare clones with their origin snapshots.
+// If fn returns an error, zfs diff is stopped and the error is returned.
+func (d *Dataset) DiffFunc(snapshot string, opts DiffOptions, fn func(*InodeChange) error) error {
+	return d.DiffFuncContext(context.Background(), snapshot, opts, fn)
+}
+
+// DiffFuncContext is like DiffFunc, but kills zfs diff when ctx is done.
+func (d *Dataset) DiffFuncContext(ctx context.Context, snapshot string, opts DiffOptions, fn func(*InodeChange) error) error {
+	flags := "-FH"
+	if opts.Timestamps {
+		flags += "t"
+	}
+
+	pr, pw := io.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		c := command{Command: "zfs", Stdout: pw, Context: ctx}
+		_, err := c.Run("diff", flags, snapshot, d.Name)
+		pw.CloseWithError(err)
+		errc <- err
+	}()
+
+	err := scanInodeChanges(pr, opts.Timestamps, fn)
+	if err != nil {
+		// closing the reader makes zfs diff fail on its next write
+		pr.CloseWithError(err)
+	}
+	runErr := <-errc
+
+	if err != nil {
+		return err
+	}
+	return runErr
+}
+
+// DiffSummary returns the number of changes between a snapshot and the given
+// ZFS dataset per ChangeType and InodeType, together with the topDirs
+// directories holding the most changes.  The diff is streamed, so only the
+// per-directory counters are kept in memory.
+func (d *Dataset) DiffSummary(snapshot string, topDirs int) (*DiffSummary, error) {
+	summary := &DiffSummary{
+		Changes: make(map[ChangeType]int),
+		Inodes:  make(map[InodeType]int),
+	}
+	dirs := make(map[string]int)
+
+	err := d.DiffFunc(snapshot, DiffOptions{}, func(c *InodeChange) error {
+		summary.Total++
+		summary.Changes[c.Change]++
+		summary.Inodes[c.Type]++
+		dirs[path.Dir(path.Clean(c.Path))]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	summary.TopDirectories = topDirectoryChanges(dirs, topDirs)
+	return summary, nil
+}
+
+// topDirectoryChanges returns the n directories with the most changes, most
+// changed first.  A negative n returns all of them.
+func topDirectoryChanges(dirs map[string]int, n int) []DirectoryChanges {
+	top := make([]DirectoryChanges, 0, len(dirs))
+	for p, count := range dirs {
+		top = append(top, DirectoryChanges{Path: p, Changes: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Changes != top[j].Changes {
+			return top[i].Changes > top[j].Changes
+		}
+		return top[i].Path < top[j].Path
+	})
+	if n >= 0 && n < len(top) {
+		top = top[:n]
+	}
+	return top
+}
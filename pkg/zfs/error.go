@@ -1,9 +1,51 @@
 package zfs
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
 )
 
+// Errors reported by the `zfs` and `zpool` shell commands.  An Error matches
+// one of them with errors.Is if its stderr output tells the cause.
+var (
+	// ErrNotFound is reported for a dataset, snapshot or zpool that does
+	// not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is reported for a dataset or zpool that exists.
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrBusy is reported for a dataset, zpool or device that is in use.
+	ErrBusy = errors.New("busy")
+	// ErrNoSpace is reported if a zpool or a quota is out of space.
+	ErrNoSpace = errors.New("no space")
+	// ErrPermission is reported if the caller may not run the command.
+	ErrPermission = errors.New("permission denied")
+	// ErrHasDependents is reported if a dataset has children, clones or
+	// more recent snapshots which the command would have to destroy.
+	ErrHasDependents = errors.New("has dependents")
+	// ErrInvalidProperty is reported for an unknown, read-only or badly
+	// formatted property.
+	ErrInvalidProperty = errors.New("invalid property")
+	// ErrPoolUnavailable is reported for a zpool that is faulted or
+	// suspended.
+	ErrPoolUnavailable = errors.New("pool unavailable")
+)
+
+// stderrCauses are matched in order against the stderr output of a command.
+var stderrCauses = []struct {
+	err   error
+	regex *regexp.Regexp
+}{
+	{ErrPoolUnavailable, regexp.MustCompile(`(?i)pool I/O is currently suspended|pool is unavailable|pool is faulted|one or more devices (is|are) currently unavailable`)},
+	{ErrHasDependents, regexp.MustCompile(`(?i)has children|has dependent clones|more recent snapshots|use '-[rR]' to`)},
+	{ErrNotFound, regexp.MustCompile(`(?i)does not exist|no such pool|no such device|could not find any snapshots`)},
+	{ErrAlreadyExists, regexp.MustCompile(`(?i)already exists`)},
+	{ErrBusy, regexp.MustCompile(`(?i)is busy|device busy|currently in use`)},
+	{ErrNoSpace, regexp.MustCompile(`(?i)out of space|no space left|greater than available space|quota exceeded`)},
+	{ErrPermission, regexp.MustCompile(`(?i)permission denied|insufficient privileges|operation not permitted`)},
+	{ErrInvalidProperty, regexp.MustCompile(`(?i)invalid property|bad property|bad numeric value|'[^']*' is readonly|must be one of|must be a multiple of|invalid (volume )?block ?size|invalid value`)},
+}
+
 // Error is an error which is returned when the `zfs` or `zpool` shell
 // commands return with a non-zero exit code.
 type Error struct {
@@ -16,3 +58,25 @@ type Error struct {
 func (e Error) Error() string {
 	return fmt.Sprintf("%s: %q => %s", e.Err, e.Debug, e.Stderr)
 }
+
+// Unwrap returns the error of running the command.
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the stderr output of the command matches target, one of
+// the Err variables of this package.
+func (e Error) Is(target error) bool {
+	return target != nil && stderrCause(e.Stderr) == target
+}
+
+// stderrCause returns the error matching the stderr output of a command, or
+// nil if the cause is unknown.
+func stderrCause(stderr string) error {
+	for _, cause := range stderrCauses {
+		if cause.regex.MatchString(stderr) {
+			return cause.err
+		}
+	}
+	return nil
+}
@@ -35,3 +35,49 @@ func TestError(t *testing.T) {
 		}
 	}
 }
+
+func TestErrorIs(t *testing.T) {
+	var tests = []struct {
+		stderr string
+		want   error
+	}{
+		{"cannot open 'tank/vol': dataset does not exist\n", ErrNotFound},
+		{"cannot open 'nopool': no such pool\n", ErrNotFound},
+		{"could not find any snapshots to destroy; check snapshot names.\n", ErrNotFound},
+		{"cannot create 'tank/vol': dataset already exists\n", ErrAlreadyExists},
+		{"cannot destroy 'tank/vol': dataset is busy\n", ErrBusy},
+		{"cannot export 'tank': pool is busy\n", ErrBusy},
+		{"cannot create 'tank/vol': out of space\n", ErrNoSpace},
+		{"cannot set property for 'tank/vol': size is greater than available space\n", ErrNoSpace},
+		{"cannot create 'tank/fs': permission denied\n", ErrPermission},
+		{"cannot destroy 'tank/fs': filesystem has children\nuse '-r' to destroy the following datasets:\ntank/fs/child\n", ErrHasDependents},
+		{"cannot destroy 'tank/vol@snap': snapshot has dependent clones\nuse '-R' to destroy the following datasets:\ntank/clone\n", ErrHasDependents},
+		{"cannot rollback to 'tank/vol@a': more recent snapshots or bookmarks exist\nuse '-r' to force deletion of the following snapshots and bookmarks:\ntank/vol@b\n", ErrHasDependents},
+		{"cannot create 'tank/fs': invalid property 'foo'\n", ErrInvalidProperty},
+		{"cannot set property for 'tank/fs': 'used' is readonly\n", ErrInvalidProperty},
+		{"bad numeric value 'abc'\n", ErrInvalidProperty},
+		{"cannot open 'tank': pool I/O is currently suspended\n", ErrPoolUnavailable},
+		{"internal error: Input/output error\n", nil},
+	}
+
+	sentinels := []error{ErrNotFound, ErrAlreadyExists, ErrBusy, ErrNoSpace, ErrPermission, ErrHasDependents, ErrInvalidProperty, ErrPoolUnavailable}
+	for _, test := range tests {
+		var err error = &Error{Err: errors.New("exit status 1"), Debug: "zfs", Stderr: test.stderr}
+		for _, sentinel := range sentinels {
+			if got := errors.Is(err, sentinel); got != (sentinel == test.want) {
+				t.Errorf("errors.Is(%q, %v) = %v", test.stderr, sentinel, got)
+			}
+		}
+	}
+}
+
+func TestErrorUnwrap(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+	err := fmt.Errorf("destroy failed: %w", &Error{Err: exitErr, Stderr: "cannot destroy 'tank/vol': dataset is busy\n"})
+	if !errors.Is(err, exitErr) {
+		t.Errorf("wrapped Error does not unwrap to the error of the command")
+	}
+	if !errors.Is(err, ErrBusy) {
+		t.Errorf("wrapped Error does not match ErrBusy")
+	}
+}
@@ -0,0 +1,113 @@
+package zfs
+
+import (
+	"context"
+	"errors"
+)
+
+// JailProperty is the user property used to record the jail a dataset has
+// been attached to with Jail.
+const JailProperty = "freebsd-manager:jail"
+
+// JailedDataset is a dataset that is delegated to a FreeBSD jail.  Jail is
+// empty if the dataset was not attached with Jail, as the jail a dataset is
+// attached to can not be queried from the host.
+type JailedDataset struct {
+	Name string
+	Jail string
+}
+
+// Jail sets the jailed property on the receiving ZFS filesystem and attaches
+// it to the jail with the specified jail ID or name, so the filesystem and
+// its descendents can be managed from inside the jail.
+// Jails are only available on FreeBSD.
+func (d *Dataset) Jail(jail string) error {
+	return d.JailContext(context.Background(), jail)
+}
+
+// JailContext is like Jail, but kills zfs jail when ctx is done.
+func (d *Dataset) JailContext(ctx context.Context, jail string) error {
+	if d.Type != DatasetFilesystem {
+		return errors.New("can only jail filesystems")
+	}
+	if jail == "" {
+		return errors.New("jail ID or name is required")
+	}
+
+	unlock, err := locks.lock(ctx, exclusive(d.Name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := d.setProperty(ctx, "jailed", "on"); err != nil {
+		return err
+	}
+	if _, err := zfs(ctx, "jail", jail, d.Name); err != nil {
+		// the property is reset even if ctx is done
+		d.setProperty(context.Background(), "jailed", "off")
+		return err
+	}
+	return d.setProperty(ctx, JailProperty, jail)
+}
+
+// Unjail detaches the receiving ZFS filesystem from the jail with the
+// specified jail ID or name and clears its jailed property.  If jail is
+// empty, the jail recorded by Jail is used.
+// Jails are only available on FreeBSD.
+func (d *Dataset) Unjail(jail string) error {
+	return d.UnjailContext(context.Background(), jail)
+}
+
+// UnjailContext is like Unjail, but kills zfs unjail when ctx is done.
+func (d *Dataset) UnjailContext(ctx context.Context, jail string) error {
+	if d.Type != DatasetFilesystem {
+		return errors.New("can only unjail filesystems")
+	}
+	if jail == "" {
+		var err error
+		jail, err = d.GetPropertyContext(ctx, JailProperty)
+		if err != nil {
+			return err
+		}
+		if jail == "-" || jail == "" {
+			return errors.New("jail ID or name is required")
+		}
+	}
+
+	unlock, err := locks.lock(ctx, exclusive(d.Name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := zfs(ctx, "unjail", jail, d.Name); err != nil {
+		return err
+	}
+	if err := d.setProperty(ctx, "jailed", "off"); err != nil {
+		return err
+	}
+	return d.inheritProperty(ctx, JailProperty)
+}
+
+// JailedDatasets returns the filesystems that have the jailed property set,
+// along with the jail they were attached to.  Descendents of a jailed
+// filesystem inherit the property and are reported as well.
+func JailedDatasets() ([]*JailedDataset, error) {
+	out, err := zfs(context.Background(), "list", "-rH", "-t", DatasetFilesystem, "-o", "name,jailed,"+JailProperty)
+	if err != nil {
+		return nil, err
+	}
+	return parseJailedDatasets(out), nil
+}
+
+func parseJailedDatasets(out [][]string) []*JailedDataset {
+	var datasets []*JailedDataset
+	for _, line := range out {
+		if len(line) != 3 || line[1] != "on" {
+			continue
+		}
+		jd := &JailedDataset{Name: line[0]}
+		setString(&jd.Jail, line[2])
+		datasets = append(datasets, jd)
+	}
+	return datasets
+}
@@ -0,0 +1,271 @@
+package zfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	versionOnce  sync.Once
+	versionMajor int
+	versionMinor int
+	versionOK    bool
+
+	jsonOnce    sync.Once
+	jsonEnabled bool
+)
+
+// zfsVersion returns the version of the installed ZFS tools, as printed by
+// zfs version.  ok is false if the version is unknown, which is the case for
+// ZFS versions older than OpenZFS 0.8 and the legacy FreeBSD ZFS.
+func zfsVersion() (major, minor int, ok bool) {
+	versionOnce.Do(func() {
+		var out bytes.Buffer
+		c := command{Command: "zfs", Stdout: &out}
+		if _, err := c.Run("version"); err != nil {
+			return
+		}
+		versionMajor, versionMinor, versionOK = parseZfsVersion(out.String())
+	})
+	return versionMajor, versionMinor, versionOK
+}
+
+// SetJSONOutput overrides the detection of JSON output support.  When
+// enabled, zfs and zpool are invoked with -j for list and get commands.
+func SetJSONOutput(enabled bool) {
+	jsonOnce.Do(func() {})
+	jsonEnabled = enabled
+}
+
+// JSONOutputSupported reports whether the installed ZFS tools can print JSON
+// output (OpenZFS 2.3 and newer).  The result is detected once by running
+// zfs version, unless it was set with SetJSONOutput.
+func JSONOutputSupported() bool {
+	jsonOnce.Do(func() {
+		major, minor, ok := zfsVersion()
+		jsonEnabled = ok && (major > 2 || major == 2 && minor >= 3)
+	})
+	return jsonEnabled
+}
+
+// matches zfs-2.3.0-1 or zfs-2.2.4-FreeBSD_g256659204
+var zfsVersionRegex = regexp.MustCompile(`(?m)^zfs-(\d+)\.(\d+)`)
+
+func parseZfsVersion(out string) (int, int, bool) {
+	matches := zfsVersionRegex.FindStringSubmatch(out)
+	if matches == nil {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err := strconv.Atoi(matches[2])
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// jsonValue is a property value, which is a string unless --json-int was
+// passed.
+type jsonValue string
+
+func (v *jsonValue) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*v = jsonValue(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*v = jsonValue(n)
+	return nil
+}
+
+type jsonProperty struct {
+	Value jsonValue `json:"value"`
+}
+
+type jsonObject struct {
+	Name       string                  `json:"name"`
+	Properties map[string]jsonProperty `json:"properties"`
+}
+
+func (o *jsonObject) property(name string) string {
+	if name == "name" && o.Name != "" {
+		return o.Name
+	}
+	p, ok := o.Properties[name]
+	if !ok || p.Value == "" {
+		return "-"
+	}
+	return string(p.Value)
+}
+
+// jsonOutput is the top level object printed by zfs and zpool with -j.
+type jsonOutput struct {
+	Datasets json.RawMessage `json:"datasets"`
+	Pools    json.RawMessage `json:"pools"`
+}
+
+// decodeJSONObjects decodes a JSON object of named datasets or pools,
+// keeping the order in which zfs printed them.
+func decodeJSONObjects(data json.RawMessage) ([]*jsonObject, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('{') {
+		return nil, fmt.Errorf("unexpected JSON token %v", t)
+	}
+
+	var objects []*jsonObject
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		name, ok := t.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected JSON token %v", t)
+		}
+		o := &jsonObject{}
+		if err := dec.Decode(o); err != nil {
+			return nil, err
+		}
+		if o.Name == "" {
+			o.Name = name
+		}
+		objects = append(objects, o)
+	}
+	return objects, nil
+}
+
+// parseJSONDatasets converts zfs list -j or zfs get -j output into one row per
+// dataset, with a column for each of the properties, as printed by -H.
+func parseJSONDatasets(data []byte, props []string) ([][]string, error) {
+	var out jsonOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	objects, err := decodeJSONObjects(out.Datasets)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([][]string, len(objects))
+	for i, o := range objects {
+		row := make([]string, len(props))
+		for j, prop := range props {
+			row[j] = o.property(prop)
+		}
+		rows[i] = row
+	}
+	return rows, nil
+}
+
+// parseJSONPools converts zpool get -j output into one row per pool and
+// property, as printed by zpool get -H.
+func parseJSONPools(data []byte, props []string) ([][]string, error) {
+	var out jsonOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	objects, err := decodeJSONObjects(out.Pools)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	for _, o := range objects {
+		for _, prop := range props {
+			rows = append(rows, []string{o.Name, prop, o.property(prop)})
+		}
+	}
+	return rows, nil
+}
+
+// runJSON runs cmd with arg and returns its raw output.
+func runJSON(ctx context.Context, cmd string, arg ...string) ([]byte, error) {
+	var out bytes.Buffer
+	c := command{Command: cmd, Stdout: &out, Context: ctx}
+	if _, err := c.Run(arg...); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// zfsList runs zfs list with arg and returns one row per dataset, with the
+// columns of dsPropList.
+func zfsList(ctx context.Context, arg ...string) ([][]string, error) {
+	return zfsListProps(ctx, dsPropList, arg...)
+}
+
+// zfsListProps runs zfs list with arg and returns one row per dataset, with a
+// column for each of props.
+func zfsListProps(ctx context.Context, props []string, arg ...string) ([][]string, error) {
+	if !JSONOutputSupported() {
+		return zfs(ctx, append([]string{"list", "-Hp", "-o", strings.Join(props, ",")}, arg...)...)
+	}
+	out, err := runJSON(ctx, "zfs", append([]string{"list", "-j", "-p", "-o", strings.Join(props, ",")}, arg...)...)
+	if err != nil {
+		return nil, err
+	}
+	return parseJSONDatasets(out, props)
+}
+
+// zfsGet runs zfs get for the properties of a single dataset and returns the
+// values in the same order.
+func zfsGet(ctx context.Context, name string, props ...string) ([]string, error) {
+	if JSONOutputSupported() {
+		out, err := runJSON(ctx, "zfs", "get", "-j", strings.Join(props, ","), name)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := parseJSONDatasets(out, props)
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) != 1 {
+			return nil, fmt.Errorf("unexpected zfs get output for %s", name)
+		}
+		return rows[0], nil
+	}
+
+	out, err := zfs(ctx, "get", "-H", "-o", "value", strings.Join(props, ","), name)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) != len(props) {
+		return nil, fmt.Errorf("unexpected zfs get output for %s", name)
+	}
+	values := make([]string, len(out))
+	for i, line := range out {
+		values[i] = line[0]
+	}
+	return values, nil
+}
+
+// zpoolGet runs zpool get -p for props and returns rows of pool name,
+// property and value.
+func zpoolGet(ctx context.Context, props []string, names ...string) ([][]string, error) {
+	if !JSONOutputSupported() {
+		return zpool(ctx, append([]string{"get", "-Hp", strings.Join(props, ",")}, names...)...)
+	}
+	out, err := runJSON(ctx, "zpool", append([]string{"get", "-j", "-p", strings.Join(props, ",")}, names...)...)
+	if err != nil {
+		return nil, err
+	}
+	return parseJSONPools(out, props)
+}
@@ -0,0 +1,93 @@
+package zfs
+
+import (
+	"testing"
+)
+
+func TestParseZfsVersion(t *testing.T) {
+	var tests = []struct {
+		out          string
+		major, minor int
+		ok           bool
+	}{
+		{"zfs-2.3.0-1\nzfs-kmod-2.3.0-1\n", 2, 3, true},
+		{"zfs-2.2.4-FreeBSD_g256659204\nzfs-kmod-2.2.4-FreeBSD_g256659204\n", 2, 2, true},
+		{"unrecognized command 'version'\n", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		major, minor, ok := parseZfsVersion(test.out)
+		equals(t, test.major, major)
+		equals(t, test.minor, minor)
+		equals(t, test.ok, ok)
+	}
+}
+
+func TestParseJSONDatasets(t *testing.T) {
+	out := []byte(`{
+  "output_version": {"command": "zfs list", "vers_major": 0, "vers_minor": 1},
+  "datasets": {
+    "tank": {
+      "name": "tank",
+      "type": "FILESYSTEM",
+      "properties": {
+        "used": {"value": "1024", "source": {"type": "NONE", "data": "-"}},
+        "mountpoint": {"value": "/tank", "source": {"type": "DEFAULT", "data": "-"}}
+      }
+    },
+    "tank/a b": {
+      "name": "tank/a b",
+      "type": "FILESYSTEM",
+      "properties": {
+        "used": {"value": 2048, "source": {"type": "NONE", "data": "-"}},
+        "mountpoint": {"value": "/mnt/a b", "source": {"type": "LOCAL", "data": "-"}}
+      }
+    },
+    "tank/a b/c": {
+      "name": "tank/a b/c",
+      "type": "FILESYSTEM",
+      "properties": {
+        "used": {"value": "0", "source": {"type": "NONE", "data": "-"}}
+      }
+    }
+  }
+}`)
+
+	rows, err := parseJSONDatasets(out, []string{"name", "used", "mountpoint"})
+	ok(t, err)
+	equals(t, [][]string{
+		{"tank", "1024", "/tank"},
+		{"tank/a b", "2048", "/mnt/a b"},
+		{"tank/a b/c", "0", "-"},
+	}, rows)
+}
+
+func TestParseJSONPools(t *testing.T) {
+	out := []byte(`{
+  "output_version": {"command": "zpool get", "vers_major": 0, "vers_minor": 1},
+  "pools": {
+    "tank": {
+      "name": "tank",
+      "type": "POOL",
+      "properties": {
+        "health": {"value": "ONLINE", "source": {"type": "NONE", "data": "-"}},
+        "size": {"value": "1073741824", "source": {"type": "NONE", "data": "-"}}
+      }
+    }
+  }
+}`)
+
+	rows, err := parseJSONPools(out, []string{"health", "size"})
+	ok(t, err)
+	equals(t, [][]string{
+		{"tank", "health", "ONLINE"},
+		{"tank", "size", "1073741824"},
+	}, rows)
+
+	z := &Zpool{}
+	for _, row := range rows {
+		ok(t, z.parseLine(row))
+	}
+	equals(t, ZpoolOnline, z.Health)
+	equals(t, GiB, z.Size)
+}
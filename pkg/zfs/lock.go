@@ -0,0 +1,231 @@
+package zfs
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LockMode is the mode of a dataset lock.
+type LockMode int
+
+// Lock modes.  Shared locks on overlapping datasets can be held together,
+// while an exclusive lock conflicts with any other lock on the same dataset,
+// its descendents, its snapshots and its ancestors.
+const (
+	LockShared LockMode = iota
+	LockExclusive
+)
+
+// LockStats are the statistics of the dataset locks taken by mutating calls,
+// as returned by GetLockStats.
+type LockStats struct {
+	// Acquisitions is the number of locks acquired.
+	Acquisitions uint64
+	// Contentions is the number of locks that had to wait for a conflicting
+	// lock to be released.
+	Contentions uint64
+	// WaitTime is the total time spent waiting for locks.
+	WaitTime time.Duration
+	// MaxWaitTime is the longest time spent waiting for a lock.
+	MaxWaitTime time.Duration
+	// Held is the number of locks currently held.
+	Held int
+	// Waiting is the number of locks currently waited for.
+	Waiting int
+}
+
+type lockRequest struct {
+	name string
+	mode LockMode
+}
+
+func shared(name string) lockRequest {
+	return lockRequest{name: name, mode: LockShared}
+}
+
+func exclusive(name string) lockRequest {
+	return lockRequest{name: name, mode: LockExclusive}
+}
+
+// datasetOf returns the dataset of a snapshot name, or the name itself.
+func datasetOf(name string) string {
+	if i := strings.Index(name, "@"); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// overlaps reports whether a is b, or an ancestor or descendent of b.
+// Snapshots are descendents of their dataset.
+func overlaps(a, b string) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	if !strings.HasPrefix(b, a) {
+		return false
+	}
+	return len(a) == len(b) || b[len(a)] == '/' || b[len(a)] == '@'
+}
+
+// lockSet is a set of locks that are acquired and released together.
+type lockSet []lockRequest
+
+func (s lockSet) conflicts(o lockSet) bool {
+	for _, a := range s {
+		for _, b := range o {
+			if (a.mode == LockExclusive || b.mode == LockExclusive) && overlaps(a.name, b.name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lockManager serializes conflicting operations on datasets within this
+// process.  Locks are granted in the order in which they were requested, so
+// an exclusive lock is not starved by a stream of shared locks.
+type lockManager struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	held    []*lockSet
+	waiting []*lockSet
+	stats   LockStats
+}
+
+func newLockManager() *lockManager {
+	m := &lockManager{}
+	m.cond = sync.NewCond(&m.mu)
+	return m
+}
+
+var locks = newLockManager()
+
+// GetLockStats returns the statistics of the dataset locks taken by mutating
+// calls of this package.
+func GetLockStats() LockStats {
+	return locks.Stats()
+}
+
+func (m *lockManager) Stats() LockStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.stats
+	stats.Held = len(m.held)
+	stats.Waiting = len(m.waiting)
+	return stats
+}
+
+// lock blocks until all of reqs can be acquired and returns a function that
+// releases them, or returns the error of ctx if it is done before.  Locks are
+// not reentrant.
+func (m *lockManager) lock(ctx context.Context, reqs ...lockRequest) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s := lockSet(reqs)
+	start := time.Now()
+
+	m.mu.Lock()
+	m.waiting = append(m.waiting, &s)
+	contended := false
+	for !m.grantable(&s) {
+		if !contended && ctx.Done() != nil {
+			// wake the waiters when ctx is done, as a sync.Cond cannot
+			// wait for a channel
+			stop := make(chan struct{})
+			defer close(stop)
+			go func() {
+				select {
+				case <-ctx.Done():
+					m.mu.Lock()
+					m.cond.Broadcast()
+					m.mu.Unlock()
+				case <-stop:
+				}
+			}()
+		}
+		contended = true
+		if err := ctx.Err(); err != nil {
+			m.waiting = removeLockSet(m.waiting, &s)
+			// waiters queued behind this one may be grantable now
+			m.cond.Broadcast()
+			m.mu.Unlock()
+			return nil, err
+		}
+		m.cond.Wait()
+	}
+	m.waiting = removeLockSet(m.waiting, &s)
+	m.held = append(m.held, &s)
+
+	m.stats.Acquisitions++
+	if contended {
+		wait := time.Since(start)
+		m.stats.Contentions++
+		m.stats.WaitTime += wait
+		if wait > m.stats.MaxWaitTime {
+			m.stats.MaxWaitTime = wait
+		}
+		// waiters queued behind this one may be grantable now
+		m.cond.Broadcast()
+	}
+	m.mu.Unlock()
+
+	return func() {
+		// the locked datasets may have changed
+		names := make([]string, len(s))
+		for i, req := range s {
+			names[i] = req.name
+		}
+		cache.invalidate(names...)
+
+		m.mu.Lock()
+		m.held = removeLockSet(m.held, &s)
+		m.cond.Broadcast()
+		m.mu.Unlock()
+	}, nil
+}
+
+// exclusivelyLocked reports whether an exclusive lock is held on the dataset
+// with the specified name, one of its descendents or one of its ancestors.
+func (m *lockManager) exclusivelyLocked(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, h := range m.held {
+		for _, req := range *h {
+			if req.mode == LockExclusive && overlaps(req.name, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// grantable reports whether s conflicts neither with a held lock nor with a
+// lock that was requested before it.
+func (m *lockManager) grantable(s *lockSet) bool {
+	for _, h := range m.held {
+		if s.conflicts(*h) {
+			return false
+		}
+	}
+	for _, w := range m.waiting {
+		if w == s {
+			break
+		}
+		if s.conflicts(*w) {
+			return false
+		}
+	}
+	return true
+}
+
+func removeLockSet(sets []*lockSet, s *lockSet) []*lockSet {
+	for i, o := range sets {
+		if o == s {
+			return append(sets[:i], sets[i+1:]...)
+		}
+	}
+	return sets
+}
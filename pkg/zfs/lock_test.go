@@ -0,0 +1,134 @@
+package zfs
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestOverlaps(t *testing.T) {
+	var tests = []struct {
+		a, b     string
+		overlaps bool
+	}{
+		{"tank", "tank", true},
+		{"tank", "tank/a", true},
+		{"tank/a", "tank", true},
+		{"tank/a", "tank/a@snap", true},
+		{"tank/a", "tank/ab", false},
+		{"tank/a@snap", "tank/a@snap2", false},
+		{"tank/a", "tank/b", false},
+		{"tank", "tank2", false},
+	}
+
+	for _, test := range tests {
+		equals(t, test.overlaps, overlaps(test.a, test.b))
+	}
+}
+
+func TestLockSetConflicts(t *testing.T) {
+	equals(t, false, lockSet{shared("tank")}.conflicts(lockSet{shared("tank/a")}))
+	equals(t, true, lockSet{exclusive("tank")}.conflicts(lockSet{shared("tank/a")}))
+	equals(t, true, lockSet{shared("tank")}.conflicts(lockSet{exclusive("tank/a@s")}))
+	equals(t, false, lockSet{exclusive("tank/a")}.conflicts(lockSet{exclusive("tank/b")}))
+}
+
+// acquired reports whether the lock was acquired within a short time.
+func acquired(m *lockManager, reqs ...lockRequest) (bool, func()) {
+	done := make(chan func(), 1)
+	go func() {
+		unlock, _ := m.lock(context.Background(), reqs...)
+		done <- unlock
+	}()
+	select {
+	case unlock := <-done:
+		return true, unlock
+	case <-time.After(50 * time.Millisecond):
+		return false, func() { (<-done)() }
+	}
+}
+
+func TestLockManager(t *testing.T) {
+	m := newLockManager()
+
+	unlockParent, _ := m.lock(context.Background(), exclusive("tank/a"))
+
+	ok1, unlockOther := acquired(m, exclusive("tank/b"))
+	equals(t, true, ok1)
+	unlockOther()
+
+	ok2, unlockChild := acquired(m, shared("tank/a/child"))
+	equals(t, false, ok2)
+
+	stats := m.Stats()
+	equals(t, 1, stats.Held)
+	equals(t, 1, stats.Waiting)
+
+	unlockParent()
+	unlockChild()
+
+	stats = m.Stats()
+	equals(t, uint64(3), stats.Acquisitions)
+	equals(t, uint64(1), stats.Contentions)
+	assert(t, stats.WaitTime > 0, "WaitTime is not recorded")
+	equals(t, 0, stats.Held)
+	equals(t, 0, stats.Waiting)
+
+	// shared locks do not block each other
+	unlock1, _ := m.lock(context.Background(), shared("tank"))
+	ok3, unlock2 := acquired(m, shared("tank/a"))
+	equals(t, true, ok3)
+	unlock1()
+	unlock2()
+}
+
+func TestLockManagerOrder(t *testing.T) {
+	m := newLockManager()
+
+	unlockShared, _ := m.lock(context.Background(), shared("tank"))
+	okExclusive, unlockExclusive := acquired(m, exclusive("tank/a"))
+	equals(t, false, okExclusive)
+
+	// a later shared lock queues behind the waiting exclusive lock
+	okShared, unlockShared2 := acquired(m, shared("tank/a"))
+	equals(t, false, okShared)
+
+	unlockShared()
+	unlockExclusive()
+	unlockShared2()
+}
+
+func TestLockManagerCancel(t *testing.T) {
+	m := newLockManager()
+
+	unlockShared, _ := m.lock(context.Background(), shared("tank"))
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := m.lock(ctx, exclusive("tank/a"))
+		errc <- err
+	}()
+	for m.Stats().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// a later shared lock queues behind the waiting exclusive lock
+	okShared, unlockShared2 := acquired(m, shared("tank/a"))
+	equals(t, false, okShared)
+
+	// the canceled lock stops waiting, and no longer blocks the locks queued
+	// behind it
+	cancel()
+	select {
+	case err := <-errc:
+		equals(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("canceled lock is still waiting")
+	}
+	unlockShared2()
+	equals(t, 1, m.Stats().Held)
+	equals(t, 0, m.Stats().Waiting)
+	unlockShared()
+
+	_, err := m.lock(ctx, shared("tank"))
+	equals(t, context.Canceled, err)
+}
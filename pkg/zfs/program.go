@@ -0,0 +1,229 @@
+package zfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProgramOptions are the options passed to RunProgram.
+type ProgramOptions struct {
+	// Sync runs the program in syncing context, so it can modify the pool.
+	// Otherwise the program is run read-only (zfs program -n).
+	Sync bool
+	// MemLimit is the memory limit of the program, or zero for the default.
+	MemLimit Bytes
+	// InstrLimit is the instruction limit of the program, or zero for the
+	// default.
+	InstrLimit uint64
+}
+
+// ProgramResult is the result of a ZFS channel program.
+type ProgramResult struct {
+	// Return is the value returned by the program, decoded from JSON.  Lua
+	// tables are returned as map[string]interface{}, including arrays, which
+	// are keyed by their index.
+	Return interface{}
+	// Output is the raw output of zfs program.
+	Output string
+}
+
+// RunProgram runs a Lua channel program on a zpool with zfs program.  All
+// of the changes made by a program in syncing context are applied in the same
+// transaction group, which makes channel programs suitable for atomic bulk
+// operations.  The arguments are passed to the program in argv.
+// A full description of channel programs may be found here:
+// https://www.freebsd.org/cgi/man.cgi?zfs-program(8).
+func RunProgram(ctx context.Context, pool, script string, args []string, opts ProgramOptions) (*ProgramResult, error) {
+	// a program may change any dataset of the pool
+	req := shared(pool)
+	if opts.Sync {
+		req = exclusive(pool)
+	}
+	unlock, err := locks.lock(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return runProgram(ctx, pool, script, args, opts)
+}
+
+func runProgram(ctx context.Context, pool, script string, args []string, opts ProgramOptions) (*ProgramResult, error) {
+	f, err := ioutil.TempFile("", "zfs-program-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(script)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// channel programs have supported -j since they were introduced
+	cli := []string{"program", "-j"}
+	if !opts.Sync {
+		cli = append(cli, "-n")
+	}
+	if opts.InstrLimit != 0 {
+		cli = append(cli, "-t", strconv.FormatUint(opts.InstrLimit, 10))
+	}
+	if opts.MemLimit != 0 {
+		cli = append(cli, "-m", opts.MemLimit.Exact())
+	}
+	cli = append(cli, pool, f.Name())
+	cli = append(cli, args...)
+
+	var out bytes.Buffer
+	c := command{Command: "zfs", Stdout: &out, Context: ctx}
+	if _, err := c.Run(cli...); err != nil {
+		return nil, err
+	}
+
+	result := &ProgramResult{Output: out.String()}
+	var ret struct {
+		Return interface{} `json:"return"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &ret); err == nil {
+		result.Return = ret.Return
+	}
+	return result, nil
+}
+
+// destroySnapshotsProgram destroys the snapshots of a dataset whose short
+// name matches a Lua pattern and, optionally, that were created before a
+// time.  Either all of the snapshots are destroyed, or none of them if one of
+// them can not be destroyed.
+//
+// argv: dataset, pattern, created before (unix time, 0 for any), dry run
+const destroySnapshotsProgram = `
+args = ...
+argv = args["argv"]
+dataset = argv[1]
+pattern = argv[2]
+before = tonumber(argv[3])
+dryrun = argv[4] == "true"
+
+matched = {}
+failed = {}
+for snap in zfs.list.snapshots(dataset) do
+	short = string.sub(snap, string.len(dataset) + 2)
+	if string.match(short, pattern) then
+		if before == 0 or zfs.get_prop(snap, "creation") < before then
+			err = zfs.check.destroy(snap)
+			if err == 0 then
+				table.insert(matched, snap)
+			else
+				failed[snap] = err
+			end
+		end
+	end
+end
+
+if next(failed) ~= nil or dryrun then
+	return {destroyed = {}, matched = matched, failed = failed}
+end
+
+for _, snap in ipairs(matched) do
+	err = zfs.sync.destroy(snap)
+	if err ~= 0 then
+		failed[snap] = err
+	end
+end
+return {destroyed = matched, matched = matched, failed = failed}
+`
+
+// DestroySnapshotsResult is the result of DestroySnapshotsMatching.
+type DestroySnapshotsResult struct {
+	// Destroyed are the snapshots that were destroyed.
+	Destroyed []string
+	// Matched are the snapshots that matched the pattern.
+	Matched []string
+	// Failed are the snapshots that could not be destroyed, with their
+	// error numbers.
+	Failed map[string]int
+}
+
+// DestroySnapshotsMatching atomically destroys the snapshots of dataset
+// whose name after the "@" matches a Lua pattern, such as "^hourly%-".  If
+// before is not zero, only snapshots created before that time are destroyed.
+// If any of the snapshots can not be destroyed, none of them are.  If dryRun
+// is set, the matching snapshots are returned without destroying them.
+func DestroySnapshotsMatching(ctx context.Context, dataset, pattern string, before time.Time, dryRun bool) (*DestroySnapshotsResult, error) {
+	pool := dataset
+	if i := strings.IndexAny(dataset, "/@"); i >= 0 {
+		pool = dataset[:i]
+	}
+
+	var createdBefore int64
+	if !before.IsZero() {
+		createdBefore = before.Unix()
+	}
+	args := []string{dataset, pattern, strconv.FormatInt(createdBefore, 10), strconv.FormatBool(dryRun)}
+
+	req := exclusive(dataset)
+	if dryRun {
+		req = shared(dataset)
+	}
+	unlock, err := locks.lock(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	res, err := runProgram(ctx, pool, destroySnapshotsProgram, args, ProgramOptions{Sync: !dryRun})
+	if err != nil {
+		return nil, err
+	}
+	return parseDestroySnapshotsResult(res.Return), nil
+}
+
+func parseDestroySnapshotsResult(ret interface{}) *DestroySnapshotsResult {
+	result := &DestroySnapshotsResult{Failed: make(map[string]int)}
+	m, _ := ret.(map[string]interface{})
+	result.Destroyed = luaStrings(m["destroyed"])
+	result.Matched = luaStrings(m["matched"])
+	if failed, ok := m["failed"].(map[string]interface{}); ok {
+		for snap, errno := range failed {
+			if n, ok := errno.(float64); ok {
+				result.Failed[snap] = int(n)
+			}
+		}
+	}
+	return result
+}
+
+// luaStrings converts a Lua array, which is encoded as a JSON object keyed by
+// index, into a slice.
+func luaStrings(v interface{}) []string {
+	var values []string
+	switch t := v.(type) {
+	case []interface{}:
+		for _, e := range t {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]int, 0, len(t))
+		for k := range t {
+			if i, err := strconv.Atoi(k); err == nil {
+				keys = append(keys, i)
+			}
+		}
+		sort.Ints(keys)
+		for _, k := range keys {
+			if s, ok := t[strconv.Itoa(k)].(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
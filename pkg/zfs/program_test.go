@@ -0,0 +1,31 @@
+package zfs
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseDestroySnapshotsResult(t *testing.T) {
+	out := []byte(`{"return": {
+		"destroyed": {"1": "tank/fs@hourly-1", "2": "tank/fs@hourly-2", "10": "tank/fs@hourly-10"},
+		"matched": {"1": "tank/fs@hourly-1", "2": "tank/fs@hourly-2", "10": "tank/fs@hourly-10"},
+		"failed": {}
+	}}`)
+
+	var ret struct {
+		Return interface{} `json:"return"`
+	}
+	ok(t, json.Unmarshal(out, &ret))
+
+	result := parseDestroySnapshotsResult(ret.Return)
+	equals(t, []string{"tank/fs@hourly-1", "tank/fs@hourly-2", "tank/fs@hourly-10"}, result.Destroyed)
+	equals(t, result.Destroyed, result.Matched)
+	equals(t, map[string]int{}, result.Failed)
+
+	result = parseDestroySnapshotsResult(map[string]interface{}{
+		"destroyed": map[string]interface{}{},
+		"failed":    map[string]interface{}{"tank/fs@held": float64(16)},
+	})
+	equals(t, 0, len(result.Destroyed))
+	equals(t, map[string]int{"tank/fs@held": 16}, result.Failed)
+}
@@ -0,0 +1,158 @@
+package zfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+// SendOptions are the options of SendSnapshotWithOptions.
+type SendOptions struct {
+	// From is the snapshot or bookmark an incremental stream starts from.
+	// A name starting with @ or # is relative to the dataset of the sent
+	// snapshot.  An empty From sends a full stream.
+	From string
+	// Intermediary includes the snapshots between From and the sent snapshot
+	// in an incremental stream.
+	Intermediary bool
+	// Raw sends encrypted datasets without decrypting them.
+	Raw bool
+}
+
+// SendSnapshotWithOptions writes a stream of the receiving snapshot to
+// output, until the stream is complete or ctx is done.
+func (d *Dataset) SendSnapshotWithOptions(ctx context.Context, output io.Writer, opts SendOptions) error {
+	if d.Type != DatasetSnapshot {
+		return errors.New("can only send snapshots")
+	}
+
+	args := []string{"send"}
+	if opts.Raw {
+		args = append(args, "-w")
+	}
+	if opts.From != "" {
+		if opts.Intermediary {
+			args = append(args, "-I", opts.From)
+		} else {
+			args = append(args, "-i", opts.From)
+		}
+	}
+	args = append(args, d.Name)
+
+	unlock, err := locks.lock(ctx, shared(d.Name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	c := command{Command: "zfs", Stdout: output, Context: ctx}
+	_, err = c.Run(args...)
+	return err
+}
+
+// ResumeSend writes the remainder of an interrupted stream to output.  token
+// is the receive_resume_token of the receiving dataset, as returned by
+// ResumeToken.
+func ResumeSend(ctx context.Context, output io.Writer, token string) error {
+	if token == "" {
+		return errors.New("resume token is required")
+	}
+	// the snapshot is only known to zfs, which decodes the token
+	c := command{Command: "zfs", Stdout: output, Context: ctx}
+	_, err := c.Run("send", "-t", token)
+	return err
+}
+
+// ResumeTokenSnapshot returns the name of the snapshot that the remainder of
+// an interrupted stream is sent from, as decoded from token by zfs send -nvt.
+func ResumeTokenSnapshot(ctx context.Context, token string) (string, error) {
+	if token == "" {
+		return "", errors.New("resume token is required")
+	}
+	var out bytes.Buffer
+	c := command{Command: "zfs", Stdout: &out, Context: ctx}
+	if _, err := c.Run("send", "-nvt", token); err != nil {
+		return "", err
+	}
+	return parseResumeTokenSnapshot(out.String())
+}
+
+// parseResumeTokenSnapshot returns the toname of the resume token contents
+// printed by zfs send -nvt.
+func parseResumeTokenSnapshot(out string) (string, error) {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "toname" && fields[1] == "=" {
+			return fields[2], nil
+		}
+	}
+	return "", errors.New("resume token without snapshot name")
+}
+
+// ReceiveOptions are the options of ReceiveSnapshotWithOptions.
+type ReceiveOptions struct {
+	// Resumable keeps the state of an interrupted receive, so that the stream
+	// can be resumed with the ResumeToken of the dataset.
+	Resumable bool
+	// Force rolls the dataset back to its latest snapshot before receiving an
+	// incremental stream, or destroys it before receiving a full stream.
+	Force bool
+	// Unmounted does not mount a received filesystem.
+	Unmounted bool
+	// Exclude lists properties whose values in the stream are ignored, as if
+	// they had not been sent.
+	Exclude []string
+}
+
+// ReceiveSnapshotWithOptions receives a stream from input into the dataset
+// with the specified name, until the stream ends or ctx is done.
+func ReceiveSnapshotWithOptions(ctx context.Context, input io.Reader, name string, opts ReceiveOptions) (*Dataset, error) {
+	args := receiveArgs(name, opts)
+	unlock, err := locks.lock(ctx, exclusive(name))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	c := command{Command: "zfs", Stdin: input, Context: ctx}
+	if _, err := c.Run(args...); err != nil {
+		return nil, err
+	}
+	return GetDatasetContext(ctx, name)
+}
+
+func receiveArgs(name string, opts ReceiveOptions) []string {
+	args := []string{"receive"}
+	if opts.Resumable {
+		args = append(args, "-s")
+	}
+	if opts.Force {
+		args = append(args, "-F")
+	}
+	if opts.Unmounted {
+		args = append(args, "-u")
+	}
+	for _, prop := range opts.Exclude {
+		args = append(args, "-x", prop)
+	}
+	return append(args, name)
+}
+
+// ResumeToken returns the token to resume an interrupted resumable receive
+// into the receiving dataset, or empty string ("") if there is none.
+func (d *Dataset) ResumeToken() (string, error) {
+	return d.ResumeTokenContext(context.Background())
+}
+
+// ResumeTokenContext is like ResumeToken, but kills zfs get when ctx is done.
+func (d *Dataset) ResumeTokenContext(ctx context.Context) (string, error) {
+	values, err := zfsGet(ctx, d.Name, "receive_resume_token")
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(values[0])
+	if token == "-" {
+		return "", nil
+	}
+	return token, nil
+}
@@ -0,0 +1,34 @@
+package zfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReceiveArgs(t *testing.T) {
+	got := receiveArgs("tank/a", ReceiveOptions{Resumable: true, Exclude: []string{"mountpoint", "jailed"}})
+	want := []string{"receive", "-s", "-x", "mountpoint", "-x", "jailed", "tank/a"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseResumeTokenSnapshot(t *testing.T) {
+	out := `resume token contents:
+nvlist version: 0
+	fromguid = 0x6c4a2ec5a2d9f3b1
+	object = 0x1
+	offset = 0x0
+	bytes = 0x3c6fa0
+	toguid = 0x2b3e7c95fd0d1a44
+	toname = tank/k8s/team-a/vol@daily
+send from tank/k8s/team-a/vol@hourly to tank/k8s/team-a/vol@daily estimated size is 12.5M
+`
+	name, err := parseResumeTokenSnapshot(out)
+	ok(t, err)
+	equals(t, "tank/k8s/team-a/vol@daily", name)
+
+	if _, err := parseResumeTokenSnapshot("resume token contents:\nnvlist version: 0\n"); err == nil {
+		t.Error("contents without toname were parsed")
+	}
+}
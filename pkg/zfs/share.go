@@ -0,0 +1,273 @@
+package zfs
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// NFSShareOptions are the options of the sharenfs property, in the format of
+// FreeBSD exports(5).  An export has at most one network; further clients can
+// be listed as hosts.
+type NFSShareOptions struct {
+	ReadOnly bool     `json:"read_only,omitempty"`
+	Maproot  string   `json:"maproot,omitempty"`
+	Mapall   string   `json:"mapall,omitempty"`
+	Network  string   `json:"network,omitempty"`
+	Sec      []string `json:"sec,omitempty"`
+	Hosts    []string `json:"hosts,omitempty"`
+}
+
+// String returns the value of the sharenfs property for the options.
+func (o *NFSShareOptions) String() string {
+	var opts []string
+	if o.ReadOnly {
+		opts = append(opts, "-ro")
+	}
+	if o.Maproot != "" {
+		opts = append(opts, "-maproot="+o.Maproot)
+	}
+	if o.Mapall != "" {
+		opts = append(opts, "-mapall="+o.Mapall)
+	}
+	if o.Network != "" {
+		opts = append(opts, "-network="+o.Network)
+	}
+	if len(o.Sec) > 0 {
+		opts = append(opts, "-sec="+strings.Join(o.Sec, ":"))
+	}
+	opts = append(opts, o.Hosts...)
+
+	if len(opts) == 0 {
+		return "on"
+	}
+	return strings.Join(opts, " ")
+}
+
+// ParseNFSShareOptions parses the value of the sharenfs property.  Nil is
+// returned if sharing is off.  Options may be given as -option=value or
+// -option value, and a network given with -mask is returned in CIDR notation.
+func ParseNFSShareOptions(value string) *NFSShareOptions {
+	switch value {
+	case "", "-", "off":
+		return nil
+	case "on":
+		return &NFSShareOptions{}
+	}
+
+	o := &NFSShareOptions{}
+	mask := ""
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == ','
+	})
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		kv := strings.SplitN(strings.TrimLeft(f, "-"), "=", 2)
+		// the value of an option may be the next field
+		arg := func() string {
+			if len(kv) == 2 {
+				return kv[1]
+			}
+			if i+1 < len(fields) {
+				i++
+				return fields[i]
+			}
+			return ""
+		}
+		switch kv[0] {
+		case "ro":
+			o.ReadOnly = true
+		case "maproot":
+			o.Maproot = arg()
+		case "mapall":
+			o.Mapall = arg()
+		case "network":
+			o.Network = arg()
+		case "mask":
+			mask = arg()
+		case "sec":
+			o.Sec = strings.Split(arg(), ":")
+		default:
+			if !strings.HasPrefix(f, "-") {
+				o.Hosts = append(o.Hosts, f)
+			}
+		}
+	}
+
+	if mask != "" && o.Network != "" && !strings.Contains(o.Network, "/") {
+		if ip := net.ParseIP(mask).To4(); ip != nil {
+			if ones, bits := net.IPMask(ip).Size(); bits != 0 {
+				o.Network += "/" + strconv.Itoa(ones)
+			}
+		}
+	}
+	return o
+}
+
+// SMBShareOptions are the options of the sharesmb property.
+type SMBShareOptions struct {
+	Name    string `json:"name,omitempty"`
+	GuestOK bool   `json:"guest_ok,omitempty"`
+}
+
+// String returns the value of the sharesmb property for the options.
+func (o *SMBShareOptions) String() string {
+	var opts []string
+	if o.Name != "" {
+		opts = append(opts, "name="+o.Name)
+	}
+	if o.GuestOK {
+		opts = append(opts, "guestok=true")
+	}
+
+	if len(opts) == 0 {
+		return "on"
+	}
+	return strings.Join(opts, ",")
+}
+
+// ParseSMBShareOptions parses the value of the sharesmb property.  Nil is
+// returned if sharing is off.
+func ParseSMBShareOptions(value string) *SMBShareOptions {
+	switch value {
+	case "", "-", "off":
+		return nil
+	}
+
+	o := &SMBShareOptions{}
+	for _, f := range strings.Split(value, ",") {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "name":
+			o.Name = kv[1]
+		case "guestok":
+			o.GuestOK, _ = strconv.ParseBool(kv[1])
+		}
+	}
+	return o
+}
+
+// Share is a ZFS filesystem shared over NFS or SMB.
+type Share struct {
+	Name       string
+	Mountpoint string
+	NFS        *NFSShareOptions
+	SMB        *SMBShareOptions
+}
+
+// SetShareOptions sets the sharenfs and sharesmb properties of the receiving
+// ZFS filesystem.  A nil value turns the corresponding share off.
+func (d *Dataset) SetShareOptions(nfs *NFSShareOptions, smb *SMBShareOptions) error {
+	return d.SetShareOptionsContext(context.Background(), nfs, smb)
+}
+
+// SetShareOptionsContext is like SetShareOptions, but kills zfs set when ctx
+// is done.
+func (d *Dataset) SetShareOptionsContext(ctx context.Context, nfs *NFSShareOptions, smb *SMBShareOptions) error {
+	if d.Type != DatasetFilesystem {
+		return errors.New("can only share filesystems")
+	}
+
+	nfsValue, smbValue := "off", "off"
+	if nfs != nil {
+		nfsValue = nfs.String()
+	}
+	if smb != nil {
+		smbValue = smb.String()
+	}
+	unlock, err := locks.lock(ctx, exclusive(d.Name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := d.setProperty(ctx, "sharenfs", nfsValue); err != nil {
+		return err
+	}
+	return d.setProperty(ctx, "sharesmb", smbValue)
+}
+
+// Share shares the receiving ZFS filesystem according to its sharenfs and
+// sharesmb properties.
+func (d *Dataset) Share() error {
+	return d.ShareContext(context.Background())
+}
+
+// ShareContext is like Share, but kills zfs share when ctx is done.
+func (d *Dataset) ShareContext(ctx context.Context) error {
+	if d.Type != DatasetFilesystem {
+		return errors.New("can only share filesystems")
+	}
+	unlock, err := locks.lock(ctx, exclusive(d.Name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	_, err = zfs(ctx, "share", d.Name)
+	return err
+}
+
+// Unshare stops sharing the receiving ZFS filesystem.  The share properties
+// are kept, so the filesystem is shared again on the next mount; use
+// SetShareOptions to remove a share permanently.
+func (d *Dataset) Unshare() error {
+	return d.UnshareContext(context.Background())
+}
+
+// UnshareContext is like Unshare, but kills zfs unshare when ctx is done.
+func (d *Dataset) UnshareContext(ctx context.Context) error {
+	if d.Type != DatasetFilesystem {
+		return errors.New("can only unshare filesystems")
+	}
+	unlock, err := locks.lock(ctx, exclusive(d.Name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	_, err = zfs(ctx, "unshare", d.Name)
+	return err
+}
+
+// Shares returns the ZFS filesystems that are shared over NFS or SMB.
+// A filter argument may be passed to select the filesystems below a dataset,
+// or empty string ("") may be used to select all filesystems.
+func Shares(filter string) ([]*Share, error) {
+	return SharesContext(context.Background(), filter)
+}
+
+// SharesContext is like Shares, but kills zfs list when ctx is done.
+func SharesContext(ctx context.Context, filter string) ([]*Share, error) {
+	args := []string{"list", "-rH", "-t", DatasetFilesystem, "-o", "name,mountpoint,sharenfs,sharesmb"}
+	if filter != "" {
+		args = append(args, filter)
+	}
+	out, err := zfs(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseShares(out), nil
+}
+
+func parseShares(out [][]string) []*Share {
+	var shares []*Share
+	for _, line := range out {
+		if len(line) != 4 {
+			continue
+		}
+		s := &Share{
+			NFS: ParseNFSShareOptions(line[2]),
+			SMB: ParseSMBShareOptions(line[3]),
+		}
+		if s.NFS == nil && s.SMB == nil {
+			continue
+		}
+		setString(&s.Name, line[0])
+		setString(&s.Mountpoint, line[1])
+		shares = append(shares, s)
+	}
+	return shares
+}
@@ -0,0 +1,49 @@
+package zfs
+
+import (
+	"testing"
+)
+
+func TestNFSShareOptions(t *testing.T) {
+	o := &NFSShareOptions{
+		ReadOnly: true,
+		Maproot:  "root",
+		Network:  "10.0.0.0/24",
+		Sec:      []string{"sys", "krb5"},
+		Hosts:    []string{"backup.example.com"},
+	}
+	equals(t, "-ro -maproot=root -network=10.0.0.0/24 -sec=sys:krb5 backup.example.com", o.String())
+	equals(t, o, ParseNFSShareOptions(o.String()))
+	equals(t, o, ParseNFSShareOptions("ro,maproot=root,network=10.0.0.0/24,sec=sys:krb5,backup.example.com"))
+
+	equals(t, o, ParseNFSShareOptions("-ro -maproot root -network 10.0.0.0/24 -sec sys:krb5 backup.example.com"))
+	equals(t, o, ParseNFSShareOptions("-ro -maproot=root -network 10.0.0.0 -mask 255.255.255.0 -sec=sys:krb5 backup.example.com"))
+	equals(t, &NFSShareOptions{Network: "10.0.0.0/8"}, ParseNFSShareOptions("-network=10.0.0.0 -mask=255.0.0.0"))
+	equals(t, &NFSShareOptions{Mapall: "nobody", Hosts: []string{"a.example.com", "b.example.com"}}, ParseNFSShareOptions("a.example.com -mapall nobody b.example.com"))
+
+	equals(t, "on", (&NFSShareOptions{}).String())
+	equals(t, &NFSShareOptions{}, ParseNFSShareOptions("on"))
+	equals(t, (*NFSShareOptions)(nil), ParseNFSShareOptions("off"))
+}
+
+func TestSMBShareOptions(t *testing.T) {
+	o := &SMBShareOptions{Name: "media", GuestOK: true}
+	equals(t, "name=media,guestok=true", o.String())
+	equals(t, o, ParseSMBShareOptions(o.String()))
+
+	equals(t, "on", (&SMBShareOptions{}).String())
+	equals(t, (*SMBShareOptions)(nil), ParseSMBShareOptions("off"))
+}
+
+func TestParseShares(t *testing.T) {
+	out := [][]string{
+		{"tank", "/tank", "off", "off"},
+		{"tank/nfs", "/tank/nfs", "-ro", "off"},
+		{"tank/smb", "/tank/smb", "off", "on"},
+	}
+
+	equals(t, []*Share{
+		{Name: "tank/nfs", Mountpoint: "/tank/nfs", NFS: &NFSShareOptions{ReadOnly: true}},
+		{Name: "tank/smb", Mountpoint: "/tank/smb", SMB: &SMBShareOptions{}},
+	}, parseShares(out))
+}
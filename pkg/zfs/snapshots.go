@@ -0,0 +1,99 @@
+package zfs
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// SortSnapshots sorts snapshots chronologically, oldest first.  Snapshots are
+// ordered by the transaction group they were created in, as the creation time
+// only has a resolution of one second.
+func SortSnapshots(snapshots []*Dataset) {
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Createtxg < snapshots[j].Createtxg
+	})
+}
+
+// ownSnapshots returns the snapshots of the receiving dataset, without the
+// snapshots of its descendents, oldest first.
+func (d *Dataset) ownSnapshots() ([]*Dataset, error) {
+	out, err := zfsList(context.Background(), "-d", "1", "-t", DatasetSnapshot, d.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]*Dataset, 0, len(out))
+	for _, line := range out {
+		s := &Dataset{}
+		if err := s.parseLine(line); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, s)
+	}
+	SortSnapshots(snapshots)
+	return snapshots, nil
+}
+
+// LatestSnapshot returns the most recent snapshot of the receiving dataset,
+// or nil if it has no snapshots.
+func (d *Dataset) LatestSnapshot() (*Dataset, error) {
+	snapshots, err := d.ownSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) == 0 {
+		return nil, nil
+	}
+	return snapshots[len(snapshots)-1], nil
+}
+
+// SnapshotsBetween returns the snapshots of the receiving dataset that were
+// created between t1 and t2, inclusive, oldest first.  A zero time leaves
+// that end of the range open.
+func (d *Dataset) SnapshotsBetween(t1, t2 time.Time) ([]*Dataset, error) {
+	snapshots, err := d.ownSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	return snapshotsBetween(snapshots, t1, t2), nil
+}
+
+func snapshotsBetween(snapshots []*Dataset, t1, t2 time.Time) []*Dataset {
+	var between []*Dataset
+	for _, s := range snapshots {
+		if !t1.IsZero() && s.Creation.Before(t1) {
+			continue
+		}
+		if !t2.IsZero() && s.Creation.After(t2) {
+			continue
+		}
+		between = append(between, s)
+	}
+	return between
+}
+
+// CommonSnapshot returns the most recent snapshot of the receiving dataset
+// that is also in other, such as the snapshots of a replica on another host.
+// Snapshots are matched by guid, so renamed snapshots are found as well.
+// Nil is returned if there is no common snapshot.
+func (d *Dataset) CommonSnapshot(other []*Dataset) (*Dataset, error) {
+	snapshots, err := d.ownSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	return latestCommonSnapshot(snapshots, other), nil
+}
+
+func latestCommonSnapshot(snapshots, other []*Dataset) *Dataset {
+	guids := make(map[uint64]bool, len(other))
+	for _, s := range other {
+		guids[s.GUID] = true
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if guids[snapshots[i].GUID] {
+			return snapshots[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package zfs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSnapshotHelpers(t *testing.T) {
+	snapshots := []*Dataset{
+		{Name: "tank/fs@c", Createtxg: 30, GUID: 3, Creation: time.Unix(300, 0)},
+		{Name: "tank/fs@a", Createtxg: 10, GUID: 1, Creation: time.Unix(100, 0)},
+		{Name: "tank/fs@b", Createtxg: 20, GUID: 2, Creation: time.Unix(200, 0)},
+	}
+	SortSnapshots(snapshots)
+	equals(t, "tank/fs@a", snapshots[0].Name)
+	equals(t, "tank/fs@c", snapshots[2].Name)
+
+	between := snapshotsBetween(snapshots, time.Unix(150, 0), time.Unix(300, 0))
+	equals(t, 2, len(between))
+	equals(t, "tank/fs@b", between[0].Name)
+	equals(t, 3, len(snapshotsBetween(snapshots, time.Time{}, time.Time{})))
+
+	replica := []*Dataset{
+		{Name: "backup/fs@a", GUID: 1},
+		{Name: "backup/fs@renamed", GUID: 2},
+		{Name: "backup/fs@x", GUID: 42},
+	}
+	equals(t, "tank/fs@b", latestCommonSnapshot(snapshots, replica).Name)
+	equals(t, (*Dataset)(nil), latestCommonSnapshot(snapshots, replica[2:]))
+}
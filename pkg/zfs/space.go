@@ -0,0 +1,145 @@
+package zfs
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// List of properties retrieved by SpaceReport, as shown by zfs list -o space
+var spacePropList = []string{"name", "available", "used", "usedbysnapshots", "usedbydataset", "usedbychildren", "usedbyrefreservation", "compressratio", "logicalreferenced"}
+
+// SpaceNode is the space accounting of a filesystem or volume in the tree
+// returned by SpaceReport.
+type SpaceNode struct {
+	Name                 string  `json:"name"`
+	Avail                Bytes   `json:"avail"`
+	Used                 Bytes   `json:"used"`
+	UsedBySnapshots      Bytes   `json:"usedbysnapshots"`
+	UsedByDataset        Bytes   `json:"usedbydataset"`
+	UsedByChildren       Bytes   `json:"usedbychildren"`
+	UsedByRefreservation Bytes   `json:"usedbyrefreservation"`
+	CompressRatio        float64 `json:"compressratio"`
+	LogicalReferenced    Bytes   `json:"logicalreferenced"`
+
+	// Total is the space used by the node and all of its descendents, broken
+	// down by what the space is used by.
+	Total SpaceTotal `json:"total"`
+
+	// Children are sorted by used space, largest first.
+	Children []*SpaceNode `json:"children,omitempty"`
+}
+
+// SpaceTotal is the space used by a subtree of datasets, rolled up from the
+// usedby properties of each of them.
+type SpaceTotal struct {
+	Snapshots         Bytes `json:"snapshots"`
+	Datasets          Bytes `json:"datasets"`
+	Refreservation    Bytes `json:"refreservation"`
+	LogicalReferenced Bytes `json:"logicalreferenced"`
+}
+
+// SpaceReport returns the space accounting of the filesystems and volumes
+// below root, including root, as a tree.  All of the properties are fetched
+// with a single zfs list call.
+func SpaceReport(root string) (*SpaceNode, error) {
+	return SpaceReportContext(context.Background(), root)
+}
+
+// SpaceReportContext is like SpaceReport, but kills zfs list when ctx is done.
+func SpaceReportContext(ctx context.Context, root string) (*SpaceNode, error) {
+	out, err := zfsListProps(ctx, spacePropList, "-r", "-t", "filesystem,volume", root)
+	if err != nil {
+		return nil, err
+	}
+	return buildSpaceTree(out)
+}
+
+func buildSpaceTree(out [][]string) (*SpaceNode, error) {
+	var root *SpaceNode
+	nodes := make(map[string]*SpaceNode, len(out))
+	for _, line := range out {
+		n := &SpaceNode{}
+		if err := n.parseLine(line); err != nil {
+			return nil, err
+		}
+		nodes[n.Name] = n
+
+		var parent *SpaceNode
+		if i := strings.LastIndex(n.Name, "/"); i >= 0 {
+			parent = nodes[n.Name[:i]]
+		}
+		if parent != nil {
+			parent.Children = append(parent.Children, n)
+		} else if root == nil {
+			root = n
+		}
+	}
+	if root == nil {
+		return nil, errors.New("no datasets found")
+	}
+
+	root.rollUp()
+	return root, nil
+}
+
+// rollUp computes the totals of the subtree and sorts the children.
+func (n *SpaceNode) rollUp() SpaceTotal {
+	n.Total = SpaceTotal{
+		Snapshots:         n.UsedBySnapshots,
+		Datasets:          n.UsedByDataset,
+		Refreservation:    n.UsedByRefreservation,
+		LogicalReferenced: n.LogicalReferenced,
+	}
+	for _, c := range n.Children {
+		t := c.rollUp()
+		n.Total.Snapshots += t.Snapshots
+		n.Total.Datasets += t.Datasets
+		n.Total.Refreservation += t.Refreservation
+		n.Total.LogicalReferenced += t.LogicalReferenced
+	}
+	sort.SliceStable(n.Children, func(i, j int) bool {
+		return n.Children[i].Used > n.Children[j].Used
+	})
+	return n.Total
+}
+
+func (n *SpaceNode) parseLine(line []string) error {
+	if len(line) != len(spacePropList) {
+		return errors.New("Output does not match what is expected on this platform")
+	}
+
+	var err error
+	for i, prop := range spacePropList {
+		val := line[i]
+		switch prop {
+		case "name":
+			setString(&n.Name, val)
+		case "available":
+			err = setBytes(&n.Avail, val)
+		case "used":
+			err = setBytes(&n.Used, val)
+		case "usedbysnapshots":
+			err = setBytes(&n.UsedBySnapshots, val)
+		case "usedbydataset":
+			err = setBytes(&n.UsedByDataset, val)
+		case "usedbychildren":
+			err = setBytes(&n.UsedByChildren, val)
+		case "usedbyrefreservation":
+			err = setBytes(&n.UsedByRefreservation, val)
+		case "compressratio":
+			if val != "-" {
+				// Trim trailing "x" before parsing float64, -p omits it
+				n.CompressRatio, err = strconv.ParseFloat(strings.TrimSuffix(val, "x"), 64)
+			}
+		case "logicalreferenced":
+			err = setBytes(&n.LogicalReferenced, val)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package zfs
+
+import (
+	"testing"
+)
+
+func TestBuildSpaceTree(t *testing.T) {
+	out := [][]string{
+		{"tank", "1000", "700", "0", "100", "600", "0", "1.50", "150"},
+		{"tank/a", "1000", "200", "50", "150", "0", "0", "1.00", "150"},
+		{"tank/b", "1000", "400", "100", "100", "200", "0", "2.00x", "200"},
+		{"tank/b/vol", "1000", "200", "0", "20", "0", "180", "1.00", "20"},
+	}
+
+	root, err := buildSpaceTree(out)
+	ok(t, err)
+
+	equals(t, "tank", root.Name)
+	equals(t, 1.5, root.CompressRatio)
+	equals(t, 2, len(root.Children))
+	equals(t, "tank/b", root.Children[0].Name)
+	equals(t, "tank/a", root.Children[1].Name)
+	equals(t, 2.0, root.Children[0].CompressRatio)
+	equals(t, "tank/b/vol", root.Children[0].Children[0].Name)
+
+	equals(t, SpaceTotal{
+		Snapshots:         150,
+		Datasets:          370,
+		Refreservation:    180,
+		LogicalReferenced: 520,
+	}, root.Total)
+	equals(t, SpaceTotal{
+		Snapshots:         100,
+		Datasets:          120,
+		Refreservation:    180,
+		LogicalReferenced: 220,
+	}, root.Children[0].Total)
+
+	_, err = buildSpaceTree(nil)
+	nok(t, err)
+}
@@ -0,0 +1,363 @@
+package zfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Scan states of a zpool.
+const (
+	ScanNone     = "none"
+	ScanScanning = "scanning"
+	ScanFinished = "finished"
+	ScanCanceled = "canceled"
+)
+
+// Vdev is a virtual device of a zpool, as shown by zpool status.  The vdevs
+// of a zpool form a tree rooted at a vdev named after the zpool.  Log, cache
+// and spare devices are grouped below vdevs named logs, cache and spares,
+// which have no state.
+type Vdev struct {
+	Name           string `json:"name"`
+	State          string `json:"state,omitempty"`
+	ReadErrors     uint64 `json:"read_errors"`
+	WriteErrors    uint64 `json:"write_errors"`
+	ChecksumErrors uint64 `json:"checksum_errors"`
+	// Message is the note zpool status prints after the error counts, e.g.
+	// "too many errors".
+	Message  string  `json:"message,omitempty"`
+	Children []*Vdev `json:"children,omitempty"`
+}
+
+// ScanStatus is the state of the last or current scrub or resilver.
+type ScanStatus struct {
+	// Function is scrub or resilver, or empty if the zpool was never scanned.
+	Function string `json:"function,omitempty"`
+	// State is one of the Scan constants.
+	State string `json:"state"`
+	// Progress is the percentage of a scan in progress that is done.
+	Progress float64 `json:"progress,omitempty"`
+	// Description is the scan line of zpool status, if available.
+	Description string `json:"description,omitempty"`
+}
+
+// ZpoolStatus is the detailed health of a zpool, as shown by zpool status.
+type ZpoolStatus struct {
+	Name   string     `json:"name"`
+	State  string     `json:"state"`
+	Status string     `json:"status,omitempty"`
+	Action string     `json:"action,omitempty"`
+	Scan   ScanStatus `json:"scan"`
+	Config []*Vdev    `json:"config"`
+	Errors string     `json:"errors,omitempty"`
+}
+
+// Status returns the detailed health of the receiving zpool.
+func (z *Zpool) Status() (*ZpoolStatus, error) {
+	return z.StatusContext(context.Background())
+}
+
+// StatusContext is like Status, but kills zpool status when ctx is done.
+func (z *Zpool) StatusContext(ctx context.Context) (*ZpoolStatus, error) {
+	if JSONOutputSupported() {
+		out, err := runJSON(ctx, "zpool", "status", "-j", "-p", z.Name)
+		if err != nil {
+			return nil, err
+		}
+		return parseJSONZpoolStatus(out, z.Name)
+	}
+
+	var out bytes.Buffer
+	c := command{Command: "zpool", Stdout: &out, Context: ctx}
+	if _, err := c.Run("status", "-p", z.Name); err != nil {
+		// zpool status -p is not available on older versions
+		out.Reset()
+		c = command{Command: "zpool", Stdout: &out, Context: ctx}
+		if _, err := c.Run("status", z.Name); err != nil {
+			return nil, err
+		}
+	}
+	return parseZpoolStatus(out.String())
+}
+
+var (
+	statusKeyRegex  = regexp.MustCompile(`^\s*([a-z]+): ?(.*)$`)
+	scanDoneRegex   = regexp.MustCompile(`([\d.]+)% done`)
+	vdevIndentRegex = regexp.MustCompile(`^\t( *)(\S.*)$`)
+)
+
+// parseZpoolStatus parses the output of zpool status for a single zpool.
+func parseZpoolStatus(out string) (*ZpoolStatus, error) {
+	s := &ZpoolStatus{}
+	var scan []string
+
+	key := ""
+	var stack []*Vdev
+	for _, line := range strings.Split(out, "\n") {
+		if m := statusKeyRegex.FindStringSubmatch(line); m != nil && !strings.HasPrefix(line, "\t") {
+			key = m[1]
+			val := strings.TrimSpace(m[2])
+			switch key {
+			case "pool":
+				s.Name = val
+			case "state":
+				s.State = val
+			case "status":
+				s.Status = val
+			case "action":
+				s.Action = val
+			case "scan":
+				scan = append(scan, val)
+			case "errors":
+				s.Errors = val
+			}
+			continue
+		}
+
+		switch key {
+		case "status", "action":
+			// continuation lines are indented with a tab
+			if val := strings.TrimSpace(line); val != "" {
+				field := &s.Status
+				if key == "action" {
+					field = &s.Action
+				}
+				*field += " " + val
+			}
+		case "scan":
+			if val := strings.TrimSpace(line); val != "" {
+				scan = append(scan, val)
+			}
+		case "config":
+			m := vdevIndentRegex.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			fields := strings.Fields(m[2])
+			if fields[0] == "NAME" {
+				continue
+			}
+			vdev, err := parseVdevLine(fields)
+			if err != nil {
+				return nil, err
+			}
+			depth := len(m[1]) / 2
+			if depth > len(stack) {
+				return nil, fmt.Errorf("unexpected indentation of vdev %s", vdev.Name)
+			}
+			stack = append(stack[:depth], vdev)
+			if depth == 0 {
+				s.Config = append(s.Config, vdev)
+			} else {
+				parent := stack[depth-1]
+				parent.Children = append(parent.Children, vdev)
+			}
+		}
+	}
+
+	if s.Name == "" {
+		return nil, fmt.Errorf("unexpected zpool status output")
+	}
+	s.Scan = parseScanStatus(scan)
+	return s, nil
+}
+
+// parseVdevLine parses the name, state, error counts and message of a vdev.
+// Group lines such as logs only have a name, spares have no error counts.
+func parseVdevLine(fields []string) (*Vdev, error) {
+	v := &Vdev{Name: fields[0]}
+	if len(fields) > 1 {
+		v.State = fields[1]
+	}
+	if len(fields) < 5 {
+		return v, nil
+	}
+	for i, field := range []*uint64{&v.ReadErrors, &v.WriteErrors, &v.ChecksumErrors} {
+		n, err := parseErrorCount(fields[2+i])
+		if err != nil {
+			return nil, err
+		}
+		*field = n
+	}
+	v.Message = strings.Join(fields[5:], " ")
+	return v, nil
+}
+
+// parseErrorCount parses an error count, which is abbreviated, e.g. 1.2K,
+// if zpool status was run without -p.
+func parseErrorCount(val string) (uint64, error) {
+	if n, err := strconv.ParseUint(val, 10, 64); err == nil {
+		return n, nil
+	}
+	b, err := ParseBytes(val)
+	return uint64(b), err
+}
+
+// parseScanStatus parses the scan lines of zpool status.
+func parseScanStatus(lines []string) ScanStatus {
+	if len(lines) == 0 {
+		return ScanStatus{State: ScanNone}
+	}
+	scan := ScanStatus{Description: strings.Join(lines, " ")}
+	first := lines[0]
+	switch {
+	case strings.HasPrefix(first, "scrub"):
+		scan.Function = "scrub"
+	case strings.HasPrefix(first, "resilver"):
+		scan.Function = "resilver"
+	}
+
+	switch {
+	case scan.Function == "":
+		scan.State = ScanNone
+	case strings.Contains(first, "in progress"):
+		scan.State = ScanScanning
+		if m := scanDoneRegex.FindStringSubmatch(scan.Description); m != nil {
+			scan.Progress, _ = strconv.ParseFloat(m[1], 64)
+		}
+	case strings.Contains(first, "canceled"):
+		scan.State = ScanCanceled
+	default:
+		scan.State = ScanFinished
+	}
+	return scan
+}
+
+type jsonVdev struct {
+	Name           string          `json:"name"`
+	State          string          `json:"state"`
+	ReadErrors     jsonValue       `json:"read_errors"`
+	WriteErrors    jsonValue       `json:"write_errors"`
+	ChecksumErrors jsonValue       `json:"checksum_errors"`
+	Vdevs          json.RawMessage `json:"vdevs"`
+}
+
+type jsonScanStats struct {
+	Function  string    `json:"function"`
+	State     string    `json:"state"`
+	ToExamine jsonValue `json:"to_examine"`
+	Issued    jsonValue `json:"issued"`
+}
+
+type jsonZpoolStatus struct {
+	Name      string          `json:"name"`
+	State     string          `json:"state"`
+	Status    string          `json:"status"`
+	Action    string          `json:"action"`
+	ScanStats *jsonScanStats  `json:"scan_stats"`
+	Vdevs     json.RawMessage `json:"vdevs"`
+	Logs      json.RawMessage `json:"logs"`
+	L2Cache   json.RawMessage `json:"l2cache"`
+	Spares    json.RawMessage `json:"spares"`
+	ErrCount  jsonValue       `json:"error_count"`
+}
+
+// parseJSONZpoolStatus parses zpool status -j output for the named zpool.
+func parseJSONZpoolStatus(data []byte, name string) (*ZpoolStatus, error) {
+	var out struct {
+		Pools map[string]*jsonZpoolStatus `json:"pools"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	js, ok := out.Pools[name]
+	if !ok {
+		return nil, fmt.Errorf("zpool %s missing in zpool status output", name)
+	}
+
+	s := &ZpoolStatus{
+		Name:   name,
+		State:  js.State,
+		Status: js.Status,
+		Action: js.Action,
+		Scan:   ScanStatus{State: ScanNone},
+	}
+	if st := js.ScanStats; st != nil && st.Function != "" && st.Function != "NONE" {
+		s.Scan.Function = strings.ToLower(st.Function)
+		s.Scan.State = strings.ToLower(st.State)
+		if s.Scan.State == ScanScanning {
+			toExamine, _ := strconv.ParseFloat(string(st.ToExamine), 64)
+			issued, _ := strconv.ParseFloat(string(st.Issued), 64)
+			if toExamine > 0 {
+				s.Scan.Progress = issued * 100 / toExamine
+			}
+		}
+	}
+	switch js.ErrCount {
+	case "":
+	case "0":
+		s.Errors = "No known data errors"
+	default:
+		s.Errors = string(js.ErrCount) + " data errors"
+	}
+
+	var err error
+	if s.Config, err = decodeJSONVdevs(js.Vdevs); err != nil {
+		return nil, err
+	}
+	for _, group := range []struct {
+		name string
+		data json.RawMessage
+	}{{"logs", js.Logs}, {"cache", js.L2Cache}, {"spares", js.Spares}} {
+		children, err := decodeJSONVdevs(group.data)
+		if err != nil {
+			return nil, err
+		}
+		if len(children) > 0 {
+			s.Config = append(s.Config, &Vdev{Name: group.name, Children: children})
+		}
+	}
+	return s, nil
+}
+
+// decodeJSONVdevs decodes a JSON object of named vdevs, keeping the order in
+// which zpool printed them.
+func decodeJSONVdevs(data json.RawMessage) ([]*Vdev, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('{') {
+		return nil, fmt.Errorf("unexpected JSON token %v", t)
+	}
+
+	var vdevs []*Vdev
+	for dec.More() {
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		jv := jsonVdev{}
+		if err := dec.Decode(&jv); err != nil {
+			return nil, err
+		}
+
+		v := &Vdev{Name: jv.Name, State: jv.State}
+		for _, field := range []struct {
+			dst *uint64
+			val jsonValue
+		}{{&v.ReadErrors, jv.ReadErrors}, {&v.WriteErrors, jv.WriteErrors}, {&v.ChecksumErrors, jv.ChecksumErrors}} {
+			if field.val == "" {
+				continue
+			}
+			n, err := parseErrorCount(string(field.val))
+			if err != nil {
+				return nil, err
+			}
+			*field.dst = n
+		}
+		children, err := decodeJSONVdevs(jv.Vdevs)
+		if err != nil {
+			return nil, err
+		}
+		v.Children = children
+		vdevs = append(vdevs, v)
+	}
+	return vdevs, nil
+}
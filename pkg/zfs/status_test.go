@@ -0,0 +1,125 @@
+package zfs
+
+import (
+	"testing"
+)
+
+const zpoolStatusOutput = `  pool: tank
+ state: DEGRADED
+status: One or more devices could not be used because the label is missing or
+	invalid.  Sufficient replicas exist for the pool to continue
+	functioning in a degraded state.
+action: Replace the device using 'zpool replace'.
+   see: https://openzfs.github.io/openzfs-docs/msg/ZFS-8000-4J
+  scan: scrub in progress since Sun Oct 18 10:00:00 2026
+	1.23G scanned at 100M/s, 512M issued at 50M/s, 10G total
+	0B repaired, 5.00% done, 00:03:00 to go
+config:
+
+	NAME        STATE     READ WRITE CKSUM
+	tank        DEGRADED     0     0     0
+	  mirror-0  DEGRADED     0     0     0
+	    ada0    ONLINE       0     0     0
+	    ada1    UNAVAIL      3  1200     0  corrupted data
+	logs
+	  ada2      ONLINE       0     0     0
+	spares
+	  ada3      AVAIL
+
+errors: No known data errors
+`
+
+func TestParseZpoolStatus(t *testing.T) {
+	s, err := parseZpoolStatus(zpoolStatusOutput)
+	ok(t, err)
+	equals(t, "tank", s.Name)
+	equals(t, ZpoolDegraded, s.State)
+	equals(t, "One or more devices could not be used because the label is missing or invalid.  Sufficient replicas exist for the pool to continue functioning in a degraded state.", s.Status)
+	equals(t, "Replace the device using 'zpool replace'.", s.Action)
+	equals(t, "No known data errors", s.Errors)
+
+	equals(t, "scrub", s.Scan.Function)
+	equals(t, ScanScanning, s.Scan.State)
+	equals(t, 5.0, s.Scan.Progress)
+
+	equals(t, 3, len(s.Config))
+	root := s.Config[0]
+	equals(t, "tank", root.Name)
+	equals(t, 1, len(root.Children))
+	mirror := root.Children[0]
+	equals(t, "mirror-0", mirror.Name)
+	equals(t, 2, len(mirror.Children))
+	equals(t, &Vdev{Name: "ada1", State: ZpoolUnavail, ReadErrors: 3, WriteErrors: 1200, Message: "corrupted data"}, mirror.Children[1])
+
+	equals(t, "logs", s.Config[1].Name)
+	equals(t, "", s.Config[1].State)
+	equals(t, "ada2", s.Config[1].Children[0].Name)
+	equals(t, &Vdev{Name: "ada3", State: "AVAIL"}, s.Config[2].Children[0])
+}
+
+func TestParseScanStatus(t *testing.T) {
+	var tests = []struct {
+		lines    []string
+		function string
+		state    string
+	}{
+		{nil, "", ScanNone},
+		{[]string{"none requested"}, "", ScanNone},
+		{[]string{"scrub repaired 0B in 00:00:01 with 0 errors on Sun Oct 18 00:00:01 2026"}, "scrub", ScanFinished},
+		{[]string{"resilvered 1.5G in 00:01:00 with 0 errors on Sun Oct 18 00:00:01 2026"}, "resilver", ScanFinished},
+		{[]string{"scrub canceled on Sun Oct 18 00:00:01 2026"}, "scrub", ScanCanceled},
+	}
+
+	for _, test := range tests {
+		scan := parseScanStatus(test.lines)
+		equals(t, test.function, scan.Function)
+		equals(t, test.state, scan.State)
+	}
+}
+
+func TestParseJSONZpoolStatus(t *testing.T) {
+	out := []byte(`{
+  "output_version": {"command": "zpool status", "vers_major": 0, "vers_minor": 1},
+  "pools": {
+    "tank": {
+      "name": "tank",
+      "state": "ONLINE",
+      "scan_stats": {"function": "SCRUB", "state": "SCANNING", "to_examine": "1000", "issued": "250"},
+      "vdevs": {
+        "tank": {
+          "name": "tank", "vdev_type": "root", "state": "ONLINE",
+          "read_errors": "0", "write_errors": "0", "checksum_errors": "0",
+          "vdevs": {
+            "mirror-0": {
+              "name": "mirror-0", "vdev_type": "mirror", "state": "ONLINE",
+              "read_errors": "0", "write_errors": "0", "checksum_errors": "0",
+              "vdevs": {
+                "ada1": {"name": "ada1", "vdev_type": "disk", "state": "ONLINE", "read_errors": "0", "write_errors": "0", "checksum_errors": "2"},
+                "ada0": {"name": "ada0", "vdev_type": "disk", "state": "ONLINE", "read_errors": "0", "write_errors": "0", "checksum_errors": "0"}
+              }
+            }
+          }
+        }
+      },
+      "l2cache": {
+        "ada2": {"name": "ada2", "vdev_type": "disk", "state": "ONLINE", "read_errors": "0", "write_errors": "0", "checksum_errors": "0"}
+      },
+      "error_count": "0"
+    }
+  }
+}`)
+
+	s, err := parseJSONZpoolStatus(out, "tank")
+	ok(t, err)
+	equals(t, ZpoolOnline, s.State)
+	equals(t, ScanStatus{Function: "scrub", State: ScanScanning, Progress: 25}, s.Scan)
+	equals(t, 2, len(s.Config))
+	mirror := s.Config[0].Children[0]
+	equals(t, "ada1", mirror.Children[0].Name)
+	equals(t, uint64(2), mirror.Children[0].ChecksumErrors)
+	equals(t, "cache", s.Config[1].Name)
+	equals(t, "ada2", s.Config[1].Children[0].Name)
+
+	_, err = parseJSONZpoolStatus(out, "backup")
+	nok(t, err)
+}
@@ -1,15 +1,17 @@
 package zfs
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os/exec"
 	"regexp"
-	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -18,11 +20,18 @@ type command struct {
 	Command string
 	Stdin   io.Reader
 	Stdout  io.Writer
+	// Context kills the command when it is done, if set
+	Context context.Context
 }
 
 func (c *command) Run(arg ...string) ([][]string, error) {
 
-	cmd := exec.Command(c.Command, arg...)
+	var cmd *exec.Cmd
+	if c.Context != nil {
+		cmd = exec.CommandContext(c.Context, c.Command, arg...)
+	} else {
+		cmd = exec.Command(c.Command, arg...)
+	}
 
 	var stdout, stderr bytes.Buffer
 
@@ -38,12 +47,30 @@ func (c *command) Run(arg ...string) ([][]string, error) {
 	}
 	cmd.Stderr = &stderr
 
-	id := uuid.New().String()
+	ctx := c.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// commands run for a request are logged with its id
+	id := RequestID(ctx)
+	if id == "" {
+		id = uuid.New().String()
+	}
 	joinedArgs := strings.Join(cmd.Args, " ")
 
 	logger.Log([]string{"ID:" + id, "START", joinedArgs})
+	start := time.Now()
 	err := cmd.Run()
 	logger.Log([]string{"ID:" + id, "FINISH"})
+	if commandLogger != nil {
+		commandLogger.LogCommand(ctx, &CommandResult{
+			Args:       cmd.Args,
+			Start:      start,
+			Duration:   time.Since(start),
+			ExitStatus: exitStatus(err),
+			Stderr:     stderr.String(),
+		})
+	}
 
 	if err != nil {
 		return nil, &Error{
@@ -64,13 +91,26 @@ func (c *command) Run(arg ...string) ([][]string, error) {
 	lines = lines[0 : len(lines)-1]
 	output := make([][]string, len(lines))
 
+	// -H output is tab delimited, fields may contain spaces
 	for i, l := range lines {
-		output[i] = strings.Fields(l)
+		output[i] = strings.Split(l, "\t")
 	}
 
 	return output, nil
 }
 
+// exitStatus returns the exit status of a command that returned err.
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
 func setString(field *string, value string) {
 	v := ""
 	if value != "-" {
@@ -92,51 +132,89 @@ func setUint(field *uint64, value string) error {
 	return nil
 }
 
-func (ds *Dataset) parseLine(line []string) error {
-	var err error
-
-	if len(line) != len(dsPropList) {
-		return errors.New("Output does not match what is expected on this platform")
+// setTime parses a time in seconds since the epoch, as printed with -p.
+func setTime(field *time.Time, value string) error {
+	var v time.Time
+	if value != "-" {
+		sec, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v = time.Unix(sec, 0)
 	}
-	setString(&ds.Name, line[0])
-	setString(&ds.Origin, line[1])
+	*field = v
+	return nil
+}
 
-	if err = setUint(&ds.Used, line[2]); err != nil {
-		return err
-	}
-	if err = setUint(&ds.Avail, line[3]); err != nil {
+func setBytes(field *Bytes, value string) error {
+	v, err := ParseBytes(value)
+	if err != nil {
 		return err
 	}
+	*field = v
+	return nil
+}
 
-	setString(&ds.Mountpoint, line[4])
-	setString(&ds.Compression, line[5])
-	setString(&ds.Type, line[6])
-
-	if err = setUint(&ds.Volsize, line[7]); err != nil {
-		return err
-	}
-	if err = setUint(&ds.Quota, line[8]); err != nil {
-		return err
+func (ds *Dataset) parseLine(line []string) error {
+	if len(line) != len(dsPropList) {
+		return errors.New("Output does not match what is expected on this platform")
 	}
-	if err = setUint(&ds.Referenced, line[9]); err != nil {
-		return err
+	for i, prop := range dsPropList {
+		if err := ds.parseProperty(prop, line[i]); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	if runtime.GOOS == "solaris" {
-		return nil
-	}
+func (ds *Dataset) parseProperty(prop, val string) error {
+	var err error
 
-	if err = setUint(&ds.Written, line[10]); err != nil {
-		return err
-	}
-	if err = setUint(&ds.Logicalused, line[11]); err != nil {
-		return err
-	}
-	if err = setUint(&ds.Usedbydataset, line[12]); err != nil {
-		return err
+	switch prop {
+	case "name":
+		setString(&ds.Name, val)
+	case "origin":
+		setString(&ds.Origin, val)
+	case "used":
+		err = setBytes(&ds.Used, val)
+	case "available":
+		err = setBytes(&ds.Avail, val)
+	case "mountpoint":
+		setString(&ds.Mountpoint, val)
+	case "compression":
+		setString(&ds.Compression, val)
+	case "type":
+		setString(&ds.Type, val)
+	case "volsize":
+		err = setBytes(&ds.Volsize, val)
+	case "volblocksize":
+		err = setBytes(&ds.Volblocksize, val)
+	case "quota":
+		err = setBytes(&ds.Quota, val)
+	case "refreservation":
+		err = setBytes(&ds.Refreservation, val)
+	case "referenced":
+		err = setBytes(&ds.Referenced, val)
+	case "written":
+		err = setBytes(&ds.Written, val)
+	case "logicalused":
+		err = setBytes(&ds.Logicalused, val)
+	case "usedbydataset":
+		err = setBytes(&ds.Usedbydataset, val)
+	case "creation":
+		err = setTime(&ds.Creation, val)
+	case "createtxg":
+		err = setUint(&ds.Createtxg, val)
+	case "guid":
+		err = setUint(&ds.GUID, val)
+	case "refquota":
+		err = setBytes(&ds.Refquota, val)
+	case "canmount":
+		setString(&ds.Canmount, val)
+	case "mounted":
+		ds.Mounted = val == "yes"
 	}
-
-	return nil
+	return err
 }
 
 /*
@@ -266,31 +344,70 @@ func parseInodeChange(line []string) (*InodeChange, error) {
 	}, nil
 }
 
+// parseDiffTimestamp parses the seconds.nanoseconds change time printed by
+// zfs diff -t.
+func parseDiffTimestamp(field string) (time.Time, error) {
+	parts := strings.SplitN(strings.TrimSpace(field), ".", 2)
+	sec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var nsec int64
+	if len(parts) == 2 {
+		nsec, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Unix(sec, nsec), nil
+}
+
+// scanInodeChanges reads zfs diff -FH output from r one line at a time and
+// passes every parsed change to fn.  If timestamps is set, the lines are
+// expected to be prefixed with the change time, as printed by zfs diff -t.
+//
 // example input
-//M       /       /testpool/bar/
-//+       F       /testpool/bar/hello.txt
-//M       /       /testpool/bar/hello.txt (+1)
-//M       /       /testpool/bar/hello-hardlink
-func parseInodeChanges(lines [][]string) ([]*InodeChange, error) {
-	changes := make([]*InodeChange, len(lines))
-
-	for i, line := range lines {
+// M       /       /testpool/bar/
+// +       F       /testpool/bar/hello.txt
+// M       /       /testpool/bar/hello.txt (+1)
+// M       /       /testpool/bar/hello-hardlink
+func scanInodeChanges(r io.Reader, timestamps bool, fn func(*InodeChange) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for i := 0; scanner.Scan(); i++ {
+		line := strings.Split(scanner.Text(), "\t")
+
+		var changeTime time.Time
+		if timestamps {
+			var err error
+			changeTime, err = parseDiffTimestamp(line[0])
+			if err != nil {
+				return fmt.Errorf("Failed to parse line %d of zfs diff: %v, got: '%s'", i, err, line)
+			}
+			line = line[1:]
+		}
+
 		c, err := parseInodeChange(line)
 		if err != nil {
-			return nil, fmt.Errorf("Failed to parse line %d of zfs diff: %v, got: '%s'", i, err, line)
+			return fmt.Errorf("Failed to parse line %d of zfs diff: %v, got: '%s'", i, err, line)
+		}
+		c.ChangeTime = changeTime
+
+		if err := fn(c); err != nil {
+			return err
 		}
-		changes[i] = c
 	}
-	return changes, nil
+	return scanner.Err()
 }
 
-func listByType(t, filter string) ([]*Dataset, error) {
-	args := []string{"list", "-rHp", "-t", t, "-o", dsPropListOptions}
+func listByType(ctx context.Context, t, filter string) ([]*Dataset, error) {
+	args := []string{"-r", "-t", t}
 
 	if filter != "" {
 		args = append(args, filter)
 	}
-	out, err := zfs(args...)
+	out, err := zfsList(ctx, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -334,11 +451,11 @@ func (z *Zpool) parseLine(line []string) error {
 	case "health":
 		setString(&z.Health, val)
 	case "allocated":
-		err = setUint(&z.Allocated, val)
+		err = setBytes(&z.Allocated, val)
 	case "size":
-		err = setUint(&z.Size, val)
+		err = setBytes(&z.Size, val)
 	case "free":
-		err = setUint(&z.Free, val)
+		err = setBytes(&z.Free, val)
 	case "fragmentation":
 		// Trim trailing "%" before parsing uint
 		i := strings.Index(val, "%")
@@ -349,12 +466,12 @@ func (z *Zpool) parseLine(line []string) error {
 	case "readonly":
 		z.ReadOnly = val == "on"
 	case "freeing":
-		err = setUint(&z.Freeing, val)
+		err = setBytes(&z.Freeing, val)
 	case "leaked":
-		err = setUint(&z.Leaked, val)
+		err = setBytes(&z.Leaked, val)
 	case "dedupratio":
-		// Trim trailing "x" before parsing float64
-		z.DedupRatio, err = strconv.ParseFloat(val[:len(val)-1], 64)
+		// Trim trailing "x" before parsing float64, -p omits it
+		z.DedupRatio, err = strconv.ParseFloat(strings.TrimSuffix(val, "x"), 64)
 	}
 	return err
 }
@@ -7,11 +7,10 @@ import (
 )
 
 // List of ZFS properties to retrieve from zfs list command on a Solaris platform
-var dsPropList = []string{"name", "origin", "used", "available", "mountpoint", "compression", "type", "volsize", "quota", "referenced"}
+var dsPropList = []string{"name", "origin", "used", "available", "mountpoint", "compression", "type", "volsize", "quota", "referenced", "volblocksize", "refreservation", "creation", "createtxg", "guid", "refquota", "canmount", "mounted"}
 
 var dsPropListOptions = strings.Join(dsPropList, ",")
 
 // List of Zpool properties to retrieve from zpool list command on a non-Solaris platform
 var zpoolPropList = []string{"name", "health", "allocated", "size", "free", "readonly", "dedupratio"}
 var zpoolPropListOptions = strings.Join(zpoolPropList, ",")
-var zpoolArgs = []string{"get", "-p", zpoolPropListOptions}
@@ -0,0 +1,142 @@
+package zfs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScanInodeChanges(t *testing.T) {
+	input := strings.Join([]string{
+		"1616161616.000000001\tM\t/\t/testpool/bar/",
+		"1616161617.500000000\t+\tF\t/testpool/bar/hello\\040world.txt",
+		"1616161618.000000000\tM\tF\t/testpool/bar/hello.txt\t(+1)",
+		"1616161619.000000000\tR\tF\t/testpool/bar/a\t/testpool/bar/b",
+	}, "\n") + "\n"
+
+	var changes []*InodeChange
+	err := scanInodeChanges(strings.NewReader(input), true, func(c *InodeChange) error {
+		changes = append(changes, c)
+		return nil
+	})
+	ok(t, err)
+	equals(t, 4, len(changes))
+
+	equals(t, time.Unix(1616161616, 1), changes[0].ChangeTime)
+	equals(t, Directory, changes[0].Type)
+	equals(t, "/testpool/bar/hello world.txt", changes[1].Path)
+	equals(t, time.Unix(1616161617, 500000000), changes[1].ChangeTime)
+	equals(t, 1, changes[2].ReferenceCountChange)
+	equals(t, Renamed, changes[3].Change)
+	equals(t, "/testpool/bar/b", changes[3].NewPath)
+}
+
+func TestScanInodeChangesStops(t *testing.T) {
+	input := "+\tF\t/a\n+\tF\t/b\n+\tF\t/c\n"
+	stop := errors.New("stop")
+
+	n := 0
+	err := scanInodeChanges(strings.NewReader(input), false, func(c *InodeChange) error {
+		n++
+		if n == 2 {
+			return stop
+		}
+		return nil
+	})
+	equals(t, stop, err)
+	equals(t, 2, n)
+
+	err = scanInodeChanges(strings.NewReader("?\tF\t/a\n"), false, func(c *InodeChange) error {
+		return nil
+	})
+	nok(t, err)
+}
+
+func TestTopDirectoryChanges(t *testing.T) {
+	dirs := map[string]int{
+		"/a":   3,
+		"/b":   7,
+		"/c":   3,
+		"/a/d": 1,
+	}
+
+	top := topDirectoryChanges(dirs, 3)
+	equals(t, []DirectoryChanges{
+		{Path: "/b", Changes: 7},
+		{Path: "/a", Changes: 3},
+		{Path: "/c", Changes: 3},
+	}, top)
+
+	equals(t, 4, len(topDirectoryChanges(dirs, -1)))
+}
+
+func TestCommandRunSplitsTabs(t *testing.T) {
+	c := command{Command: "printf"}
+	out, err := c.Run("tank/my fs\t/mnt/my fs\t-\n")
+	ok(t, err)
+	equals(t, [][]string{{"tank/my fs", "/mnt/my fs", "-"}}, out)
+}
+
+func TestCommandRunContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	c := command{Command: "sleep", Context: ctx}
+	_, err := c.Run("10")
+	nok(t, err)
+	assert(t, time.Since(start) < 5*time.Second, "command was not killed when its context was done")
+}
+
+type recordingLogger struct {
+	lines [][]string
+	ids   []string
+}
+
+func (l *recordingLogger) Log(cmd []string) {
+	l.lines = append(l.lines, cmd)
+}
+
+func (l *recordingLogger) LogCommand(ctx context.Context, r *CommandResult) {
+	l.ids = append(l.ids, RequestID(ctx))
+}
+
+func TestCommandRunRequestID(t *testing.T) {
+	l := &recordingLogger{}
+	prev := logger
+	SetLogger(l)
+	SetCommandLogger(l)
+	defer func() {
+		SetLogger(prev)
+		SetCommandLogger(nil)
+	}()
+
+	c := command{Command: "true", Context: WithRequestID(context.Background(), "req-1")}
+	_, err := c.Run()
+	ok(t, err)
+	equals(t, [][]string{{"ID:req-1", "START", "true"}, {"ID:req-1", "FINISH"}}, l.lines)
+	equals(t, []string{"req-1"}, l.ids)
+
+	// commands without a request are logged with an id of their own
+	c = command{Command: "true"}
+	_, err = c.Run()
+	ok(t, err)
+	assert(t, l.lines[2][0] != "ID:" && l.lines[2][0] == l.lines[3][0], "unexpected log lines %v", l.lines)
+	equals(t, []string{"req-1", ""}, l.ids)
+}
+
+func TestParseJailedDatasets(t *testing.T) {
+	out := [][]string{
+		{"tank", "off", "-"},
+		{"tank/jails/www", "on", "www"},
+		{"tank/jails/www/data", "on", "www"},
+		{"tank/jails/db", "on", "-"},
+	}
+
+	equals(t, []*JailedDataset{
+		{Name: "tank/jails/www", Jail: "www"},
+		{Name: "tank/jails/www/data", Jail: "www"},
+		{Name: "tank/jails/db", Jail: ""},
+	}, parseJailedDatasets(out))
+}
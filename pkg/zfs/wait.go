@@ -0,0 +1,147 @@
+package zfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// WaitActivity is a background activity that WaitFor can wait for.
+type WaitActivity string
+
+// Activities that can be waited for.  All of them are zpool activities,
+// except for WaitDeleteQueue, which waits for the delete queue of a
+// filesystem to be processed.
+const (
+	WaitDiscard     WaitActivity = "discard"
+	WaitFree        WaitActivity = "free"
+	WaitInitialize  WaitActivity = "initialize"
+	WaitReplace     WaitActivity = "replace"
+	WaitRemove      WaitActivity = "remove"
+	WaitResilver    WaitActivity = "resilver"
+	WaitScrub       WaitActivity = "scrub"
+	WaitTrim        WaitActivity = "trim"
+	WaitDeleteQueue WaitActivity = "deleteq"
+)
+
+// WaitPollInterval is how often WaitFor checks the status of a zpool on ZFS
+// versions without zpool wait.
+var WaitPollInterval = 5 * time.Second
+
+// waitSupported reports whether zpool wait and zfs wait are available,
+// which they are since OpenZFS 2.0.
+func waitSupported() bool {
+	major, _, ok := zfsVersion()
+	return ok && major >= 2
+}
+
+// WaitFor blocks until the activity is no longer in progress, or until ctx
+// is done.  name is a zpool, or a filesystem for WaitDeleteQueue.
+// zpool wait and zfs wait are used where available, otherwise the status of
+// the zpool is polled every WaitPollInterval.
+func WaitFor(ctx context.Context, name string, activity WaitActivity) error {
+	if waitSupported() {
+		cmd := "zpool"
+		if activity == WaitDeleteQueue {
+			cmd = "zfs"
+		}
+		c := command{Command: cmd, Context: ctx}
+		_, err := c.Run("wait", "-t", string(activity), name)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	if activity == WaitDeleteQueue {
+		return errors.New("waiting for the delete queue requires zfs wait")
+	}
+
+	for {
+		busy, err := activityInProgress(ctx, name, activity)
+		if err != nil {
+			return err
+		}
+		if !busy {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(WaitPollInterval):
+		}
+	}
+}
+
+// activityInProgress checks the zpool status and properties for the activity.
+func activityInProgress(ctx context.Context, pool string, activity WaitActivity) (bool, error) {
+	if activity == WaitFree {
+		var out bytes.Buffer
+		c := command{Command: "zpool", Stdout: &out, Context: ctx}
+		if _, err := c.Run("get", "-Hp", "-o", "value", "freeing", pool); err != nil {
+			return false, err
+		}
+		freeing := strings.TrimSpace(out.String())
+		return freeing != "0" && freeing != "-", nil
+	}
+
+	var out bytes.Buffer
+	c := command{Command: "zpool", Stdout: &out, Context: ctx}
+	if _, err := c.Run("status", "-t", pool); err != nil {
+		// zpool status -t is not available on older versions
+		out.Reset()
+		c = command{Command: "zpool", Stdout: &out, Context: ctx}
+		if _, err := c.Run("status", pool); err != nil {
+			return false, err
+		}
+	}
+	return statusInProgress(out.String(), activity)
+}
+
+// statusInProgress reports whether zpool status output shows the activity.
+func statusInProgress(status string, activity WaitActivity) (bool, error) {
+	switch activity {
+	case WaitScrub, WaitResilver, WaitRemove, WaitDiscard, WaitReplace, WaitInitialize, WaitTrim:
+	default:
+		return false, fmt.Errorf("unknown activity %q", activity)
+	}
+
+	for _, line := range strings.Split(status, "\n") {
+		line = strings.TrimSpace(line)
+		switch activity {
+		case WaitScrub:
+			if strings.HasPrefix(line, "scan:") && strings.Contains(line, "scrub in progress") {
+				return true, nil
+			}
+		case WaitResilver:
+			if strings.HasPrefix(line, "scan:") && strings.Contains(line, "resilver in progress") {
+				return true, nil
+			}
+		case WaitRemove:
+			if strings.HasPrefix(line, "remove:") && strings.Contains(line, "in progress") {
+				return true, nil
+			}
+		case WaitDiscard:
+			if strings.HasPrefix(line, "checkpoint:") && strings.Contains(line, "discarding") {
+				return true, nil
+			}
+		case WaitReplace:
+			if strings.HasPrefix(line, "replacing-") {
+				return true, nil
+			}
+		case WaitInitialize:
+			if strings.Contains(line, "% initialized, started") {
+				return true, nil
+			}
+		case WaitTrim:
+			if strings.Contains(line, "% trimmed, started") {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
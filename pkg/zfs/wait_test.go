@@ -0,0 +1,47 @@
+package zfs
+
+import (
+	"testing"
+)
+
+const scrubStatus = `  pool: tank
+ state: ONLINE
+  scan: scrub in progress since Sun Oct 18 10:00:00 2026
+	1.20G scanned at 400M/s, 600M issued at 200M/s, 10.0G total
+	0B repaired, 5.86% done, 00:00:47 to go
+config:
+
+	NAME            STATE     READ WRITE CKSUM
+	tank            ONLINE       0     0     0
+	  mirror-0      ONLINE       0     0     0
+	    replacing-0 ONLINE       0     0     0
+	      ada0      ONLINE       0     0     0  (12% trimmed, started at Sun Oct 18 10:00:00 2026)
+	      ada2      ONLINE       0     0     0
+	    ada1        ONLINE       0     0     0  (100% initialized, completed at Sun Oct 18 09:00:00 2026)
+
+errors: No known data errors
+`
+
+func TestStatusInProgress(t *testing.T) {
+	var tests = []struct {
+		activity WaitActivity
+		busy     bool
+	}{
+		{WaitScrub, true},
+		{WaitResilver, false},
+		{WaitRemove, false},
+		{WaitReplace, true},
+		{WaitTrim, true},
+		{WaitInitialize, false},
+		{WaitDiscard, false},
+	}
+
+	for _, test := range tests {
+		busy, err := statusInProgress(scrubStatus, test.activity)
+		ok(t, err)
+		equals(t, test.busy, busy)
+	}
+
+	_, err := statusInProgress(scrubStatus, WaitActivity("foo"))
+	nok(t, err)
+}
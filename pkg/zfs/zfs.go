@@ -2,11 +2,13 @@
 package zfs
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // ZFS dataset types, which can indicate if a dataset is a filesystem,
@@ -23,21 +25,38 @@ const (
 // The field definitions can be found in the ZFS manual:
 // http://www.freebsd.org/cgi/man.cgi?zfs(8).
 type Dataset struct {
-	Name          string
-	Origin        string
-	Used          uint64
-	Avail         uint64
-	Mountpoint    string
-	Compression   string
-	Type          string
-	Written       uint64
-	Volsize       uint64
-	Logicalused   uint64
-	Usedbydataset uint64
-	Quota         uint64
-	Referenced    uint64
+	Name           string
+	Origin         string
+	Used           Bytes
+	Avail          Bytes
+	Mountpoint     string
+	Compression    string
+	Type           string
+	Written        Bytes
+	Volsize        Bytes
+	Logicalused    Bytes
+	Usedbydataset  Bytes
+	Quota          Bytes
+	Referenced     Bytes
+	Volblocksize   Bytes
+	Refreservation Bytes
+	Creation       time.Time
+	Createtxg      uint64
+	GUID           uint64
+	Refquota       Bytes
+	Canmount       string
+	Mounted        bool
 }
 
+// CreatedByProperty is the user property used to record who or what created
+// a dataset or snapshot.
+const CreatedByProperty = "freebsd-manager:created_by"
+
+// HostProperties are the properties of a filesystem that take effect on the
+// host rather than within the dataset: where it is mounted, how it is shared
+// and whether it is delegated to a jail.
+var HostProperties = []string{"mountpoint", "sharenfs", "sharesmb", "jailed"}
+
 // InodeType is the type of inode as reported by Diff
 type InodeType int
 
@@ -86,6 +105,8 @@ type InodeChange struct {
 	Path                 string
 	NewPath              string
 	ReferenceCountChange int
+	// ChangeTime is only set when timestamps are requested from DiffFunc.
+	ChangeTime time.Time
 }
 
 // Logger can be used to log commands/actions
@@ -109,9 +130,52 @@ func SetLogger(l Logger) {
 	}
 }
 
-// zfs is a helper function to wrap typical calls to zfs.
-func zfs(arg ...string) ([][]string, error) {
-	c := command{Command: "zfs"}
+// CommandResult is the outcome of a zfs or zpool command.
+type CommandResult struct {
+	// Args are the command and its arguments.
+	Args     []string
+	Start    time.Time
+	Duration time.Duration
+	// ExitStatus is -1 if the command could not be started or was killed.
+	ExitStatus int
+	Stderr     string
+}
+
+// CommandLogger can be used to log the outcome of commands.
+type CommandLogger interface {
+	// LogCommand is called when a command finished, with the context it was
+	// run with, which carries the request id set by WithRequestID.
+	LogCommand(ctx context.Context, r *CommandResult)
+}
+
+var commandLogger CommandLogger
+
+// SetCommandLogger sets a handler to log the outcome of all commands, nil
+// disables it.
+func SetCommandLogger(l CommandLogger) {
+	commandLogger = l
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the id of the request that the
+// commands run with it are for.  The id is passed to the Logger instead of a
+// random one, and to the CommandLogger with ctx.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request id carried by ctx, or an empty string if there
+// is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// zfs is a helper function to wrap typical calls to zfs.  The command is
+// killed when ctx is done.
+func zfs(ctx context.Context, arg ...string) ([][]string, error) {
+	c := command{Command: "zfs", Context: ctx}
 	return c.Run(arg...)
 }
 
@@ -119,34 +183,59 @@ func zfs(arg ...string) ([][]string, error) {
 // A filter argument may be passed to select a dataset with the matching name,
 // or empty string ("") may be used to select all datasets.
 func Datasets(filter string) ([]*Dataset, error) {
-	return listByType("all", filter)
+	return listByType(context.Background(), "all", filter)
 }
 
 // Snapshots returns a slice of ZFS snapshots.
 // A filter argument may be passed to select a snapshot with the matching name,
 // or empty string ("") may be used to select all snapshots.
 func Snapshots(filter string) ([]*Dataset, error) {
-	return listByType(DatasetSnapshot, filter)
+	return SnapshotsContext(context.Background(), filter)
+}
+
+// SnapshotsContext is like Snapshots, but kills zfs list when ctx is done.
+func SnapshotsContext(ctx context.Context, filter string) ([]*Dataset, error) {
+	return listByType(ctx, DatasetSnapshot, filter)
 }
 
 // Filesystems returns a slice of ZFS filesystems.
 // A filter argument may be passed to select a filesystem with the matching name,
 // or empty string ("") may be used to select all filesystems.
 func Filesystems(filter string) ([]*Dataset, error) {
-	return listByType(DatasetFilesystem, filter)
+	return FilesystemsContext(context.Background(), filter)
+}
+
+// FilesystemsContext is like Filesystems, but kills zfs list when ctx is done.
+func FilesystemsContext(ctx context.Context, filter string) ([]*Dataset, error) {
+	return listByType(ctx, DatasetFilesystem, filter)
 }
 
 // Volumes returns a slice of ZFS volumes.
 // A filter argument may be passed to select a volume with the matching name,
 // or empty string ("") may be used to select all volumes.
 func Volumes(filter string) ([]*Dataset, error) {
-	return listByType(DatasetVolume, filter)
+	return VolumesContext(context.Background(), filter)
+}
+
+// VolumesContext is like Volumes, but kills zfs list when ctx is done.
+func VolumesContext(ctx context.Context, filter string) ([]*Dataset, error) {
+	return listByType(ctx, DatasetVolume, filter)
 }
 
 // GetDataset retrieves a single ZFS dataset by name.  This dataset could be
 // any valid ZFS dataset type, such as a clone, filesystem, snapshot, or volume.
 func GetDataset(name string) (*Dataset, error) {
-	out, err := zfs("list", "-Hp", "-o", dsPropListOptions, name)
+	return GetDatasetContext(context.Background(), name)
+}
+
+// GetDatasetContext is like GetDataset, but kills zfs list when ctx is done.
+func GetDatasetContext(ctx context.Context, name string) (*Dataset, error) {
+	if ds, ok := cache.getDataset(name); ok {
+		return ds, nil
+	}
+
+	gen := cache.generation()
+	out, err := zfsList(ctx, name)
 	if err != nil {
 		return nil, err
 	}
@@ -158,12 +247,18 @@ func GetDataset(name string) (*Dataset, error) {
 		}
 	}
 
+	cache.putDataset(gen, ds)
 	return ds, nil
 }
 
 // Clone clones a ZFS snapshot and returns a clone dataset.
 // An error will be returned if the input dataset is not of snapshot type.
 func (d *Dataset) Clone(dest string, properties map[string]string) (*Dataset, error) {
+	return d.CloneContext(context.Background(), dest, properties)
+}
+
+// CloneContext is like Clone, but kills zfs clone when ctx is done.
+func (d *Dataset) CloneContext(ctx context.Context, dest string, properties map[string]string) (*Dataset, error) {
 	if d.Type != DatasetSnapshot {
 		return nil, errors.New("can only clone snapshots")
 	}
@@ -174,15 +269,25 @@ func (d *Dataset) Clone(dest string, properties map[string]string) (*Dataset, er
 		args = append(args, propsSlice(properties)...)
 	}
 	args = append(args, []string{d.Name, dest}...)
-	_, err := zfs(args...)
+	unlock, err := locks.lock(ctx, shared(d.Name), exclusive(dest))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	_, err = zfs(ctx, args...)
 	if err != nil {
 		return nil, err
 	}
-	return GetDataset(dest)
+	return GetDatasetContext(ctx, dest)
 }
 
 // Unmount unmounts currently mounted ZFS file systems.
 func (d *Dataset) Unmount(force bool) (*Dataset, error) {
+	return d.UnmountContext(context.Background(), force)
+}
+
+// UnmountContext is like Unmount, but kills zfs umount when ctx is done.
+func (d *Dataset) UnmountContext(ctx context.Context, force bool) (*Dataset, error) {
 	if d.Type == DatasetSnapshot {
 		return nil, errors.New("cannot unmount snapshots")
 	}
@@ -192,15 +297,25 @@ func (d *Dataset) Unmount(force bool) (*Dataset, error) {
 		args = append(args, "-f")
 	}
 	args = append(args, d.Name)
-	_, err := zfs(args...)
+	unlock, err := locks.lock(ctx, exclusive(d.Name))
 	if err != nil {
 		return nil, err
 	}
-	return GetDataset(d.Name)
+	defer unlock()
+	_, err = zfs(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	return GetDatasetContext(ctx, d.Name)
 }
 
 // Mount mounts ZFS file systems.
 func (d *Dataset) Mount(overlay bool, options []string) (*Dataset, error) {
+	return d.MountContext(context.Background(), overlay, options)
+}
+
+// MountContext is like Mount, but kills zfs mount when ctx is done.
+func (d *Dataset) MountContext(ctx context.Context, overlay bool, options []string) (*Dataset, error) {
 	if d.Type == DatasetSnapshot {
 		return nil, errors.New("cannot mount snapshots")
 	}
@@ -214,56 +329,125 @@ func (d *Dataset) Mount(overlay bool, options []string) (*Dataset, error) {
 		args = append(args, strings.Join(options, ","))
 	}
 	args = append(args, d.Name)
-	_, err := zfs(args...)
+	unlock, err := locks.lock(ctx, exclusive(d.Name))
 	if err != nil {
 		return nil, err
 	}
-	return GetDataset(d.Name)
+	defer unlock()
+	_, err = zfs(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	return GetDatasetContext(ctx, d.Name)
 }
 
 // ReceiveSnapshot receives a ZFS stream from the input io.Reader, creates a
 // new snapshot with the specified name, and streams the input data into the
 // newly-created snapshot.
 func ReceiveSnapshot(input io.Reader, name string) (*Dataset, error) {
-	c := command{Command: "zfs", Stdin: input}
-	_, err := c.Run("receive", name)
-	if err != nil {
-		return nil, err
-	}
-	return GetDataset(name)
+	return ReceiveSnapshotWithOptions(context.Background(), input, name, ReceiveOptions{})
 }
 
 // SendSnapshot sends a ZFS stream of a snapshot to the input io.Writer.
 // An error will be returned if the input dataset is not of snapshot type.
 func (d *Dataset) SendSnapshot(output io.Writer) error {
-	if d.Type != DatasetSnapshot {
-		return errors.New("can only send snapshots")
-	}
-
-	c := command{Command: "zfs", Stdout: output}
-	_, err := c.Run("send", d.Name)
-	return err
+	return d.SendSnapshotWithOptions(context.Background(), output, SendOptions{})
 }
 
 // CreateVolume creates a new ZFS volume with the specified name, size, and
 // properties.
 // A full list of available ZFS properties may be found here:
 // https://www.freebsd.org/cgi/man.cgi?zfs(8).
-func CreateVolume(name string, size uint64, properties map[string]string) (*Dataset, error) {
-	args := make([]string, 4, 5)
+func CreateVolume(name string, size Bytes, properties map[string]string) (*Dataset, error) {
+	return CreateVolumeWithOptions(context.Background(), name, size, VolumeOptions{}, properties)
+}
+
+// VolumeOptions are the options passed to CreateVolumeWithOptions.
+type VolumeOptions struct {
+	// Sparse creates the volume without a refreservation.
+	Sparse bool
+	// BlockSize is the volblocksize of the volume, or zero for the default.
+	BlockSize Bytes
+}
+
+// CreateVolumeWithOptions creates a new ZFS volume with the specified name,
+// size, options and properties.  If a block size is given, the size must be a
+// multiple of it.  zfs create is killed when ctx is done.
+func CreateVolumeWithOptions(ctx context.Context, name string, size Bytes, opts VolumeOptions, properties map[string]string) (*Dataset, error) {
+	if opts.BlockSize != 0 {
+		if opts.BlockSize < 512 || opts.BlockSize&(opts.BlockSize-1) != 0 {
+			return nil, fmt.Errorf("volblocksize %s is not a power of two of at least 512", opts.BlockSize)
+		}
+		if size%opts.BlockSize != 0 {
+			return nil, fmt.Errorf("volume size %s is not a multiple of volblocksize %s", size.Exact(), opts.BlockSize)
+		}
+	}
+
+	args := make([]string, 2, 8)
 	args[0] = "create"
 	args[1] = "-p"
-	args[2] = "-V"
-	args[3] = strconv.FormatUint(size, 10)
+	if opts.Sparse {
+		args = append(args, "-s")
+	}
+	if opts.BlockSize != 0 {
+		args = append(args, "-b", opts.BlockSize.Exact())
+	}
+	args = append(args, "-V", size.Exact())
 	if properties != nil {
 		args = append(args, propsSlice(properties)...)
 	}
 	args = append(args, name)
-	_, err := zfs(args...)
+	unlock, err := locks.lock(ctx, exclusive(name))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	_, err = zfs(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	return GetDatasetContext(ctx, name)
+}
+
+// Resize changes the size of a ZFS volume.  The new size must be a multiple
+// of the volblocksize of the volume, and the volume is only shrunk if force
+// is set, as shrinking discards the data at the end of the volume.
+// zfs itself adjusts the refreservation of a thick volume to the new size,
+// when it is the one computed for the old size.  Sparse volumes and custom
+// refreservations are left unchanged.
+func (d *Dataset) Resize(size Bytes, force bool) (*Dataset, error) {
+	return d.ResizeContext(context.Background(), size, force)
+}
+
+// ResizeContext is like Resize, but kills zfs set when ctx is done.
+func (d *Dataset) ResizeContext(ctx context.Context, size Bytes, force bool) (*Dataset, error) {
+	if d.Type != DatasetVolume {
+		return nil, errors.New("can only resize volumes")
+	}
+
+	unlock, err := locks.lock(ctx, exclusive(d.Name))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	cur, err := GetDatasetContext(ctx, d.Name)
 	if err != nil {
 		return nil, err
 	}
-	return GetDataset(name)
+	if cur.Volblocksize != 0 && size%cur.Volblocksize != 0 {
+		return nil, fmt.Errorf("volume size %s is not a multiple of volblocksize %s", size.Exact(), cur.Volblocksize)
+	}
+	if size < cur.Volsize && !force {
+		return nil, fmt.Errorf("refusing to shrink volume %s from %s to %s", d.Name, cur.Volsize, size)
+	}
+	if size == cur.Volsize {
+		return cur, nil
+	}
+
+	if err := cur.setProperty(ctx, "volsize", size.Exact()); err != nil {
+		return nil, err
+	}
+	return GetDatasetContext(ctx, d.Name)
 }
 
 // Destroy destroys a ZFS dataset. If the destroy bit flag is set, any
@@ -271,6 +455,12 @@ func CreateVolume(name string, size uint64, properties map[string]string) (*Data
 // If the deferred bit flag is set, the snapshot is marked for deferred
 // deletion.
 func (d *Dataset) Destroy(flags DestroyFlag) error {
+	return d.DestroyContext(context.Background(), flags)
+}
+
+// DestroyContext is like Destroy, but kills zfs destroy when ctx is done.
+// Datasets destroyed until then stay destroyed.
+func (d *Dataset) DestroyContext(ctx context.Context, flags DestroyFlag) error {
 	args := make([]string, 1, 3)
 	args[0] = "destroy"
 	if flags&DestroyRecursive != 0 {
@@ -290,7 +480,12 @@ func (d *Dataset) Destroy(flags DestroyFlag) error {
 	}
 
 	args = append(args, d.Name)
-	_, err := zfs(args...)
+	unlock, err := locks.lock(ctx, exclusive(d.Name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	_, err = zfs(ctx, args...)
 	return err
 }
 
@@ -298,8 +493,44 @@ func (d *Dataset) Destroy(flags DestroyFlag) error {
 // A full list of available ZFS properties may be found here:
 // https://www.freebsd.org/cgi/man.cgi?zfs(8).
 func (d *Dataset) SetProperty(key, val string) error {
+	return d.SetPropertyContext(context.Background(), key, val)
+}
+
+// SetPropertyContext is like SetProperty, but kills zfs set when ctx is done.
+func (d *Dataset) SetPropertyContext(ctx context.Context, key, val string) error {
+	unlock, err := locks.lock(ctx, exclusive(d.Name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return d.setProperty(ctx, key, val)
+}
+
+func (d *Dataset) setProperty(ctx context.Context, key, val string) error {
 	prop := strings.Join([]string{key, val}, "=")
-	_, err := zfs("set", prop, d.Name)
+	_, err := zfs(ctx, "set", prop, d.Name)
+	return err
+}
+
+// InheritProperty clears a ZFS property on the receiving dataset, so that it
+// is inherited from its parent again.
+func (d *Dataset) InheritProperty(key string) error {
+	return d.InheritPropertyContext(context.Background(), key)
+}
+
+// InheritPropertyContext is like InheritProperty, but kills zfs inherit when
+// ctx is done.
+func (d *Dataset) InheritPropertyContext(ctx context.Context, key string) error {
+	unlock, err := locks.lock(ctx, exclusive(d.Name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return d.inheritProperty(ctx, key)
+}
+
+func (d *Dataset) inheritProperty(ctx context.Context, key string) error {
+	_, err := zfs(ctx, "inherit", key, d.Name)
 	return err
 }
 
@@ -308,16 +539,26 @@ func (d *Dataset) SetProperty(key, val string) error {
 // A full list of available ZFS properties may be found here:
 // https://www.freebsd.org/cgi/man.cgi?zfs(8).
 func (d *Dataset) GetProperty(key string) (string, error) {
-	out, err := zfs("get", "-H", key, d.Name)
+	return d.GetPropertyContext(context.Background(), key)
+}
+
+// GetPropertyContext is like GetProperty, but kills zfs get when ctx is done.
+func (d *Dataset) GetPropertyContext(ctx context.Context, key string) (string, error) {
+	values, err := zfsGet(ctx, d.Name, key)
 	if err != nil {
 		return "", err
 	}
 
-	return out[0][2], nil
+	return values[0], nil
 }
 
 // Rename renames a dataset.
 func (d *Dataset) Rename(name string, createParent bool, recursiveRenameSnapshots bool) (*Dataset, error) {
+	return d.RenameContext(context.Background(), name, createParent, recursiveRenameSnapshots)
+}
+
+// RenameContext is like Rename, but kills zfs rename when ctx is done.
+func (d *Dataset) RenameContext(ctx context.Context, name string, createParent bool, recursiveRenameSnapshots bool) (*Dataset, error) {
 	args := make([]string, 3, 5)
 	args[0] = "rename"
 	args[1] = d.Name
@@ -328,12 +569,17 @@ func (d *Dataset) Rename(name string, createParent bool, recursiveRenameSnapshot
 	if recursiveRenameSnapshots {
 		args = append(args, "-r")
 	}
-	_, err := zfs(args...)
+	unlock, err := locks.lock(ctx, exclusive(d.Name), exclusive(name))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	_, err = zfs(ctx, args...)
 	if err != nil {
 		return d, err
 	}
 
-	return GetDataset(name)
+	return GetDatasetContext(ctx, name)
 }
 
 // Snapshots returns a slice of all ZFS snapshots of a given dataset.
@@ -346,6 +592,12 @@ func (d *Dataset) Snapshots() ([]*Dataset, error) {
 // A full list of available ZFS properties may be found here:
 // https://www.freebsd.org/cgi/man.cgi?zfs(8).
 func CreateFilesystem(name string, properties map[string]string) (*Dataset, error) {
+	return CreateFilesystemContext(context.Background(), name, properties)
+}
+
+// CreateFilesystemContext is like CreateFilesystem, but kills zfs create when
+// ctx is done.
+func CreateFilesystemContext(ctx context.Context, name string, properties map[string]string) (*Dataset, error) {
 	args := make([]string, 1, 4)
 	args[0] = "create"
 
@@ -354,17 +606,27 @@ func CreateFilesystem(name string, properties map[string]string) (*Dataset, erro
 	}
 
 	args = append(args, name)
-	_, err := zfs(args...)
+	unlock, err := locks.lock(ctx, exclusive(name))
 	if err != nil {
 		return nil, err
 	}
-	return GetDataset(name)
+	defer unlock()
+	_, err = zfs(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	return GetDatasetContext(ctx, name)
 }
 
 // Snapshot creates a new ZFS snapshot of the receiving dataset, using the
 // specified name.  Optionally, the snapshot can be taken recursively, creating
 // snapshots of all descendent filesystems in a single, atomic operation.
 func (d *Dataset) Snapshot(name string, recursive bool) (*Dataset, error) {
+	return d.SnapshotContext(context.Background(), name, recursive)
+}
+
+// SnapshotContext is like Snapshot, but kills zfs snapshot when ctx is done.
+func (d *Dataset) SnapshotContext(ctx context.Context, name string, recursive bool) (*Dataset, error) {
 	args := make([]string, 1, 4)
 	args[0] = "snapshot"
 	if recursive {
@@ -372,11 +634,67 @@ func (d *Dataset) Snapshot(name string, recursive bool) (*Dataset, error) {
 	}
 	snapName := fmt.Sprintf("%s@%s", d.Name, name)
 	args = append(args, snapName)
-	_, err := zfs(args...)
+	unlock, err := locks.lock(ctx, shared(d.Name))
 	if err != nil {
 		return nil, err
 	}
-	return GetDataset(snapName)
+	defer unlock()
+	_, err = zfs(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	return GetDatasetContext(ctx, snapName)
+}
+
+// SnapshotMany creates a snapshot with the specified name of every dataset in
+// names using a single zfs snapshot invocation, so that all of the snapshots
+// are created in the same transaction group.  Properties are set on each of
+// the new snapshots; user properties such as CreatedByProperty can be used to
+// record who or what created them.
+func SnapshotMany(names []string, snapName string, properties map[string]string) ([]*Dataset, error) {
+	return SnapshotManyContext(context.Background(), names, snapName, properties)
+}
+
+// SnapshotManyContext is like SnapshotMany, but kills zfs snapshot when ctx
+// is done.
+func SnapshotManyContext(ctx context.Context, names []string, snapName string, properties map[string]string) ([]*Dataset, error) {
+	if len(names) == 0 {
+		return nil, errors.New("no datasets to snapshot")
+	}
+	if snapName == "" || strings.ContainsAny(snapName, "@/") {
+		return nil, fmt.Errorf("invalid snapshot name %q", snapName)
+	}
+
+	args := make([]string, 1, 1+len(properties)*2+len(names))
+	args[0] = "snapshot"
+	if properties != nil {
+		args = append(args, propsSlice(properties)...)
+	}
+	snapNames := make([]string, len(names))
+	reqs := make([]lockRequest, len(names))
+	for i, name := range names {
+		snapNames[i] = fmt.Sprintf("%s@%s", name, snapName)
+		reqs[i] = shared(name)
+	}
+	args = append(args, snapNames...)
+	unlock, err := locks.lock(ctx, reqs...)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	_, err = zfs(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]*Dataset, len(snapNames))
+	for i, name := range snapNames {
+		snapshots[i], err = GetDatasetContext(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return snapshots, nil
 }
 
 // Rollback rolls back the receiving ZFS dataset to a previous snapshot.
@@ -385,6 +703,11 @@ func (d *Dataset) Snapshot(name string, recursive bool) (*Dataset, error) {
 // snapshots exist.
 // An error will be returned if the input dataset is not of snapshot type.
 func (d *Dataset) Rollback(destroyMoreRecent bool) error {
+	return d.RollbackContext(context.Background(), destroyMoreRecent)
+}
+
+// RollbackContext is like Rollback, but kills zfs rollback when ctx is done.
+func (d *Dataset) RollbackContext(ctx context.Context, destroyMoreRecent bool) error {
 	if d.Type != DatasetSnapshot {
 		return errors.New("can only rollback snapshots")
 	}
@@ -396,7 +719,12 @@ func (d *Dataset) Rollback(destroyMoreRecent bool) error {
 	}
 	args = append(args, d.Name)
 
-	_, err := zfs(args...)
+	unlock, err := locks.lock(ctx, exclusive(datasetOf(d.Name)))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	_, err = zfs(ctx, args...)
 	return err
 }
 
@@ -404,17 +732,17 @@ func (d *Dataset) Rollback(destroyMoreRecent bool) error {
 // A recursion depth may be specified, or a depth of 0 allows unlimited
 // recursion.
 func (d *Dataset) Children(depth uint64) ([]*Dataset, error) {
-	args := []string{"list"}
+	var args []string
 	if depth > 0 {
 		args = append(args, "-d")
 		args = append(args, strconv.FormatUint(depth, 10))
 	} else {
 		args = append(args, "-r")
 	}
-	args = append(args, "-t", "all", "-Hp", "-o", dsPropListOptions)
+	args = append(args, "-t", "all")
 	args = append(args, d.Name)
 
-	out, err := zfs(args...)
+	out, err := zfsList(context.Background(), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -438,13 +766,13 @@ func (d *Dataset) Children(depth uint64) ([]*Dataset, error) {
 // Diff returns changes between a snapshot and the given ZFS dataset.
 // The snapshot name must include the filesystem part as it is possible to
 // compare clones with their origin snapshots.
+// Every change is held in memory; use DiffFunc to process large diffs.
 func (d *Dataset) Diff(snapshot string) ([]*InodeChange, error) {
-	args := []string{"diff", "-FH", snapshot, d.Name}[:]
-	out, err := zfs(args...)
-	if err != nil {
-		return nil, err
-	}
-	inodeChanges, err := parseInodeChanges(out)
+	var inodeChanges []*InodeChange
+	err := d.DiffFunc(snapshot, DiffOptions{}, func(c *InodeChange) error {
+		inodeChanges = append(inodeChanges, c)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -1,6 +1,7 @@
 package zfs
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"math"
@@ -158,7 +159,7 @@ func TestCreateFilesystemWithProperties(t *testing.T) {
 
 func TestVolumes(t *testing.T) {
 	zpoolTest(t, func() {
-		v, err := CreateVolume("test/volume-test", uint64(pow2(23)), nil)
+		v, err := CreateVolume("test/volume-test", Bytes(pow2(23)), nil)
 		ok(t, err)
 
 		// volumes are sometimes "busy" if you try to manipulate them right away
@@ -176,6 +177,55 @@ func TestVolumes(t *testing.T) {
 	})
 }
 
+func TestVolumeResize(t *testing.T) {
+	zpoolTest(t, func() {
+		opts := VolumeOptions{BlockSize: 16 * KiB}
+		v, err := CreateVolumeWithOptions(context.Background(), "test/volume-test", 8*MiB, opts, nil)
+		ok(t, err)
+
+		// volumes are sometimes "busy" if you try to manipulate them right away
+		sleep(1)
+
+		equals(t, 16*KiB, v.Volblocksize)
+		assert(t, v.Refreservation != 0, "Refreservation is not set on a thick volume")
+
+		_, err = v.Resize(16*MiB+512, false)
+		nok(t, err)
+
+		_, err = v.Resize(4*MiB, false)
+		nok(t, err)
+
+		v, err = v.Resize(16*MiB, false)
+		ok(t, err)
+		equals(t, 16*MiB, v.Volsize)
+		assert(t, v.Refreservation >= 16*MiB, "Refreservation was not adjusted")
+
+		ok(t, v.Destroy(DestroyDefault))
+
+		s, err := CreateVolumeWithOptions(context.Background(), "test/sparse-test", 8*MiB, VolumeOptions{Sparse: true}, nil)
+		ok(t, err)
+		sleep(1)
+		equals(t, Bytes(0), s.Refreservation)
+
+		s, err = s.Resize(16*MiB, false)
+		ok(t, err)
+		equals(t, Bytes(0), s.Refreservation)
+
+		ok(t, s.Destroy(DestroyDefault))
+
+		c, err := CreateVolume("test/custom-test", 8*MiB, map[string]string{"refreservation": "4M"})
+		ok(t, err)
+		sleep(1)
+		equals(t, 4*MiB, c.Refreservation)
+
+		c, err = c.Resize(16*MiB, false)
+		ok(t, err)
+		equals(t, 4*MiB, c.Refreservation)
+
+		ok(t, c.Destroy(DestroyDefault))
+	})
+}
+
 func TestSnapshot(t *testing.T) {
 	zpoolTest(t, func() {
 		f, err := CreateFilesystem("test/snapshot-test", nil)
@@ -201,6 +251,42 @@ func TestSnapshot(t *testing.T) {
 	})
 }
 
+func TestSnapshotMany(t *testing.T) {
+	zpoolTest(t, func() {
+		f1, err := CreateFilesystem("test/snapshot-many-a", nil)
+		ok(t, err)
+
+		f2, err := CreateFilesystem("test/snapshot-many-b", nil)
+		ok(t, err)
+
+		props := map[string]string{
+			CreatedByProperty: "zfs_test",
+		}
+		snapshots, err := SnapshotMany([]string{f1.Name, f2.Name}, "test", props)
+		ok(t, err)
+
+		equals(t, 2, len(snapshots))
+		equals(t, "test/snapshot-many-a@test", snapshots[0].Name)
+		equals(t, "test/snapshot-many-b@test", snapshots[1].Name)
+
+		for _, s := range snapshots {
+			equals(t, DatasetSnapshot, s.Type)
+
+			prop, err := s.GetProperty(CreatedByProperty)
+			ok(t, err)
+			equals(t, "zfs_test", prop)
+
+			ok(t, s.Destroy(DestroyDefault))
+		}
+
+		_, err = SnapshotMany([]string{f1.Name}, "bad@name", nil)
+		nok(t, err)
+
+		ok(t, f1.Destroy(DestroyDefault))
+		ok(t, f2.Destroy(DestroyDefault))
+	})
+}
+
 func TestClone(t *testing.T) {
 	zpoolTest(t, func() {
 		f, err := CreateFilesystem("test/snapshot-test", nil)
@@ -408,3 +494,137 @@ func TestDiff(t *testing.T) {
 		ok(t, fs.Destroy(DestroyForceUmount))
 	})
 }
+
+func TestDiffSummary(t *testing.T) {
+	zpoolTest(t, func() {
+		fs, err := CreateFilesystem("test/origin", nil)
+		ok(t, err)
+
+		snapshot, err := fs.Snapshot("snapshot", false)
+		ok(t, err)
+
+		for _, name := range []string{"a", "b", "c"} {
+			f, err := os.Create(filepath.Join(fs.Mountpoint, name))
+			ok(t, err)
+			ok(t, f.Close())
+		}
+
+		summary, err := fs.DiffSummary(snapshot.Name, 1)
+		ok(t, err)
+		equals(t, 4, summary.Total)
+		equals(t, 3, summary.Changes[Created])
+		equals(t, 1, summary.Changes[Modified])
+		equals(t, 3, summary.Inodes[File])
+		equals(t, []DirectoryChanges{{Path: "/test/origin", Changes: 3}}, summary.TopDirectories)
+
+		var timestamped int
+		err = fs.DiffFunc(snapshot.Name, DiffOptions{Timestamps: true}, func(c *InodeChange) error {
+			assert(t, !c.ChangeTime.IsZero(), "ChangeTime is not set")
+			timestamped++
+			return nil
+		})
+		ok(t, err)
+		equals(t, 4, timestamped)
+
+		ok(t, snapshot.Destroy(DestroyForceUmount))
+		ok(t, fs.Destroy(DestroyForceUmount))
+	})
+}
+
+func TestJail(t *testing.T) {
+	zpoolTest(t, func() {
+		f, err := CreateFilesystem("test/jail-test", nil)
+		ok(t, err)
+
+		jailed, err := JailedDatasets()
+		ok(t, err)
+		equals(t, 0, len(jailed))
+
+		nok(t, f.Jail("no-such-jail"))
+		nok(t, f.Unjail(""))
+
+		ok(t, f.Destroy(DestroyDefault))
+	})
+}
+
+func TestDestroySnapshotsMatching(t *testing.T) {
+	zpoolTest(t, func() {
+		f, err := CreateFilesystem("test/program-test", nil)
+		ok(t, err)
+
+		for _, name := range []string{"hourly-1", "hourly-2", "daily-1"} {
+			_, err := f.Snapshot(name, false)
+			ok(t, err)
+		}
+
+		result, err := DestroySnapshotsMatching(context.Background(), f.Name, "^hourly%-", time.Time{}, true)
+		ok(t, err)
+		equals(t, 2, len(result.Matched))
+		equals(t, 0, len(result.Destroyed))
+
+		result, err = DestroySnapshotsMatching(context.Background(), f.Name, "^hourly%-", time.Time{}, false)
+		ok(t, err)
+		equals(t, []string{"test/program-test@hourly-1", "test/program-test@hourly-2"}, result.Destroyed)
+
+		snapshots, err := f.Snapshots()
+		ok(t, err)
+		equals(t, 1, len(snapshots))
+		equals(t, "test/program-test@daily-1", snapshots[0].Name)
+
+		ok(t, f.Destroy(DestroyRecursive))
+	})
+}
+
+func TestLatestSnapshot(t *testing.T) {
+	zpoolTest(t, func() {
+		f, err := CreateFilesystem("test/snapshot-test", nil)
+		ok(t, err)
+
+		latest, err := f.LatestSnapshot()
+		ok(t, err)
+		equals(t, (*Dataset)(nil), latest)
+
+		s1, err := f.Snapshot("test1", false)
+		ok(t, err)
+		s2, err := f.Snapshot("test2", false)
+		ok(t, err)
+
+		assert(t, s1.GUID != 0, "GUID is not set")
+		assert(t, s1.Createtxg < s2.Createtxg, "Createtxg is not increasing")
+		assert(t, !s2.Creation.IsZero(), "Creation is not set")
+
+		latest, err = f.LatestSnapshot()
+		ok(t, err)
+		equals(t, s2.Name, latest.Name)
+
+		between, err := f.SnapshotsBetween(s1.Creation, time.Time{})
+		ok(t, err)
+		equals(t, 2, len(between))
+
+		common, err := f.CommonSnapshot([]*Dataset{s1})
+		ok(t, err)
+		equals(t, s1.Name, common.Name)
+
+		ok(t, f.Destroy(DestroyRecursive))
+	})
+}
+
+func TestWaitFor(t *testing.T) {
+	zpoolTest(t, func() {
+		_, err := zpool(context.Background(), "scrub", "test")
+		ok(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		ok(t, WaitFor(ctx, "test", WaitScrub))
+
+		busy, err := activityInProgress(ctx, "test", WaitScrub)
+		ok(t, err)
+		equals(t, false, busy)
+
+		f, err := CreateFilesystem("test/wait-test", nil)
+		ok(t, err)
+		ok(t, f.Destroy(DestroyDefault))
+		ok(t, WaitFor(ctx, "test", WaitFree))
+	})
+}
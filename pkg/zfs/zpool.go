@@ -1,5 +1,7 @@
 package zfs
 
+import "context"
+
 // ZFS zpool states, which can indicate if a pool is online, offline,
 // degraded, etc.  More information regarding zpool states can be found here:
 // https://docs.oracle.com/cd/E19253-01/819-5461/gamno/index.html.
@@ -17,34 +19,40 @@ const (
 type Zpool struct {
 	Name          string
 	Health        string
-	Allocated     uint64
-	Size          uint64
-	Free          uint64
+	Allocated     Bytes
+	Size          Bytes
+	Free          Bytes
 	Fragmentation uint64
 	ReadOnly      bool
-	Freeing       uint64
-	Leaked        uint64
+	Freeing       Bytes
+	Leaked        Bytes
 	DedupRatio    float64
 }
 
-// zpool is a helper function to wrap typical calls to zpool.
-func zpool(arg ...string) ([][]string, error) {
-	c := command{Command: "zpool"}
+// zpool is a helper function to wrap typical calls to zpool.  The command is
+// killed when ctx is done.
+func zpool(ctx context.Context, arg ...string) ([][]string, error) {
+	c := command{Command: "zpool", Context: ctx}
 	return c.Run(arg...)
 }
 
 // GetZpool retrieves a single ZFS zpool by name.
 func GetZpool(name string) (*Zpool, error) {
-	args := zpoolArgs
-	args = append(args, name)
-	out, err := zpool(args...)
+	return GetZpoolContext(context.Background(), name)
+}
+
+// GetZpoolContext is like GetZpool, but kills zpool get when ctx is done.
+func GetZpoolContext(ctx context.Context, name string) (*Zpool, error) {
+	if z, ok := cache.getZpool(name); ok {
+		return z, nil
+	}
+
+	gen := cache.generation()
+	out, err := zpoolGet(ctx, zpoolPropList, name)
 	if err != nil {
 		return nil, err
 	}
 
-	// there is no -H
-	out = out[1:]
-
 	z := &Zpool{Name: name}
 	for _, line := range out {
 		if err := z.parseLine(line); err != nil {
@@ -52,6 +60,7 @@ func GetZpool(name string) (*Zpool, error) {
 		}
 	}
 
+	cache.putZpool(gen, z)
 	return z, nil
 }
 
@@ -70,6 +79,12 @@ func (z *Zpool) Snapshots() ([]*Dataset, error) {
 // A full list of available ZFS properties and command-line arguments may be
 // found here: https://www.freebsd.org/cgi/man.cgi?zfs(8).
 func CreateZpool(name string, properties map[string]string, args ...string) (*Zpool, error) {
+	return CreateZpoolContext(context.Background(), name, properties, args...)
+}
+
+// CreateZpoolContext is like CreateZpool, but kills zpool create when ctx is
+// done.
+func CreateZpoolContext(ctx context.Context, name string, properties map[string]string, args ...string) (*Zpool, error) {
 	cli := make([]string, 1, 4)
 	cli[0] = "create"
 	if properties != nil {
@@ -77,7 +92,12 @@ func CreateZpool(name string, properties map[string]string, args ...string) (*Zp
 	}
 	cli = append(cli, name)
 	cli = append(cli, args...)
-	_, err := zpool(cli...)
+	unlock, err := locks.lock(ctx, exclusive(name))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	_, err = zpool(ctx, cli...)
 	if err != nil {
 		return nil, err
 	}
@@ -87,26 +107,82 @@ func CreateZpool(name string, properties map[string]string, args ...string) (*Zp
 
 // Destroy destroys a ZFS zpool by name.
 func (z *Zpool) Destroy() error {
-	_, err := zpool("destroy", z.Name)
+	return z.DestroyContext(context.Background())
+}
+
+// DestroyContext is like Destroy, but kills zpool destroy when ctx is done.
+func (z *Zpool) DestroyContext(ctx context.Context) error {
+	unlock, err := locks.lock(ctx, exclusive(z.Name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	_, err = zpool(ctx, "destroy", z.Name)
+	return err
+}
+
+// Scrub starts a scrub of the zpool, or resumes a paused one.  Use WaitFor
+// with WaitScrub to wait for it to finish.
+func (z *Zpool) Scrub() error {
+	return z.ScrubContext(context.Background())
+}
+
+// ScrubContext is like Scrub, but kills zpool scrub when ctx is done.  The
+// scrub itself keeps running; use StopScrub to cancel it.
+func (z *Zpool) ScrubContext(ctx context.Context) error {
+	_, err := zpool(ctx, "scrub", z.Name)
+	return err
+}
+
+// StopScrub cancels the scrub in progress.
+func (z *Zpool) StopScrub() error {
+	return z.StopScrubContext(context.Background())
+}
+
+// StopScrubContext is like StopScrub, but kills zpool scrub -s when ctx is
+// done.
+func (z *Zpool) StopScrubContext(ctx context.Context) error {
+	_, err := zpool(ctx, "scrub", "-s", z.Name)
 	return err
 }
 
 // ListZpools list all ZFS zpools accessible on the current system.
+// The properties of all zpools are retrieved with a single zpool get call.
 func ListZpools() ([]*Zpool, error) {
-	args := []string{"list", "-Ho", "name"}
-	out, err := zpool(args...)
+	return ListZpoolsContext(context.Background())
+}
+
+// ListZpoolsContext is like ListZpools, but kills zpool get when ctx is done.
+func ListZpoolsContext(ctx context.Context) ([]*Zpool, error) {
+	gen := cache.generation()
+	out, err := zpoolGet(ctx, zpoolPropList)
 	if err != nil {
 		return nil, err
 	}
 
+	pools, err := parseZpools(out)
+	if err != nil {
+		return nil, err
+	}
+	for _, z := range pools {
+		cache.putZpool(gen, z)
+	}
+	return pools, nil
+}
+
+// parseZpools groups zpool get output by zpool, keeping the zpool order.
+func parseZpools(out [][]string) ([]*Zpool, error) {
 	var pools []*Zpool
 
+	var z *Zpool
 	for _, line := range out {
-		z, err := GetZpool(line[0])
-		if err != nil {
+		if z == nil || z.Name != line[0] {
+			z = &Zpool{Name: line[0]}
+			pools = append(pools, z)
+		}
+		if err := z.parseLine(line); err != nil {
 			return nil, err
 		}
-		pools = append(pools, z)
 	}
 	return pools, nil
 }
@@ -0,0 +1,21 @@
+package server
+
+import (
+	"github.com/garenwen/freebsd-manager/handle"
+	"github.com/garenwen/freebsd-manager/pkg/audit"
+	"github.com/garenwen/freebsd-manager/pkg/zfs"
+)
+
+// AuditLog opens the audit log configured by opts, and logs the zfs and zpool
+// commands to it.  It returns nil if auditing is disabled.
+func AuditLog(opts handle.Options) (*audit.Log, error) {
+	if opts.AuditLog == "" {
+		return nil, nil
+	}
+	log, err := audit.Open(opts.AuditLog, opts.AuditLogMaxSize, opts.AuditLogMaxFiles)
+	if err != nil {
+		return nil, err
+	}
+	zfs.SetCommandLogger(log)
+	return log, nil
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/garenwen/freebsd-manager/handle"
+	"github.com/garenwen/freebsd-manager/pkg/auth"
+	"github.com/garenwen/freebsd-manager/server/rbac"
+	"github.com/golang/glog"
+	"golang.org/x/sync/errgroup"
+)
+
+// reloadInterval is how often the credentials and certificate files are
+// checked for changes.
+const reloadInterval = 5 * time.Second
+
+// Authenticators returns the authenticators configured by opts, or none if
+// authentication is disabled.  Callers are authenticated by the credentials
+// file, or else by their TLS client certificate.  The credentials file is
+// reloaded on changes until ctx is done.
+func Authenticators(ctx context.Context, g *errgroup.Group, opts handle.Options) ([]auth.Authenticator, error) {
+	var authenticators []auth.Authenticator
+	if opts.AuthFile != "" {
+		store, err := auth.NewStore(opts.AuthFile)
+		if err != nil {
+			return nil, err
+		}
+		g.Go(func() error {
+			store.Watch(ctx, reloadInterval)
+			return nil
+		})
+		authenticators = append(authenticators, store)
+	}
+	if opts.SecureEnabled && opts.TlsCA != "" {
+		authenticators = append(authenticators, auth.Certificate{})
+	}
+
+	if len(authenticators) == 0 {
+		glog.Warningln("authentication is disabled, set --auth-file or --tls-ca to enable it")
+	}
+	return authenticators, nil
+}
+
+// Policy returns the authorization policy configured by opts, or nil if
+// authorization is disabled.  The policy file is reloaded on changes until
+// ctx is done.
+func Policy(ctx context.Context, g *errgroup.Group, opts handle.Options) (*rbac.Engine, error) {
+	if opts.PolicyFile == "" {
+		return nil, nil
+	}
+	engine, err := rbac.NewEngine(opts.PolicyFile)
+	if err != nil {
+		return nil, err
+	}
+	g.Go(func() error {
+		engine.Watch(ctx, reloadInterval)
+		return nil
+	})
+	return engine, nil
+}
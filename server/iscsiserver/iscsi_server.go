@@ -13,6 +13,9 @@ import (
 	"github.com/garenwen/freebsd-manager/server"
 
 	"github.com/garenwen/freebsd-manager/handle"
+	"github.com/garenwen/freebsd-manager/pkg/audit"
+	"github.com/garenwen/freebsd-manager/pkg/auth"
+	"github.com/garenwen/freebsd-manager/server/rbac"
 	"github.com/gin-gonic/gin"
 	"github.com/golang/glog"
 	"golang.org/x/sync/errgroup"
@@ -23,11 +26,12 @@ type IscsiServer struct {
 	shutdownFn    context.CancelFunc
 	childRoutines *errgroup.Group
 
+	opts    handle.Options
 	gin     *gin.Engine
 	httpSrv *http.Server
 }
 
-func NewIscsiServer() server.Server {
+func NewIscsiServer(opts handle.Options) server.Server {
 	rootCtx, shutdownFn := context.WithCancel(context.Background())
 	childRoutines, childCtx := errgroup.WithContext(rootCtx)
 
@@ -35,6 +39,7 @@ func NewIscsiServer() server.Server {
 		context:       childCtx,
 		shutdownFn:    shutdownFn,
 		childRoutines: childRoutines,
+		opts:          opts,
 	}
 }
 
@@ -42,17 +47,39 @@ func (zs *IscsiServer) Start() {
 
 	go ListenToSystemSignals(zs)
 	glog.Infoln("Gin Server staring")
-	zs.gin = zs.newGin()
+	authenticators, err := server.Authenticators(zs.context, zs.childRoutines, zs.opts)
+	if err != nil {
+		glog.Fatalln("failed to load credentials", err)
+	}
+	tlsConfig, err := server.TLSConfig(zs.context, zs.childRoutines, zs.opts)
+	if err != nil {
+		glog.Fatalln("failed to load certificates", err)
+	}
+	policy, err := server.Policy(zs.context, zs.childRoutines, zs.opts)
+	if err != nil {
+		glog.Fatalln("failed to load policy", err)
+	}
+	auditLog, err := server.AuditLog(zs.opts)
+	if err != nil {
+		glog.Fatalln("failed to open audit log", err)
+	}
+	zs.gin = zs.newGin(authenticators, policy, auditLog)
 
 	zs.httpSrv = &http.Server{
 		Addr:           "0.0.0.0:8880",
 		Handler:        zs.gin,
 		ReadTimeout:    70 * time.Second,
 		WriteTimeout:   70 * time.Second,
+		TLSConfig:      tlsConfig,
 		MaxHeaderBytes: 1 << 20,
 	}
 
-	if err := zs.httpSrv.ListenAndServe(); err != nil {
+	serve := zs.httpSrv.ListenAndServe
+	if tlsConfig != nil {
+		// the certificates are served by the TLS configuration
+		serve = func() error { return zs.httpSrv.ListenAndServeTLS("", "") }
+	}
+	if err := serve(); err != nil {
 
 		glog.Errorln("server was shutdown gracefully")
 		zs.Shutdown(1, "Startup failed")
@@ -61,21 +88,33 @@ func (zs *IscsiServer) Start() {
 	}
 }
 
-func (zs *IscsiServer) newGin() *gin.Engine {
+func (zs *IscsiServer) newGin(authenticators []auth.Authenticator, policy *rbac.Engine, auditLog *audit.Log) *gin.Engine {
 
 	route := gin.Default()
 
-	// accounts := gin.Accounts{"measure": "measure"}
-	// route.Use(gin.BasicAuth(accounts))
-
 	route.GET("/healthz", func(c *gin.Context) {
 		c.String(http.StatusOK, "ok")
 	})
 
+	// routes added from here on are audited, including failed authentication
+	if auditLog != nil {
+		route.Use(auditLog.Middleware())
+	}
+
+	// routes added from here on require authentication
+	if len(authenticators) > 0 {
+		route.Use(auth.Middleware(authenticators...))
+		route.Use(auth.SignedPayload())
+	}
+
 	iscsiHandler := handle.NewIscsiHandler()
 	v1 := route.Group("api/v1")
 	{
-		v1.POST("/create_iscsi", iscsiHandler.HandleCreateIscsi)
+		v1.POST("/create_iscsi", rbac.Authorize(policy, rbac.Create, "iscsi"), iscsiHandler.HandleCreateIscsi)
+
+		if auditLog != nil {
+			v1.GET("/audit", rbac.Authorize(policy, rbac.List, "audit"), auditLog.HandleQuery)
+		}
 	}
 
 	return route
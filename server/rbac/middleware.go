@@ -0,0 +1,216 @@
+package rbac
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+
+	v1 "github.com/garenwen/freebsd-manager/pkg/apis/storage/v1"
+	"github.com/garenwen/freebsd-manager/pkg/audit"
+	"github.com/garenwen/freebsd-manager/pkg/auth"
+	"github.com/garenwen/freebsd-manager/pkg/zfs"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+)
+
+// Dataset returns the names of the datasets or pools a request acts on.
+type Dataset func(c *gin.Context) []string
+
+// Param names a dataset by a path parameter.
+func Param(key string) Dataset {
+	return func(c *gin.Context) []string {
+		return []string{c.Param(key)}
+	}
+}
+
+// Query names datasets by query parameters.  If none of them is set, the
+// request acts on all datasets.
+func Query(keys ...string) Dataset {
+	return func(c *gin.Context) []string {
+		var names []string
+		for _, key := range keys {
+			if name := c.Query(key); name != "" {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			return []string{""}
+		}
+		return names
+	}
+}
+
+// maxBodySize is the size up to which JSON request bodies are read.
+const maxBodySize = 1 << 20
+
+// Body names a dataset by a field of the JSON request body.  The field is
+// matched like the handlers bind the body with encoding/json, case
+// insensitively.  The body is read with readBody.
+func Body(field string) Dataset {
+	typ := reflect.StructOf([]reflect.StructField{{
+		Name: "Name",
+		Type: reflect.TypeOf(""),
+		Tag:  reflect.StructTag(`json:"` + field + `"`),
+	}})
+	return func(c *gin.Context) []string {
+		body, ok := readBody(c)
+		if !ok {
+			return nil
+		}
+		v := reflect.New(typ)
+		if err := json.Unmarshal(body, v.Interface()); err != nil {
+			return []string{""}
+		}
+		return []string{v.Elem().Field(0).String()}
+	}
+}
+
+// Property returns the names of the properties a request sets.
+type Property func(c *gin.Context) []string
+
+// BodyProperties names the properties set by the JSON request body, in its
+// mountpoint field and the keys of its properties and parameters maps, and
+// those reset by its inherit list.  The body is read with readBody.
+func BodyProperties() Property {
+	return func(c *gin.Context) []string {
+		body, ok := readBody(c)
+		if !ok {
+			return nil
+		}
+		var v struct {
+			Mountpoint string            `json:"mountpoint"`
+			Properties map[string]string `json:"properties"`
+			Parameters map[string]string `json:"parameters"`
+			Inherit    []string          `json:"inherit"`
+		}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return nil
+		}
+		var props []string
+		if v.Mountpoint != "" {
+			props = append(props, "mountpoint")
+		}
+		for prop := range v.Properties {
+			props = append(props, prop)
+		}
+		for prop := range v.Parameters {
+			props = append(props, prop)
+		}
+		return append(props, v.Inherit...)
+	}
+}
+
+// Selector selects what a request acts on, such as a Dataset or a Property.
+type Selector interface {
+	apply(c *gin.Context, req *Request)
+}
+
+func (d Dataset) apply(c *gin.Context, req *Request) {
+	req.Datasets = append(req.Datasets, d(c)...)
+}
+
+func (p Property) apply(c *gin.Context, req *Request) {
+	req.Properties = append(req.Properties, p(c)...)
+}
+
+// readBody returns the JSON request body, and leaves it for the handler to
+// read.  Requests with duplicate or case-variant fields are rejected with 400
+// Bad Request, as the handler could bind another one than the one authorized,
+// and bodies over 1MB with 413 Request Entity Too Large.
+func readBody(c *gin.Context) ([]byte, bool) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize))
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: err.Error()}})
+		return nil, false
+	}
+	if err != nil {
+		return nil, true
+	}
+	if key, ok := duplicateKey(body); ok {
+		c.AbortWithStatusJSON(http.StatusBadRequest, v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorBadData, Msg: "duplicate field " + key + " in request body"}})
+		return nil, false
+	}
+	return body, true
+}
+
+// duplicateKey returns a key of a JSON object that repeats an earlier one, as
+// compared by encoding/json.
+func duplicateKey(data []byte) (string, bool) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return "", false
+	}
+	var keys []string
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return "", false
+		}
+		key, _ := t.(string)
+		for _, k := range keys {
+			if strings.EqualFold(k, key) {
+				return key, true
+			}
+		}
+		keys = append(keys, key)
+		if err := dec.Decode(&json.RawMessage{}); err != nil {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// Authorize returns a handler that rejects a request with 403 Forbidden unless
+// engine allows the caller verb on resource, for the datasets the request
+// acts on and the properties it sets.  Requests are not authorized if engine
+// is nil.
+//
+// Callers are recorded with auth.SetUnscoped as authorized for all datasets
+// if a rule that is not limited to datasets allows the request, or if engine
+// is nil.  The host properties the caller may not set on the datasets are
+// recorded with auth.SetDeniedProperties, for handlers of requests whose
+// properties are only known to zfs, such as received streams.
+func Authorize(engine *Engine, verb, resource string, selectors ...Selector) gin.HandlerFunc {
+	if engine == nil {
+		return func(c *gin.Context) {
+			auth.SetUnscoped(c, true)
+		}
+	}
+	return func(c *gin.Context) {
+		req := Request{Subject: auth.Subject(c), Verb: verb, Resource: resource}
+		for _, s := range selectors {
+			if s.apply(c, &req); c.IsAborted() {
+				return
+			}
+		}
+		if engine.Allowed(req) {
+			// only rules without datasets allow all of them
+			auth.SetUnscoped(c, engine.Allowed(Request{Subject: req.Subject, Verb: verb, Resource: resource, Datasets: []string{""}}))
+			if req.Datasets != nil {
+				var denied []string
+				for _, prop := range zfs.HostProperties {
+					if !engine.Allowed(Request{Subject: req.Subject, Verb: verb, Resource: resource, Datasets: req.Datasets, Properties: []string{prop}}) {
+						denied = append(denied, prop)
+					}
+				}
+				auth.SetDeniedProperties(c, denied)
+			}
+			return
+		}
+
+		denial := fmt.Sprintf("%s %s of %q", req.Verb, req.Resource, req.Datasets)
+		if len(req.Properties) > 0 {
+			denial += fmt.Sprintf(" setting %q", req.Properties)
+		}
+		glog.Warningf("audit: denied %s to %s for %s %s", denial, req.Subject, c.Request.Method, c.Request.URL.EscapedPath())
+		audit.Deny(c, denial)
+		c.AbortWithStatusJSON(http.StatusForbidden, v1.BaseResult{Status: v1.StatusError, ApiError: &v1.ApiError{Typ: v1.ErrorPermission, Msg: req.Subject + " may not " + req.Verb + " " + req.Resource}})
+	}
+}
@@ -0,0 +1,301 @@
+// Package rbac authorizes the requests of authenticated callers by the roles
+// bound to them in a policy file.
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garenwen/freebsd-manager/pkg/auth"
+	"github.com/garenwen/freebsd-manager/pkg/zfs"
+	"github.com/golang/glog"
+)
+
+// Verbs of requests.
+const (
+	Get    = "get"
+	List   = "list"
+	Create = "create"
+	Update = "update"
+	Delete = "delete"
+)
+
+// Anonymous is the subject of callers that were not authenticated.
+const Anonymous = auth.Anonymous
+
+// Policy binds roles to subjects, as read from a JSON file such as:
+//
+//	{
+//		"roles": [{
+//			"name": "team-a",
+//			"rules": [{
+//				"verbs": ["get", "list", "create", "delete"],
+//				"resources": ["volumes", "snapshots"],
+//				"datasets": ["tank/k8s/team-a"]
+//			}]
+//		}, {
+//			"name": "monitoring",
+//			"rules": [{"verbs": ["get", "list"], "resources": ["*"]}]
+//		}],
+//		"bindings": [
+//			{"role": "team-a", "subjects": ["token:team-a", "certificate:team-a"]},
+//			{"role": "monitoring", "subjects": ["basic:prometheus"]}
+//		]
+//	}
+//
+// Subjects are the names of authenticated callers prefixed with the method
+// that authenticated them: token: for bearer tokens, basic: for htpasswd
+// users, hmac: for HMAC key ids and certificate: for the CN of client
+// certificates.  Names are only unique within a method, so a binding of
+// token:admin is not granted to a certificate with CN=admin.  anonymous is
+// the subject of callers that were not authenticated, and * any caller.
+type Policy struct {
+	Roles    []Role    `json:"roles"`
+	Bindings []Binding `json:"bindings"`
+}
+
+// Role is a named set of rules.
+type Role struct {
+	Name  string `json:"name"`
+	Rules []Rule `json:"rules"`
+}
+
+// Rule allows verbs on resources, both of which may be *.
+//
+// If Datasets is set, the rule only allows requests for the named datasets,
+// their descendants and their snapshots, and requests for resources which are
+// not datasets, such as jobs, of which the caller only sees their own.  Pools
+// are named like their root dataset.  Unfiltered lists act on all datasets,
+// so they are not allowed by such a rule: the caller lists volumes, say, with
+// a filter within its datasets, and gets its pools by name, as the list of
+// pools needs a rule without datasets.  Such a rule does not allow setting the
+// properties that take effect on the host, zfs.HostProperties, unless they
+// are listed in Properties, which may be *, as they could mount or share the
+// datasets outside of them.  They are also excluded from received streams.
+type Rule struct {
+	Verbs      []string `json:"verbs"`
+	Resources  []string `json:"resources"`
+	Datasets   []string `json:"datasets,omitempty"`
+	Properties []string `json:"properties,omitempty"`
+}
+
+// Binding grants a role to subjects.
+type Binding struct {
+	Role     string   `json:"role"`
+	Subjects []string `json:"subjects"`
+}
+
+// Request is an action of a caller to be authorized.
+type Request struct {
+	Subject  string
+	Verb     string
+	Resource string
+	// Datasets are the datasets or pools the request acts on, nil if the
+	// resource is not a dataset.  An empty name stands for all of them, e.g.
+	// for unfiltered lists.
+	Datasets []string
+	// Properties are the properties the request sets on the datasets.
+	Properties []string
+}
+
+func contains(list []string, val string) bool {
+	for _, v := range list {
+		if v == "*" || v == val {
+			return true
+		}
+	}
+	return false
+}
+
+// within reports whether the dataset is one of prefixes, a descendant or a
+// snapshot of one.
+func within(dataset string, prefixes []string) bool {
+	if dataset == "" {
+		return false
+	}
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if dataset == prefix || strings.HasPrefix(dataset, prefix+"/") || strings.HasPrefix(dataset, prefix+"@") {
+			return true
+		}
+	}
+	return false
+}
+
+// hostProperty reports whether prop is one of zfs.HostProperties, which zfs
+// only knows in lower case.
+func hostProperty(prop string) bool {
+	for _, p := range zfs.HostProperties {
+		if strings.EqualFold(p, prop) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) allows(verb, resource, dataset string, properties []string) bool {
+	if !contains(r.Verbs, verb) || !contains(r.Resources, resource) {
+		return false
+	}
+	if len(r.Datasets) == 0 {
+		return true
+	}
+	if !within(dataset, r.Datasets) {
+		return false
+	}
+	for _, prop := range properties {
+		if hostProperty(prop) && !contains(r.Properties, strings.ToLower(prop)) {
+			return false
+		}
+	}
+	return true
+}
+
+// rules returns the rules of the roles bound to subject.
+func (p *Policy) rules(subject string) ([]Rule, error) {
+	roles := make(map[string]*Role, len(p.Roles))
+	for i := range p.Roles {
+		roles[p.Roles[i].Name] = &p.Roles[i]
+	}
+
+	var rules []Rule
+	for _, b := range p.Bindings {
+		role, ok := roles[b.Role]
+		if !ok {
+			return nil, fmt.Errorf("binding of unknown role %s", b.Role)
+		}
+		if contains(b.Subjects, subject) {
+			rules = append(rules, role.Rules...)
+		}
+	}
+	return rules, nil
+}
+
+// Allowed reports whether the policy allows a request.
+func (p *Policy) Allowed(req Request) bool {
+	rules, err := p.rules(req.Subject)
+	if err != nil {
+		return false
+	}
+
+	if req.Datasets == nil {
+		for i := range rules {
+			if contains(rules[i].Verbs, req.Verb) && contains(rules[i].Resources, req.Resource) {
+				return true
+			}
+		}
+		return false
+	}
+	// every dataset must be allowed by some rule
+	for _, dataset := range req.Datasets {
+		allowed := false
+		for i := range rules {
+			if rules[i].allows(req.Verb, req.Resource, dataset, req.Properties) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// validate checks that every binding refers to a role.
+func (p *Policy) validate() error {
+	_, err := p.rules("")
+	return err
+}
+
+// Engine evaluates requests against the policy of a file.
+type Engine struct {
+	path string
+
+	mu      sync.RWMutex
+	policy  *Policy
+	modTime time.Time
+	size    int64
+}
+
+// NewEngine returns an Engine with the policy of the file at path.
+func NewEngine(path string) (*Engine, error) {
+	e := &Engine{path: path}
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reload reads the policy file again.  The previous policy is kept if it
+// cannot be read.
+func (e *Engine) Reload() error {
+	fi, err := os.Stat(e.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(e.path)
+	if err != nil {
+		return err
+	}
+	policy := &Policy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return fmt.Errorf("%s: %v", e.path, err)
+	}
+	if err := policy.validate(); err != nil {
+		return fmt.Errorf("%s: %v", e.path, err)
+	}
+
+	e.mu.Lock()
+	e.policy, e.modTime, e.size = policy, fi.ModTime(), fi.Size()
+	e.mu.Unlock()
+	return nil
+}
+
+// changed reports whether the policy file was modified since it was last
+// read.
+func (e *Engine) changed() bool {
+	fi, err := os.Stat(e.path)
+	if err != nil {
+		return false
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return !fi.ModTime().Equal(e.modTime) || fi.Size() != e.size
+}
+
+// Watch reloads the policy file whenever it changes, checking it every
+// interval until ctx is done.
+func (e *Engine) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !e.changed() {
+			continue
+		}
+		if err := e.Reload(); err != nil {
+			glog.Errorln("failed to reload policy", err)
+			continue
+		}
+		glog.Infoln("reloaded policy from", e.path)
+	}
+}
+
+// Allowed reports whether the current policy allows a request.
+func (e *Engine) Allowed(req Request) bool {
+	e.mu.RLock()
+	policy := e.policy
+	e.mu.RUnlock()
+	return policy.Allowed(req)
+}
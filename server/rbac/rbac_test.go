@@ -0,0 +1,331 @@
+package rbac
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/garenwen/freebsd-manager/pkg/auth"
+	"github.com/gin-gonic/gin"
+)
+
+var testPolicy = Policy{
+	Roles: []Role{{
+		Name: "team-a",
+		Rules: []Rule{
+			{Verbs: []string{Get, List, Create, Delete}, Resources: []string{"volumes", "snapshots"}, Datasets: []string{"tank/k8s/team-a"}},
+			{Verbs: []string{Get}, Resources: []string{"jobs"}, Datasets: []string{"tank/k8s/team-a"}},
+			{Verbs: []string{Create}, Resources: []string{"snapshots"}, Datasets: []string{"tank/k8s/team-a"}, Properties: []string{"mountpoint"}},
+		},
+	}, {
+		Name:  "monitoring",
+		Rules: []Rule{{Verbs: []string{Get, List}, Resources: []string{"*"}}},
+	}, {
+		Name:  "admin",
+		Rules: []Rule{{Verbs: []string{"*"}, Resources: []string{"*"}}},
+	}},
+	Bindings: []Binding{
+		{Role: "team-a", Subjects: []string{"token:team-a"}},
+		{Role: "monitoring", Subjects: []string{"token:prometheus", "token:admin"}},
+		{Role: "admin", Subjects: []string{"token:admin"}},
+	},
+}
+
+func TestPolicyAllowed(t *testing.T) {
+	var tests = []struct {
+		req  Request
+		want bool
+	}{
+		{Request{"token:team-a", Create, "volumes", []string{"tank/k8s/team-a/pvc-1"}, nil}, true},
+		{Request{"token:team-a", Delete, "volumes", []string{"tank/k8s/team-a"}, nil}, true},
+		{Request{"token:team-a", Delete, "snapshots", []string{"tank/k8s/team-a/pvc-1@snap"}, nil}, true},
+		{Request{"token:team-a", Delete, "volumes", []string{"tank/k8s/team-b/pvc-1"}, nil}, false},
+		{Request{"token:team-a", Delete, "volumes", []string{"tank/k8s/team-ab"}, nil}, false},
+		{Request{"token:team-a", Update, "volumes", []string{"tank/k8s/team-a/pvc-1"}, nil}, false},
+		{Request{"token:team-a", Create, "filesystems", []string{"tank/k8s/team-a/fs"}, nil}, false},
+		// clones must stay within the datasets of the caller
+		{Request{"token:team-a", Create, "volumes", []string{"tank/k8s/team-a/pvc-1", "tank/k8s/team-b/pvc-2"}, nil}, false},
+		// unfiltered lists and pools are outside any dataset
+		{Request{"token:team-a", List, "volumes", []string{""}, nil}, false},
+		{Request{"token:team-a", List, "volumes", []string{"tank/k8s/team-a"}, nil}, true},
+		{Request{"token:team-a", Get, "pools", []string{"tank"}, nil}, false},
+		// resources which are not datasets
+		{Request{"token:team-a", Get, "jobs", nil, nil}, true},
+		{Request{"token:team-a", List, "jobs", nil, nil}, false},
+		{Request{"token:prometheus", List, "volumes", []string{""}, nil}, true},
+		{Request{"token:prometheus", Get, "metrics", nil, nil}, true},
+		{Request{"token:prometheus", Delete, "volumes", []string{"tank/vol"}, nil}, false},
+		{Request{"token:admin", Delete, "pools", []string{"tank"}, nil}, true},
+		{Request{"token:unknown", Get, "volumes", []string{"tank/vol"}, nil}, false},
+		{Request{Anonymous, Get, "metrics", nil, nil}, false},
+		// scoped rules do not allow the properties that take effect on the
+		// host, unless they list them
+		{Request{"token:team-a", Create, "volumes", []string{"tank/k8s/team-a/pvc-1"}, []string{"compression", "volsize"}}, true},
+		{Request{"token:team-a", Create, "volumes", []string{"tank/k8s/team-a/fs"}, []string{"mountpoint"}}, false},
+		{Request{"token:team-a", Create, "volumes", []string{"tank/k8s/team-a/fs"}, []string{"SHARENFS"}}, false},
+		{Request{"token:team-a", Create, "volumes", []string{"tank/k8s/team-a/fs"}, []string{"jailed"}}, false},
+		{Request{"token:team-a", Create, "snapshots", []string{"tank/k8s/team-a/fs"}, []string{"mountpoint"}}, true},
+		{Request{"token:admin", Create, "volumes", []string{"tank/fs"}, []string{"mountpoint"}}, true},
+		// subjects of other methods are other callers
+		{Request{"certificate:admin", Delete, "pools", []string{"tank"}, nil}, false},
+	}
+
+	for _, test := range tests {
+		if got := testPolicy.Allowed(test.req); got != test.want {
+			t.Errorf("Allowed(%+v) = %v, want %v", test.req, got, test.want)
+		}
+	}
+}
+
+func TestEngineReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rbac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.json")
+
+	write := func(p interface{}) {
+		data, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(testPolicy)
+	e, err := NewEngine(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := Request{Subject: "token:prometheus", Verb: Get, Resource: "metrics"}
+	if !e.Allowed(req) {
+		t.Fatal("request denied by the loaded policy")
+	}
+
+	write(Policy{Bindings: []Binding{{Role: "missing", Subjects: []string{"*"}}}})
+	if err := e.Reload(); err == nil {
+		t.Fatal("policy with an unknown role was loaded")
+	}
+	if !e.Allowed(req) {
+		t.Fatal("policy was dropped by a failed reload")
+	}
+
+	write(Policy{})
+	if err := e.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if e.Allowed(req) {
+		t.Fatal("request allowed by an empty policy")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir, err := ioutil.TempDir("", "rbac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.json")
+	data, _ := json.Marshal(Policy{
+		Roles:    []Role{{Name: "anonymous", Rules: []Rule{{Verbs: []string{Create}, Resources: []string{"volumes"}, Datasets: []string{"tank/a", "tank/k8s/team-a"}}}}},
+		Bindings: []Binding{{Role: "anonymous", Subjects: []string{Anonymous}}},
+	})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEngine(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route := gin.New()
+	route.POST("/volumes", Authorize(e, Create, "volumes", Body("name")), func(c *gin.Context) {
+		// the body is still readable by the handler
+		body, _ := ioutil.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	})
+
+	for _, test := range []struct {
+		body string
+		code int
+	}{
+		{`{"name": "tank/a/vol"}`, http.StatusOK},
+		{`{"name": "tank/b/vol"}`, http.StatusForbidden},
+		{`{}`, http.StatusForbidden},
+		{`not json`, http.StatusForbidden},
+		// fields are matched case insensitively, like the handlers bind them
+		{`{"Name": "tank/a/vol"}`, http.StatusOK},
+		{`{"NAME": "tank/b/vol"}`, http.StatusForbidden},
+		// the handler would bind the last of duplicate fields
+		{`{"name":"tank/k8s/team-a/v","Name":"tank/victim/v"}`, http.StatusBadRequest},
+		{`{"name":"tank/a/vol","name":"tank/b/vol"}`, http.StatusBadRequest},
+		{`{"name": "tank/a/vol"}` + strings.Repeat(" ", maxBodySize), http.StatusRequestEntityTooLarge},
+	} {
+		w := httptest.NewRecorder()
+		route.ServeHTTP(w, httptest.NewRequest("POST", "/volumes", strings.NewReader(test.body)))
+		if w.Code != test.code {
+			t.Errorf("%.50s: got %d, want %d", test.body, w.Code, test.code)
+		}
+		if w.Code == http.StatusOK && w.Body.String() != test.body {
+			t.Errorf("%s: handler read %q", test.body, w.Body.String())
+		}
+	}
+}
+
+// callerHeader authenticates the caller named by the X-Caller header.
+type callerHeader struct{}
+
+func (callerHeader) Authenticate(r *http.Request) (*auth.Identity, error) {
+	return &auth.Identity{Name: r.Header.Get("X-Caller"), Method: auth.MethodToken}, nil
+}
+
+func TestUnscoped(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir, err := ioutil.TempDir("", "rbac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.json")
+	data, _ := json.Marshal(testPolicy)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEngine(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route := gin.New()
+	route.Use(auth.Middleware(callerHeader{}))
+	unscoped := func(c *gin.Context) {
+		c.String(http.StatusOK, strconv.FormatBool(auth.Unscoped(c)))
+	}
+	route.GET("/jobs", Authorize(e, Get, "jobs"), unscoped)
+	route.GET("/open/jobs", Authorize(nil, Get, "jobs"), unscoped)
+
+	for _, test := range []struct {
+		caller, path, unscoped string
+	}{
+		{"team-a", "/jobs", "false"},
+		{"prometheus", "/jobs", "true"},
+		{"team-a", "/open/jobs", "true"},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", test.path, nil)
+		r.Header.Set("X-Caller", test.caller)
+		route.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != test.unscoped {
+			t.Errorf("%s %s: got %d %s, want unscoped %s", test.caller, test.path, w.Code, w.Body.String(), test.unscoped)
+		}
+	}
+}
+
+func TestAuthorizeProperties(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir, err := ioutil.TempDir("", "rbac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.json")
+	data, _ := json.Marshal(testPolicy)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEngine(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route := gin.New()
+	route.Use(auth.Middleware(callerHeader{}))
+	route.POST("/volumes", Authorize(e, Create, "volumes", Body("name"), BodyProperties()), func(c *gin.Context) {
+		c.String(http.StatusOK, strings.Join(auth.DeniedProperties(c), ","))
+	})
+
+	for _, test := range []struct {
+		caller, body string
+		code         int
+		denied       string
+	}{
+		{"team-a", `{"name":"tank/k8s/team-a/v","parameters":{"compression":"lz4"}}`, http.StatusOK, "mountpoint,sharenfs,sharesmb,jailed"},
+		{"team-a", `{"name":"tank/k8s/team-a/fs","mountpoint":"/etc"}`, http.StatusForbidden, ""},
+		{"team-a", `{"name":"tank/k8s/team-a/fs","properties":{"sharenfs":"on"}}`, http.StatusForbidden, ""},
+		{"team-a", `{"name":"tank/k8s/team-a/fs","Properties":{"jailed":"on"}}`, http.StatusForbidden, ""},
+		{"team-a", `{"name":"tank/k8s/team-a/fs","inherit":["mountpoint"]}`, http.StatusForbidden, ""},
+		{"admin", `{"name":"tank/fs","mountpoint":"/srv"}`, http.StatusOK, ""},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/volumes", strings.NewReader(test.body))
+		r.Header.Set("X-Caller", test.caller)
+		route.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s %s: got %d, want %d", test.caller, test.body, w.Code, test.code)
+		}
+		if w.Code == http.StatusOK && w.Body.String() != test.denied {
+			t.Errorf("%s %s: denied %q, want %q", test.caller, test.body, w.Body.String(), test.denied)
+		}
+	}
+}
+
+func TestAuthorizePools(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir, err := ioutil.TempDir("", "rbac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.json")
+	data, _ := json.Marshal(Policy{
+		Roles: []Role{
+			{Name: "tank-reader", Rules: []Rule{{Verbs: []string{Get, List}, Resources: []string{"pools"}, Datasets: []string{"tank"}}}},
+			{Name: "monitoring", Rules: []Rule{{Verbs: []string{Get, List}, Resources: []string{"pools"}}}},
+		},
+		Bindings: []Binding{
+			{Role: "tank-reader", Subjects: []string{"token:team-a"}},
+			{Role: "monitoring", Subjects: []string{"token:prometheus"}},
+		},
+	})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEngine(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route := gin.New()
+	route.Use(auth.Middleware(callerHeader{}))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	route.GET("/pools", Authorize(e, List, "pools", Query()), ok)
+	route.GET("/pools/:name", Authorize(e, Get, "pools", Param("name")), ok)
+
+	for _, test := range []struct {
+		caller, path string
+		code         int
+	}{
+		{"team-a", "/pools/tank", http.StatusOK},
+		{"team-a", "/pools/backup", http.StatusForbidden},
+		// the list of pools needs a rule without datasets
+		{"team-a", "/pools", http.StatusForbidden},
+		{"prometheus", "/pools", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", test.path, nil)
+		r.Header.Set("X-Caller", test.caller)
+		route.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s %s: got %d, want %d", test.caller, test.path, w.Code, test.code)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/garenwen/freebsd-manager/handle"
+	"github.com/golang/glog"
+	"golang.org/x/sync/errgroup"
+)
+
+// certificates serves the certificate of the server and the CA of client
+// certificates, reloading them from their files on changes.
+type certificates struct {
+	certFile, keyFile, caFile string
+
+	mu       sync.RWMutex
+	config   *tls.Config
+	modTimes map[string]time.Time
+}
+
+// TLSConfig returns the TLS configuration of opts, or nil if TLS is disabled.
+// Client certificates signed by opts.TlsCA are required if it is set.  The
+// files are reloaded on changes until ctx is done.  A key or CA without a
+// certificate is an error, rather than silently serving plain HTTP.
+func TLSConfig(ctx context.Context, g *errgroup.Group, opts handle.Options) (*tls.Config, error) {
+	if !opts.SecureEnabled {
+		if opts.TlsCertKey != "" || opts.TlsCA != "" {
+			return nil, fmt.Errorf("--tls-key and --tls-ca require --tls-certificate")
+		}
+		return nil, nil
+	}
+	certs := &certificates{certFile: opts.TlsCert, keyFile: opts.TlsCertKey, caFile: opts.TlsCA}
+	// the key may be in the same file as the certificate
+	if certs.keyFile == "" {
+		certs.keyFile = certs.certFile
+	}
+	if err := certs.reload(); err != nil {
+		return nil, err
+	}
+	g.Go(func() error {
+		certs.watch(ctx, reloadInterval)
+		return nil
+	})
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &certs.current().Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return certs.current(), nil
+		},
+	}, nil
+}
+
+func (certs *certificates) files() []string {
+	files := []string{certs.certFile, certs.keyFile}
+	if certs.caFile != "" {
+		files = append(files, certs.caFile)
+	}
+	return files
+}
+
+// reload reads the files again.  The previous configuration is kept if they
+// cannot be read.
+func (certs *certificates) reload() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range certs.files() {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = fi.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(certs.certFile, certs.keyFile)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if certs.caFile != "" {
+		pem, err := ioutil.ReadFile(certs.caFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", certs.caFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	certs.mu.Lock()
+	certs.config, certs.modTimes = config, modTimes
+	certs.mu.Unlock()
+	return nil
+}
+
+// changed reports whether any of the files was modified since they were last
+// read.
+func (certs *certificates) changed() bool {
+	certs.mu.RLock()
+	defer certs.mu.RUnlock()
+	for _, file := range certs.files() {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return false
+		}
+		if !fi.ModTime().Equal(certs.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch reloads the files whenever they change, checking them every interval
+// until ctx is done.
+func (certs *certificates) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !certs.changed() {
+			continue
+		}
+		// the certificate and the key may be replaced one after the other,
+		// so a mismatch is retried on the next tick
+		if err := certs.reload(); err != nil {
+			glog.Errorln("failed to reload certificates", err)
+			continue
+		}
+		glog.Infoln("reloaded certificate", certs.certFile)
+	}
+}
+
+func (certs *certificates) current() *tls.Config {
+	certs.mu.RLock()
+	defer certs.mu.RUnlock()
+	return certs.config
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/garenwen/freebsd-manager/handle"
+	"github.com/garenwen/freebsd-manager/pkg/auth"
+	"golang.org/x/sync/errgroup"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// newTestCert returns a certificate signed by parent, or a self-signed CA if
+// parent is nil.
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})...)
+	return &testCert{cert: cert, key: key, pem: data}
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(c.pem, c.pem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil)
+	certFile, caFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(certFile, newTestCert(t, "server", ca).pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(caFile, ca.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() {
+		cancel()
+		g.Wait()
+	}()
+	opts := handle.Options{SecureEnabled: true, TlsCert: certFile, TlsCA: caFile}
+	config, err := TLSConfig(ctx, g, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := auth.Certificate{}.Authenticate(r)
+		w.Write([]byte(id.Name))
+	}))
+	srv.TLS = config
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(clientCert *testCert) (string, *x509.Certificate, error) {
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.cert)
+		config := &tls.Config{RootCAs: roots}
+		if clientCert != nil {
+			config.Certificates = []tls.Certificate{clientCert.tlsCertificate(t)}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			return "", nil, err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), resp.TLS.PeerCertificates[0], err
+	}
+
+	name, serverCert, err := get(newTestCert(t, "team-a", ca))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "team-a" {
+		t.Errorf("got identity %q, want team-a", name)
+	}
+	if _, _, err := get(nil); err == nil {
+		t.Error("request without a client certificate succeeded")
+	}
+	if _, _, err := get(newTestCert(t, "team-a", newTestCert(t, "other", nil))); err == nil {
+		t.Error("request with a client certificate of another CA succeeded")
+	}
+
+	// a renewed certificate is served after a reload
+	renewed := newTestCert(t, "server", ca)
+	if err := ioutil.WriteFile(certFile, renewed.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(certFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(3 * reloadInterval)
+	for {
+		_, cert, err := get(newTestCert(t, "team-a", ca))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cert.Equal(serverCert) {
+			if !cert.Equal(renewed.cert) {
+				t.Fatal("unexpected server certificate")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("certificate was not reloaded")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestTLSConfigWithoutCertificate(t *testing.T) {
+	g := &errgroup.Group{}
+	for _, opts := range []handle.Options{
+		{TlsCertKey: "server.key"},
+		{TlsCA: "ca.pem"},
+	} {
+		if _, err := TLSConfig(context.Background(), g, opts); err == nil {
+			t.Errorf("%+v: missing certificate was not an error", opts)
+		}
+	}
+	if config, err := TLSConfig(context.Background(), g, handle.Options{}); config != nil || err != nil {
+		t.Errorf("got %v, %v without TLS", config, err)
+	}
+}
@@ -13,6 +13,10 @@ import (
 	"github.com/garenwen/freebsd-manager/server"
 
 	"github.com/garenwen/freebsd-manager/handle"
+	"github.com/garenwen/freebsd-manager/pkg/audit"
+	"github.com/garenwen/freebsd-manager/pkg/auth"
+	"github.com/garenwen/freebsd-manager/pkg/jobs"
+	"github.com/garenwen/freebsd-manager/server/rbac"
 	"github.com/gin-gonic/gin"
 	"github.com/golang/glog"
 	"golang.org/x/sync/errgroup"
@@ -23,11 +27,12 @@ type ZfsServer struct {
 	shutdownFn    context.CancelFunc
 	childRoutines *errgroup.Group
 
+	opts    handle.Options
 	gin     *gin.Engine
 	httpSrv *http.Server
 }
 
-func NewZfsServer() server.Server {
+func NewZfsServer(opts handle.Options) server.Server {
 	rootCtx, shutdownFn := context.WithCancel(context.Background())
 	childRoutines, childCtx := errgroup.WithContext(rootCtx)
 
@@ -35,6 +40,7 @@ func NewZfsServer() server.Server {
 		context:       childCtx,
 		shutdownFn:    shutdownFn,
 		childRoutines: childRoutines,
+		opts:          opts,
 	}
 }
 
@@ -42,17 +48,45 @@ func (zs *ZfsServer) Start() {
 
 	go ListenToSystemSignals(zs)
 	glog.Infoln("Gin Server staring")
-	zs.gin = zs.newGin()
+	jobManager, err := jobs.NewManager(zs.opts.JobHistory, zs.opts.JobHistorySize)
+	if err != nil {
+		glog.Fatalln("failed to load job history", err)
+	}
+	authenticators, err := server.Authenticators(zs.context, zs.childRoutines, zs.opts)
+	if err != nil {
+		glog.Fatalln("failed to load credentials", err)
+	}
+	tlsConfig, err := server.TLSConfig(zs.context, zs.childRoutines, zs.opts)
+	if err != nil {
+		glog.Fatalln("failed to load certificates", err)
+	}
+	policy, err := server.Policy(zs.context, zs.childRoutines, zs.opts)
+	if err != nil {
+		glog.Fatalln("failed to load policy", err)
+	}
+	auditLog, err := server.AuditLog(zs.opts)
+	if err != nil {
+		glog.Fatalln("failed to open audit log", err)
+	}
+	zs.gin = zs.newGin(jobManager, authenticators, policy, auditLog)
 
 	zs.httpSrv = &http.Server{
-		Addr:           "0.0.0.0:8870",
-		Handler:        zs.gin,
+		Addr: "0.0.0.0:8870",
+		// snapshot streams can take hours, so their handlers lift the
+		// timeouts
+		Handler:        handle.StreamDeadlines(zs.gin),
 		ReadTimeout:    70 * time.Second,
 		WriteTimeout:   70 * time.Second,
 		MaxHeaderBytes: 1 << 20,
+		TLSConfig:      tlsConfig,
 	}
 
-	if err := zs.httpSrv.ListenAndServe(); err != nil {
+	serve := zs.httpSrv.ListenAndServe
+	if tlsConfig != nil {
+		// the certificates are served by the TLS configuration
+		serve = func() error { return zs.httpSrv.ListenAndServeTLS("", "") }
+	}
+	if err := serve(); err != nil {
 
 		glog.Errorln("server was shutdown gracefully")
 		zs.Shutdown(1, "Startup failed")
@@ -61,22 +95,87 @@ func (zs *ZfsServer) Start() {
 	}
 }
 
-func (zs *ZfsServer) newGin() *gin.Engine {
+func (zs *ZfsServer) newGin(jobManager *jobs.Manager, authenticators []auth.Authenticator, policy *rbac.Engine, auditLog *audit.Log) *gin.Engine {
 
 	route := gin.Default()
-
-	// accounts := gin.Accounts{"measure": "measure"}
-	// route.Use(gin.BasicAuth(accounts))
+	// dataset names are escaped in paths, e.g. /exports/tank%2Fnfs
+	route.UseRawPath = true
 
 	route.GET("/healthz", func(c *gin.Context) {
 		c.String(http.StatusOK, "ok")
 	})
 
-	zfsHandler := handle.NewZfsHandler()
+	// routes added from here on are audited, including failed authentication
+	if auditLog != nil {
+		route.Use(auditLog.Middleware())
+	}
+
+	// routes added from here on require authentication
+	if len(authenticators) > 0 {
+		route.Use(auth.Middleware(authenticators...))
+		// snapshot streams cannot be read twice to check their digest
+		route.Use(auth.SignedPayload("/apis/storage/v1/snapshots/:name/stream", "/apis/storage/v1/datasets/:name/receive"))
+	}
+
+	// every route is authorized for a verb on a resource, and the datasets
+	// named by the request and the properties it sets
+	authorize := func(verb, resource string, selectors ...rbac.Selector) gin.HandlerFunc {
+		return rbac.Authorize(policy, verb, resource, selectors...)
+	}
+	name := rbac.Param("name")
+	props := rbac.BodyProperties()
+
+	zfsHandler := handle.NewZfsHandler(jobManager)
+	route.GET("/metrics", authorize(rbac.Get, "metrics"), zfsHandler.HandleMetrics)
+
 	v1 := route.Group("apis/storage/v1")
 	{
 		// 汇聚查询接口
-		v1.POST("/create_volume", zfsHandler.HandleCreateVolume)
+		v1.POST("/create_volume", authorize(rbac.Create, "volumes", rbac.Body("name"), props), zfsHandler.HandleCreateVolume)
+		v1.POST("/expand_volume", authorize(rbac.Update, "volumes", rbac.Body("volume_id")), zfsHandler.HandleExpandVolume)
+
+		v1.GET("/volumes", authorize(rbac.List, "volumes", rbac.Query("filter")), zfsHandler.HandleListVolumes)
+		v1.GET("/volumes/:name", authorize(rbac.Get, "volumes", name), zfsHandler.HandleGetVolume)
+		v1.PATCH("/volumes/:name", authorize(rbac.Update, "volumes", name, props), zfsHandler.HandleUpdateVolume)
+		v1.DELETE("/volumes/:name", authorize(rbac.Delete, "volumes", name), zfsHandler.HandleDeleteVolume)
+		v1.POST("/volumes/:name/clone", authorize(rbac.Create, "volumes", name, rbac.Body("name"), props), zfsHandler.HandleCloneVolume)
+
+		v1.GET("/filesystems", authorize(rbac.List, "filesystems", rbac.Query("filter")), zfsHandler.HandleListFilesystems)
+		v1.POST("/filesystems", authorize(rbac.Create, "filesystems", rbac.Body("name"), props), zfsHandler.HandleCreateFilesystem)
+		v1.GET("/filesystems/:name", authorize(rbac.Get, "filesystems", name), zfsHandler.HandleGetFilesystem)
+		v1.PATCH("/filesystems/:name", authorize(rbac.Update, "filesystems", name, props), zfsHandler.HandleUpdateFilesystem)
+		v1.DELETE("/filesystems/:name", authorize(rbac.Delete, "filesystems", name), zfsHandler.HandleDeleteFilesystem)
+		v1.POST("/filesystems/:name/mount", authorize(rbac.Update, "filesystems", name), zfsHandler.HandleMountFilesystem)
+		v1.POST("/filesystems/:name/unmount", authorize(rbac.Update, "filesystems", name), zfsHandler.HandleUnmountFilesystem)
+
+		v1.GET("/snapshots", authorize(rbac.List, "snapshots", rbac.Query("snapshot_id", "source_volume_id")), zfsHandler.HandleListSnapshots)
+		v1.POST("/snapshots", authorize(rbac.Create, "snapshots", rbac.Body("source_volume_id")), zfsHandler.HandleCreateSnapshot)
+		v1.DELETE("/snapshots/:name", authorize(rbac.Delete, "snapshots", name), zfsHandler.HandleDeleteSnapshot)
+		v1.POST("/snapshots/:name/rollback", authorize(rbac.Update, "snapshots", name), zfsHandler.HandleRollbackSnapshot)
+		v1.POST("/snapshots/:name/clone", authorize(rbac.Create, "snapshots", name, rbac.Body("name"), props), zfsHandler.HandleCloneSnapshot)
+		v1.GET("/snapshots/:name/stream", authorize(rbac.Get, "snapshots", name), zfsHandler.HandleSendSnapshot)
+
+		v1.PUT("/datasets/:name/receive", authorize(rbac.Create, "datasets", name), zfsHandler.HandleReceiveSnapshot)
+		v1.GET("/datasets/:name/resume_token", authorize(rbac.Get, "datasets", name), zfsHandler.HandleGetResumeToken)
+
+		v1.GET("/exports", authorize(rbac.List, "exports", rbac.Query("filter")), zfsHandler.HandleListExports)
+		v1.POST("/exports", authorize(rbac.Create, "exports", rbac.Body("name"), props), zfsHandler.HandleCreateExport)
+		v1.DELETE("/exports/:name", authorize(rbac.Delete, "exports", name), zfsHandler.HandleDeleteExport)
+
+		v1.GET("/space", authorize(rbac.Get, "space", rbac.Query("root")), zfsHandler.HandleSpaceReport)
+
+		v1.GET("/pools", authorize(rbac.List, "pools", rbac.Query()), zfsHandler.HandleListPools)
+		v1.GET("/pools/:name", authorize(rbac.Get, "pools", name), zfsHandler.HandleGetPool)
+		v1.GET("/pools/:name/status", authorize(rbac.Get, "pools", name), zfsHandler.HandleGetPoolStatus)
+		v1.POST("/pools/:name/scrub", authorize(rbac.Update, "pools", name), zfsHandler.HandleScrubPool)
+
+		v1.GET("/jobs", authorize(rbac.List, "jobs"), zfsHandler.HandleListJobs)
+		v1.GET("/jobs/:id", authorize(rbac.Get, "jobs"), zfsHandler.HandleGetJob)
+		v1.POST("/jobs/:id/cancel", authorize(rbac.Update, "jobs"), zfsHandler.HandleCancelJob)
+
+		if auditLog != nil {
+			v1.GET("/audit", authorize(rbac.List, "audit"), auditLog.HandleQuery)
+		}
 	}
 
 	return route